@@ -19,24 +19,27 @@ import (
 )
 
 type app struct {
-	ui              *ui            // ui state (screen, windows, input)
-	nav             *nav           // navigation state (dirs, cursor, selections, preview, caches)
-	ticker          *time.Ticker   // refresh ticker if `period` > 0
-	quitChan        chan struct{}  // signals main loop to exit
-	cmd             *exec.Cmd      // currently running % (shell-pipe) command
-	cmdIn           io.WriteCloser // stdin writer for running % command
-	cmdOutBuf       []byte         // output of running % command
-	cmdHistory      []string       // command history entries
-	cmdHistoryBeg   int            // index where commands from this session start in cmdHistory
-	cmdHistoryInd   int            // history navigation offset from most recent
-	cmdHistoryInput *string        // initial input used as prefix filter while browsing history
-	menuCompActive  bool           // whether completion cycling is active
-	menuCompTmp     []string       // token snapshot taken when completion cycling starts, used for `cmd-menu-discard`
-	menuComps       []compMatch    // completion candidates for active prompt
-	menuCompInd     int            // index of selected completion candidate (-1: none selected)
-	selectionOut    []string       // paths to output on exit, used for `-print-selection` and `-selection-path`
-	watch           *watch         // fs watcher if `watch` is enabled
-	quitting        bool           // guard to prevent re-entering quit logic
+	ui              *ui               // ui state (screen, windows, input)
+	nav             *nav              // navigation state (dirs, cursor, selections, preview, caches)
+	ticker          *time.Ticker      // refresh ticker if `period` > 0
+	quitChan        chan struct{}     // signals main loop to exit
+	cmd             *exec.Cmd         // currently running % (shell-pipe) command
+	cmdIn           io.WriteCloser    // stdin writer for running % command
+	cmdOutBuf       []byte            // output of running % command
+	cmdHistory      []string          // command history entries
+	cmdHistoryBeg   int               // index where commands from this session start in cmdHistory
+	cmdHistoryInd   int               // history navigation offset from most recent
+	cmdHistoryInput *string           // initial input used as prefix filter while browsing history
+	menuCompActive  bool              // whether completion cycling is active
+	menuCompTmp     []string          // token snapshot taken when completion cycling starts, used for `cmd-menu-discard`
+	menuComps       []compMatch       // completion candidates for active prompt
+	menuCompInd     int               // index of selected completion candidate (-1: none selected)
+	selectionOut    []string          // paths to output on exit, used for `-print-selection` and `-selection-path`
+	watch           *watch            // fs watcher if `watch` is enabled
+	quitting        bool              // guard to prevent re-entering quit logic
+	conflicts       []*conflictPrompt // pending paste conflicts waiting for an answer
+	lastSelections  []string          // selections of the last `selection` event
+	lastMode        string            // mode of the last `mode` event
 }
 
 func newApp(ui *ui, nav *nav) *app {
@@ -304,6 +307,7 @@ func (app *app) loop() {
 	}
 
 	app.nav.addJumpList()
+	emitEvent("cd", map[string]any{"path": app.nav.currDir().path})
 
 	if gSelect != "" {
 		go func() {
@@ -319,6 +323,13 @@ func (app *app) loop() {
 	}
 
 	for {
+		// Conflicts are not prompted while the command line is in use, so
+		// that they do not discard what the user is typing.
+		if len(app.conflicts) > 0 && app.ui.cmdPrefix == "" {
+			app.promptConflict()
+			app.ui.draw(app.nav)
+		}
+
 		select {
 		case <-app.quitChan:
 			if app.nav.copyJobs > 0 {
@@ -343,6 +354,8 @@ func (app *app) loop() {
 			log.Printf("*************** closing client, PID: %d ***************", gClientID)
 
 			return
+		case c := <-app.nav.conflictChan:
+			app.conflicts = append(app.conflicts, c)
 		case n := <-app.nav.copyJobsChan:
 			app.nav.copyJobs += n
 			app.ui.draw(app.nav)
@@ -406,10 +419,12 @@ func (app *app) loop() {
 				d.filter = prev.filter
 				d.sort()
 				d.sel(prev.name(), app.nav.height)
+				app.nav.invalidateGit(d.path)
 			} else {
 				d.sort()
 			}
 			app.nav.dirCache[d.path] = d
+			app.nav.duUpdate(d)
 
 			app.nav.position()
 
@@ -467,11 +482,13 @@ func (app *app) loop() {
 				name := dir.name()
 				dir.sort()
 				dir.sel(name, app.nav.height)
+				app.nav.duUpdate(dir)
 			}
 
 			if r, ok := app.nav.regCache[f.path]; ok {
 				app.nav.checkReg(r)
 			}
+			app.nav.invalidateGit(filepath.Dir(f.path))
 			onLoad(app, []string{f.path})
 			app.ui.draw(app.nav)
 		case path := <-app.nav.delChan:
@@ -482,12 +499,60 @@ func (app *app) loop() {
 
 			deletePathRecursive(app.nav.regCache, path)
 			deletePathRecursive(app.nav.dirCache, path)
+			app.nav.invalidateGit(filepath.Dir(path))
 
 			if slices.Contains(app.nav.dirPaths, path) {
 				if err := app.nav.cd(filepath.Dir(path)); err != nil {
 					log.Print(err)
 				}
 			}
+		case b := <-app.nav.fuzzyChan:
+			if b.finder != app.nav.fuzzy {
+				continue
+			}
+			b.finder.add(b.paths, b.done)
+			if app.ui.cmdPrefix == "fuzzy-find: " {
+				app.listFuzzy()
+			}
+			app.ui.draw(app.nav)
+		case st := <-app.nav.gitChan:
+			repo, ok := app.nav.gitRepos[st.root]
+			if !ok {
+				continue
+			}
+			repo.status = st
+			repo.loading = false
+
+			// changes to the index and refs are only visible in the `.git` directory
+			if gOpts.watch {
+				gitDir := filepath.Join(st.root, ".git")
+				if fi, err := os.Stat(gitDir); err == nil && fi.IsDir() {
+					app.watch.add(gitDir)
+				}
+			}
+
+			app.ui.draw(app.nav)
+		case r := <-app.nav.duChan:
+			if r.scan != app.nav.du {
+				continue
+			}
+			if errors.Is(r.err, errJobCanceled) {
+				app.nav.stopDu()
+				app.ui.echo("du: canceled")
+			} else if err := app.nav.duDone(r); err != nil {
+				app.ui.echoerrf("du: %s", err)
+			} else if r.path == r.scan.root {
+				app.ui.echomsg("du: " + r.String())
+			}
+			app.ui.draw(app.nav)
+		case r := <-app.nav.compareChan:
+			if r.cmp != app.nav.comparison {
+				continue
+			}
+			r.cmp.states = r.states
+			r.cmp.loading = false
+			app.ui.echomsg("compare: " + r.String())
+			app.ui.draw(app.nav)
 		case ev := <-app.ui.evChan:
 			e := app.ui.readEvent(ev, app.nav)
 			if e == nil {
@@ -507,13 +572,19 @@ func (app *app) loop() {
 					break loop
 				}
 			}
+			app.checkEvents()
 			app.ui.draw(app.nav)
 		case e := <-app.ui.exprChan:
 			e.eval(app, nil)
+			app.checkEvents()
 			app.ui.draw(app.nav)
 		case e := <-serverChan:
 			e.eval(app, nil)
+			app.checkEvents()
 			app.ui.draw(app.nav)
+		case done := <-gStateReq:
+			app.saveState()
+			close(done)
 		case <-app.ticker.C:
 			app.nav.renew()
 			app.ui.loadFile(app, false)
@@ -549,6 +620,39 @@ func (app *app) runCmdSync(cmd *exec.Cmd, pauseAfter bool) {
 	app.nav.renew()
 }
 
+// promptConflict asks the user how to resolve the first pending paste conflict
+// unless the command line is already in use.
+func (app *app) promptConflict() {
+	if len(app.conflicts) == 0 || app.ui.cmdPrefix != "" {
+		return
+	}
+
+	name := filepath.Base(app.conflicts[0].path)
+	app.ui.cmdPrefix = "paste: '" + name + "' exists, [o]verwrite/[s]kip/[r]ename (uppercase for all)? "
+}
+
+// answerConflict resolves the first pending paste conflict and prompts for the
+// next one if there is any.
+func (app *app) answerConflict(action conflictPolicy, all bool) {
+	if len(app.conflicts) == 0 {
+		return
+	}
+
+	app.conflicts[0].answer <- conflictAnswer{action, all}
+	app.conflicts = app.conflicts[1:]
+	app.promptConflict()
+}
+
+// runPager shows the given text using the pager.
+func (app *app) runPager(s string) {
+	cmd := shellCommand(envPager, nil)
+	cmd.Stdin = strings.NewReader(s)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	app.runCmdSync(cmd, false)
+}
+
 // runShell is used to run a shell command. Modes are as follows:
 //
 //	Prefix  Wait  Async  Stdin  Stdout  Stderr  UI action
@@ -563,25 +667,7 @@ func (app *app) runShell(s string, args []string, prefix string) {
 	exportLfPath()
 	exportOpts()
 
-	gState.mutex.Lock()
-	gState.data["maps"] = listBinds(map[string]map[string]expr{
-		"n": gOpts.nkeys,
-		"v": gOpts.vkeys,
-	})
-	gState.data["nmaps"] = listBinds(map[string]map[string]expr{
-		"n": gOpts.nkeys,
-	})
-	gState.data["vmaps"] = listBinds(map[string]map[string]expr{
-		"v": gOpts.vkeys,
-	})
-	gState.data["cmaps"] = listBinds(map[string]map[string]expr{
-		"c": gOpts.cmdkeys,
-	})
-	gState.data["cmds"] = listCmds(gOpts.cmds)
-	gState.data["jumps"] = listJumps(app.nav.jumpList, app.nav.jumpListInd)
-	gState.data["history"] = listHistory(app.cmdHistory)
-	gState.data["files"] = listFilesInCurrDir(app.nav)
-	gState.mutex.Unlock()
+	app.saveState()
 
 	cmd := shellCommand(s, args)
 
@@ -727,6 +813,12 @@ func (app *app) watchDir(dir *dir) {
 		return
 	}
 
+	// directories inside archives are reloaded when the archive is modified,
+	// and remote directories cannot be watched
+	if _, _, ok := splitArchive(dir.path); ok || isRemote(dir.path) {
+		return
+	}
+
 	app.watch.add(dir.path)
 
 	// ensure dircounts are updated for child directories
@@ -737,46 +829,50 @@ func (app *app) watchDir(dir *dir) {
 	}
 }
 
-func (app *app) exportMode() {
-	getMode := func() string {
-		if app.menuCompActive {
-			return "compmenu"
-		}
+func (app *app) mode() string {
+	if app.menuCompActive {
+		return "compmenu"
+	}
 
-		if strings.HasPrefix(app.ui.cmdPrefix, "delete") {
-			return "delete"
-		}
+	if strings.HasPrefix(app.ui.cmdPrefix, "delete") || strings.HasPrefix(app.ui.cmdPrefix, "trash-empty") {
+		return "delete"
+	}
 
-		if strings.HasPrefix(app.ui.cmdPrefix, "replace") || strings.HasPrefix(app.ui.cmdPrefix, "create") {
-			return "rename"
-		}
+	if strings.HasPrefix(app.ui.cmdPrefix, "paste: ") {
+		return "paste"
+	}
 
-		switch app.ui.cmdPrefix {
-		case "filter: ":
-			return "filter"
-		case "find: ", "find-back: ":
-			return "find"
-		case "mark-save: ", "mark-load: ", "mark-remove: ":
-			return "mark"
-		case "rename: ":
-			return "rename"
-		case "/", "?":
-			return "search"
-		case ":":
-			return "command"
-		case "$", "%", "!", "&":
-			return "shell"
-		case ">":
-			return "pipe"
-		case "":
-			if app.nav.isVisualMode() {
-				return "visual"
-			}
-			return "normal"
-		default:
-			return "unknown"
+	if strings.HasPrefix(app.ui.cmdPrefix, "replace") || strings.HasPrefix(app.ui.cmdPrefix, "create") || strings.HasPrefix(app.ui.cmdPrefix, "bulk-rename") {
+		return "rename"
+	}
+
+	switch app.ui.cmdPrefix {
+	case "filter: ":
+		return "filter"
+	case "find: ", "find-back: ", "fuzzy-find: ":
+		return "find"
+	case "mark-save: ", "mark-load: ", "mark-remove: ":
+		return "mark"
+	case "rename: ", "rename-pattern: ":
+		return "rename"
+	case "/", "?":
+		return "search"
+	case ":":
+		return "command"
+	case "$", "%", "!", "&":
+		return "shell"
+	case ">":
+		return "pipe"
+	case "":
+		if app.nav.isVisualMode() {
+			return "visual"
 		}
+		return "normal"
+	default:
+		return "unknown"
 	}
+}
 
-	os.Setenv("lf_mode", getMode())
+func (app *app) exportMode() {
+	os.Setenv("lf_mode", app.mode())
 }
@@ -0,0 +1,868 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archives can be browsed as read-only virtual directories, where the members
+// of an archive are listed below the path of the archive itself (e.g.
+// `/path/to/a.zip/dir/file`). Since these paths do not exist on disk, the
+// working directory is set to the directory containing the archive instead.
+var gArchiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2"}
+
+var errArchiveReadOnly = errors.New("archives are read-only")
+
+// isArchive reports whether the given path has the extension of a supported
+// archive format.
+func isArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range gArchiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isArchiveFile reports whether the given file is an archive on disk that can
+// be opened as a directory.
+func isArchiveFile(f *file) bool {
+	if _, ok := f.FileInfo.(*archiveStat); ok {
+		return false
+	}
+	return f.err == nil && f.Mode().IsRegular() && isArchive(f.path) && !isRemote(f.path)
+}
+
+// splitArchive splits a path inside an archive into the path of the archive
+// and the slash separated path of the member, which is empty for the archive
+// itself. The result is false for paths that are not inside archives, which
+// includes archives on remote hosts.
+func splitArchive(p string) (archive, member string, ok bool) {
+	if isRemote(p) {
+		return "", "", false
+	}
+	for d := p; ; d = filepath.Dir(d) {
+		if isArchive(d) {
+			if s, err := os.Stat(d); err == nil && s.Mode().IsRegular() {
+				rel, err := filepath.Rel(d, p)
+				if err != nil || rel == "." {
+					rel = ""
+				}
+				return d, filepath.ToSlash(rel), true
+			}
+		}
+		if d == filepath.Dir(d) {
+			return "", "", false
+		}
+	}
+}
+
+// isArchiveMember reports whether the given path is a member inside an
+// archive.
+func isArchiveMember(p string) bool {
+	_, member, ok := splitArchive(p)
+	return ok && member != ""
+}
+
+// diskPath returns the path of the archive for paths inside archives, and the
+// path itself otherwise.
+func diskPath(p string) string {
+	if archive, _, ok := splitArchive(p); ok {
+		return archive
+	}
+	return p
+}
+
+// chdir changes the working directory to the given path, or to the directory
+// containing the archive for paths inside archives. The working directory is
+// kept for remote paths, which are only checked to be directories.
+func chdir(p string) error {
+	if isRemote(p) {
+		s, err := getFS(p).Stat(p)
+		if err != nil {
+			return err
+		}
+		if !s.IsDir() {
+			return fmt.Errorf("not a directory: %s", p)
+		}
+		return nil
+	}
+	if archive, _, ok := splitArchive(p); ok {
+		p = filepath.Dir(archive)
+	}
+	return os.Chdir(p)
+}
+
+type archiveStat struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (as *archiveStat) Name() string       { return as.name }
+func (as *archiveStat) Size() int64        { return as.size }
+func (as *archiveStat) Mode() os.FileMode  { return as.mode }
+func (as *archiveStat) ModTime() time.Time { return as.modTime }
+func (as *archiveStat) IsDir() bool        { return as.mode.IsDir() }
+func (as *archiveStat) Sys() any           { return nil }
+
+// archiveMember is a member of an archive. The contents of members in tar
+// archives can only be read while walking the archive.
+type archiveMember struct {
+	name   string // slash separated path inside the archive
+	info   *archiveStat
+	target string // target of symbolic links in tar archives
+	open   func() (io.ReadCloser, error)
+}
+
+// walkArchive calls fn for each member of the archive in the order they are
+// stored. Members with unsafe paths and unsupported types are skipped. Walking
+// stops without an error when fn returns [fs.SkipAll].
+func walkArchive(archive string, fn func(m *archiveMember) error) error {
+	err := walkArchiveMembers(archive, func(m *archiveMember) error {
+		m.name = path.Clean(strings.TrimSuffix(m.name, "/"))
+		if !fs.ValidPath(m.name) || m.name == "." {
+			return nil
+		}
+		m.info.name = path.Base(m.name)
+		return fn(m)
+	})
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func walkArchiveMembers(archive string, fn func(m *archiveMember) error) error {
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		r, err := zip.OpenReader(archive)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		for _, f := range r.File {
+			mode := f.Mode()
+			if !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
+				continue
+			}
+			m := &archiveMember{
+				name: f.Name,
+				info: &archiveStat{size: int64(f.UncompressedSize64), mode: mode, modTime: f.Modified},
+				open: f.Open,
+			}
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch lower := strings.ToLower(archive); {
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(lower, ".tar.bz2") || strings.HasSuffix(lower, ".tbz2"):
+		r = bzip2.NewReader(f)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue
+		}
+
+		m := &archiveMember{
+			name:   hdr.Name,
+			info:   &archiveStat{size: hdr.Size, mode: hdr.FileInfo().Mode(), modTime: hdr.ModTime},
+			target: hdr.Linkname,
+			open:   func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveIndex is the list of members of an archive, which is kept until the
+// archive is modified.
+type archiveIndex struct {
+	modTime time.Time
+	members map[string]*archiveMember // members by their path including implicit parent directories
+}
+
+var gArchives = struct {
+	sync.Mutex
+	indexes map[string]*archiveIndex
+}{indexes: make(map[string]*archiveIndex)}
+
+func loadArchiveIndex(archive string) (*archiveIndex, error) {
+	s, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	gArchives.Lock()
+	idx, ok := gArchives.indexes[archive]
+	gArchives.Unlock()
+	if ok && idx.modTime.Equal(s.ModTime()) {
+		return idx, nil
+	}
+
+	idx = &archiveIndex{modTime: s.ModTime(), members: make(map[string]*archiveMember)}
+	err = walkArchive(archive, func(m *archiveMember) error {
+		m.open = nil
+		idx.members[m.name] = m
+		for d := path.Dir(m.name); d != "."; d = path.Dir(d) {
+			if _, ok := idx.members[d]; ok {
+				break
+			}
+			idx.members[d] = &archiveMember{
+				name: d,
+				info: &archiveStat{name: path.Base(d), mode: os.ModeDir | 0o755, modTime: s.ModTime()},
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gArchives.Lock()
+	gArchives.indexes[archive] = idx
+	gArchives.Unlock()
+
+	return idx, nil
+}
+
+// readdirArchive lists the members of the directory at the given path inside
+// an archive.
+func readdirArchive(p string) ([]*file, error) {
+	archive, dir, ok := splitArchive(p)
+	if !ok {
+		return nil, fmt.Errorf("not an archive: %s", p)
+	}
+
+	idx, err := loadArchiveIndex(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		dir = "."
+	} else if m, ok := idx.members[dir]; !ok || !m.info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+
+	counts := make(map[string]int)
+	var members []*archiveMember
+	for name, m := range idx.members {
+		parent := path.Dir(name)
+		counts[parent]++
+		if parent == dir {
+			members = append(members, m)
+		}
+	}
+
+	files := make([]*file, 0, len(members))
+	for _, m := range members {
+		dirCount := -1
+		if m.info.IsDir() {
+			dirCount = counts[m.name]
+		}
+		files = append(files, &file{
+			FileInfo:   m.info,
+			linkTarget: m.target,
+			path:       filepath.Join(p, m.info.name),
+			dirCount:   dirCount,
+			dirSize:    -1,
+			accessTime: m.info.modTime,
+			birthTime:  m.info.modTime,
+			changeTime: m.info.modTime,
+			ext:        getFileExtension(m.info),
+		})
+	}
+
+	return files, nil
+}
+
+// openArchiveMember returns the contents of the file at the given path inside
+// an archive.
+func openArchiveMember(p string) (io.ReadCloser, error) {
+	archive, member, ok := splitArchive(p)
+	if !ok || member == "" {
+		return nil, fmt.Errorf("not an archive member: %s", p)
+	}
+
+	pr, pw := io.Pipe()
+	found := make(chan error, 1)
+
+	// Members of tar archives can only be read while walking, so the contents
+	// are streamed through a pipe.
+	go func() {
+		sent := false
+		err := walkArchive(archive, func(m *archiveMember) error {
+			if m.name != member {
+				return nil
+			}
+			if !m.info.Mode().IsRegular() {
+				return fmt.Errorf("not a regular file: %s", p)
+			}
+			r, err := m.open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			sent = true
+			found <- nil
+			_, err = io.Copy(pw, r)
+			pw.CloseWithError(err)
+			return fs.SkipAll
+		})
+		if sent {
+			return
+		}
+		if err == nil {
+			err = &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+		}
+		found <- err
+	}()
+
+	if err := <-found; err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// archiveSize returns the total size of the members at or below the given
+// path inside an archive.
+func archiveSize(p string) (int64, error) {
+	archive, member, ok := splitArchive(p)
+	if !ok {
+		return 0, fmt.Errorf("not an archive: %s", p)
+	}
+
+	idx, err := loadArchiveIndex(archive)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for name, m := range idx.members {
+		if member == "" || name == member || strings.HasPrefix(name, member+"/") {
+			total += m.info.Size()
+		}
+	}
+	return total, nil
+}
+
+// extractArchive extracts the member at the given path inside an archive into
+// dstDir, along with the members below it for directories, or all members for
+// the archive itself. Conflicts with existing files are resolved according to
+// the conflict policy of the job.
+func extractArchive(src, dstDir string, j *job, nums chan<- int64, errs chan<- error) error {
+	archive, member, ok := splitArchive(src)
+	if !ok {
+		return fmt.Errorf("not an archive: %s", src)
+	}
+
+	// members are only written below the resolved destination directory so
+	// that symbolic links in the archive cannot redirect them elsewhere
+	root, err := filepath.EvalSymlinks(dstDir)
+	if err != nil {
+		return err
+	}
+
+	base := path.Base(member)
+	renamed := make(map[string]string)
+
+	err = walkArchive(archive, func(m *archiveMember) error {
+		if err := j.wait(); err != nil {
+			return err
+		}
+
+		rel := m.name
+		if member != "" {
+			r, ok := strings.CutPrefix(m.name, member)
+			if !ok || r != "" && !strings.HasPrefix(r, "/") {
+				return nil
+			}
+			rel = base + r
+		}
+
+		// renamed directories apply to the members below them
+		dst := filepath.Join(dstDir, filepath.FromSlash(rel))
+		for d := rel; d != "."; d = path.Dir(d) {
+			if r, ok := renamed[d]; ok {
+				dst = filepath.Join(r, filepath.FromSlash(strings.TrimPrefix(rel, d)))
+				break
+			}
+		}
+
+		if !withinDir(root, filepath.Dir(dst)) {
+			errs <- fmt.Errorf("extract: %s: path leaves the destination directory", m.name)
+			return nil
+		}
+
+		if lstat, err := os.Lstat(dst); err == nil && !(m.info.IsDir() && lstat.IsDir()) {
+			switch j.resolve(m.info, lstat, dst) {
+			case conflictSkip:
+				nums <- m.info.Size()
+				return nil
+			case conflictRename:
+				newDst := dupPath(dst)
+				if m.info.IsDir() {
+					renamed[rel] = newDst
+				}
+				dst = newDst
+			case conflictOverwrite:
+				if m.info.IsDir() || lstat.IsDir() {
+					errs <- fmt.Errorf("cannot overwrite %s: file type mismatch", dst)
+					return nil
+				}
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			errs <- fmt.Errorf("mkdir: %w", err)
+			return nil
+		}
+
+		switch {
+		case m.info.IsDir():
+			if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+				errs <- fmt.Errorf("mkdir: %w", err)
+			}
+		case m.info.Mode()&os.ModeSymlink != 0:
+			target := m.target
+			if target == "" {
+				r, err := m.open()
+				if err != nil {
+					errs <- fmt.Errorf("symlink: %w", err)
+					return nil
+				}
+				b, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					errs <- fmt.Errorf("symlink: %w", err)
+					return nil
+				}
+				target = string(b)
+			}
+			// the target is not cleaned before resolving its links
+			link := filepath.Dir(dst) + string(filepath.Separator) + filepath.FromSlash(target)
+			if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" || !withinDir(root, link) {
+				errs <- fmt.Errorf("symlink: %s: target leaves the destination directory", m.name)
+				return nil
+			}
+			os.Remove(dst)
+			if err := os.Symlink(target, dst); err != nil {
+				errs <- fmt.Errorf("symlink: %w", err)
+			}
+		default:
+			if err := extractFile(m, dst, j, nums); err != nil {
+				if errors.Is(err, errJobCanceled) {
+					return err
+				}
+				errs <- err
+				return nil
+			}
+		}
+
+		return nil
+	})
+	if errors.Is(err, errJobCanceled) {
+		return err
+	}
+	if err != nil {
+		errs <- fmt.Errorf("extract: %w", err)
+	}
+	return nil
+}
+
+// withinDir reports whether the path is inside root after resolving symbolic
+// links in its existing part, where root should already be resolved. Paths are
+// resolved one component at a time, since `..` after a symbolic link refers to
+// the parent of its target. Paths with links that cannot be resolved are never
+// inside root.
+func withinDir(root, p string) bool {
+	vol := filepath.VolumeName(p)
+	curr := vol + string(filepath.Separator)
+	for _, name := range strings.Split(p[len(vol):], string(filepath.Separator)) {
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			curr = filepath.Dir(curr)
+			continue
+		}
+
+		curr = filepath.Join(curr, name)
+		lstat, err := os.Lstat(curr)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false
+		}
+		if lstat.Mode()&os.ModeSymlink != 0 {
+			if curr, err = filepath.EvalSymlinks(curr); err != nil {
+				return false
+			}
+		}
+	}
+
+	rel, err := filepath.Rel(root, curr)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// extractFile writes the contents of an archive member to a temporary file
+// next to dst, which is renamed to dst only after it is complete.
+func extractFile(m *archiveMember, dst string, j *job, nums chan<- int64) error {
+	r, err := m.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, tmp, err := createTemp(localFS{}, dst, m.info.Mode().Perm()|0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(NewProgressWriter(w, nums), &jobReader{r, j}); err != nil {
+		w.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Chtimes(dst, m.info.ModTime(), m.info.ModTime()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// canCreateArchive reports whether archives can be created with the extension
+// of the given path, as bzip2 is only supported for reading.
+func canCreateArchive(p string) bool {
+	lower := strings.ToLower(p)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveWriter adds files to an archive, where the names of directories end
+// with a slash.
+type archiveWriter interface {
+	add(name string, info os.FileInfo, target string, r io.Reader) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (w *tarWriter) add(name string, info os.FileInfo, target string, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(w.tw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) add(name string, info os.FileInfo, target string, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.Mode().IsRegular() {
+		hdr.Method = zip.Deflate
+	}
+
+	fw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	// symbolic links are stored with their target as the contents
+	if target != "" {
+		_, err = io.WriteString(fw, target)
+		return err
+	}
+	if r != nil {
+		_, err = io.Copy(fw, r)
+	}
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// createArchive writes the given files and directories recursively to a new
+// archive at dst, where the format is chosen by the extension of dst. Members
+// are named relative to the directories containing the sources. The archive is
+// written to a temporary file next to dst, which is renamed to dst only after
+// it is complete.
+func createArchive(srcs []string, dst string, j *job, nums chan<- int64, errs chan<- error) error {
+	f, tmp, err := createTemp(localFS{}, dst, 0o666)
+	if err != nil {
+		return err
+	}
+
+	var w archiveWriter
+	switch lower := strings.ToLower(dst); {
+	case strings.HasSuffix(lower, ".zip"):
+		w = &zipWriter{zip.NewWriter(f)}
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		gz := gzip.NewWriter(f)
+		w = &tarWriter{tar.NewWriter(gz), gz}
+	default:
+		w = &tarWriter{tar.NewWriter(f), nil}
+	}
+
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	for _, src := range srcs {
+		j.next(src)
+
+		base := filepath.Dir(src)
+		err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err := j.wait(); err != nil {
+				return err
+			}
+			if err != nil {
+				errs <- fmt.Errorf("walk: %w", err)
+				return nil
+			}
+
+			// the archive being written is skipped when it is inside a source
+			if p == tmp {
+				return nil
+			}
+
+			rel, err := filepath.Rel(base, p)
+			if err != nil {
+				errs <- fmt.Errorf("relative: %w", err)
+				return nil
+			}
+			name := filepath.ToSlash(rel)
+
+			switch {
+			case info.IsDir():
+				nums <- info.Size()
+				return w.add(name+"/", info, "", nil)
+			case info.Mode()&os.ModeSymlink != 0:
+				target, err := os.Readlink(p)
+				if err != nil {
+					errs <- fmt.Errorf("symlink: %w", err)
+					return nil
+				}
+				nums <- info.Size()
+				return w.add(name, info, target, nil)
+			case info.Mode().IsRegular():
+				r, err := os.Open(p)
+				if err != nil {
+					errs <- err
+					return nil
+				}
+				defer r.Close()
+				return w.add(name, info, "", io.TeeReader(&jobReader{r, j}, NewProgressWriter(io.Discard, nums)))
+			default:
+				nums <- info.Size()
+				return nil
+			}
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// archiveAsync creates an archive at dst from the given files in the
+// background.
+func (nav *nav) archiveAsync(app *app, srcs []string, dst string) {
+	nav.transferAsync(app, "archive", "Archived successfully", srcs, filepath.Dir(dst),
+		func() (int64, error) { return copySize(srcs) },
+		func(j *job, nums chan<- int64, errs chan<- error) error {
+			return createArchive(srcs, dst, j, nums, errs)
+		})
+}
+
+// extractAsync extracts all members of the given archive into dstDir in the
+// background, creating dstDir if it does not exist.
+func (nav *nav) extractAsync(app *app, archive, dstDir string) {
+	nav.transferAsync(app, "extract", "Extracted successfully", []string{archive}, dstDir,
+		func() (int64, error) { return archiveSize(archive) },
+		func(j *job, nums chan<- int64, errs chan<- error) error {
+			if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
+				return err
+			}
+			return extractArchive(archive, dstDir, j, nums, errs)
+		})
+}
+
+// transferAsync runs a job writing files in the background, where the
+// progress is shown with the same counters as copying files.
+func (nav *nav) transferAsync(app *app, kind, done string, srcs []string, dstDir string, size func() (int64, error), run func(j *job, nums chan<- int64, errs chan<- error) error) {
+	errCount := 0
+	sendErr := func(format string, a ...any) {
+		errCount++
+		msg := fmt.Sprintf("%s [%d]: %s", kind, errCount, fmt.Sprintf(format, a...))
+		app.ui.exprChan <- &callExpr{"echoerr", []string{msg}, 1}
+	}
+
+	j := nav.startJob(kind, srcs, dstDir, gOpts.pasteconflict)
+	defer nav.jobs.remove(j)
+
+	nav.copyJobsChan <- 1
+
+	total, err := size()
+	if err != nil {
+		sendErr("%v", err)
+		nav.copyJobsChan <- -1
+		return
+	}
+
+	nav.copyTotalChan <- total
+
+	nums := make(chan int64, 1024)
+	errs := make(chan error, 1024)
+	go func() {
+		if err := run(j, nums, errs); err != nil && !errors.Is(err, errJobCanceled) {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	var copied int64
+loop:
+	for {
+		select {
+		case n := <-nums:
+			copied += n
+			nav.copyBytesChan <- n
+		case err, ok := <-errs:
+			if !ok {
+				break loop
+			}
+			sendErr("%v", err)
+		}
+	}
+
+	if copied < total {
+		nav.copyBytesChan <- total - copied
+	}
+
+	nav.copyJobsChan <- -1
+	nav.copyTotalChan <- -total
+
+	if gSingleMode {
+		nav.renew()
+		app.ui.loadFile(app, true)
+	} else {
+		if _, err := remote("send load"); err != nil {
+			sendErr("%v", err)
+		}
+	}
+
+	if j.canceled() {
+		app.ui.exprChan <- &callExpr{"echo", []string{fmt.Sprintf("%s: job %d canceled", kind, j.id)}, 1}
+	} else if errCount == 0 {
+		app.ui.exprChan <- &callExpr{"echo", []string{"\033[0;32m" + done + "\033[0m"}, 1}
+	}
+}
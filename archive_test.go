@@ -0,0 +1,342 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// archiveTestFiles are written to the test archives, where the parent
+// directories are not stored explicitly.
+var archiveTestFiles = []struct {
+	name    string
+	content string
+}{
+	{"a.txt", "a\n"},
+	{"d/b.txt", "b\n"},
+	{"d/e/c.txt", "c\n"},
+	{"../escape.txt", "x\n"},
+}
+
+func writeTestZip(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, tf := range archiveTestFiles {
+		fw, err := w.Create(tf.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, tf.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	w := tar.NewWriter(gz)
+	for _, tf := range archiveTestFiles {
+		hdr := &tar.Header{Name: tf.name, Mode: 0o644, Size: int64(len(tf.content)), Typeflag: tar.TypeReg}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, tf.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteHeader(&tar.Header{Name: "l", Linkname: "a.txt", Typeflag: tar.TypeSymlink}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReaddirArchive(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "x.zip")
+	tarPath := filepath.Join(dir, "x.tar.gz")
+	writeTestZip(t, zipPath)
+	writeTestTarGz(t, tarPath)
+
+	tests := []struct {
+		path string
+		exp  []string
+	}{
+		{zipPath, []string{"a.txt", "d"}},
+		{filepath.Join(zipPath, "d"), []string{"b.txt", "e"}},
+		{filepath.Join(zipPath, "d", "e"), []string{"c.txt"}},
+		{tarPath, []string{"a.txt", "d", "l"}},
+		{filepath.Join(tarPath, "d", "e"), []string{"c.txt"}},
+	}
+
+	for _, test := range tests {
+		files, err := readdirArchive(test.path)
+		if err != nil {
+			t.Fatalf("unexpected error at input '%s': %s", test.path, err)
+		}
+
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Name())
+			if f.path != filepath.Join(test.path, f.Name()) {
+				t.Errorf("at input '%s' expected path '%s' but got '%s'", test.path, filepath.Join(test.path, f.Name()), f.path)
+			}
+		}
+		slices.Sort(got)
+
+		if !slices.Equal(got, test.exp) {
+			t.Errorf("at input '%s' expected '%v' but got '%v'", test.path, test.exp, got)
+		}
+	}
+
+	if _, err := readdirArchive(filepath.Join(zipPath, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing directory but got '%v'", err)
+	}
+}
+
+func TestSplitArchive(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "x.zip")
+	writeTestZip(t, zipPath)
+	if err := os.Mkdir(filepath.Join(dir, "y.zip"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		archive string
+		member  string
+		ok      bool
+	}{
+		{zipPath, zipPath, "", true},
+		{filepath.Join(zipPath, "d", "b.txt"), zipPath, "d/b.txt", true},
+		{filepath.Join(dir, "y.zip", "z"), "", "", false},
+		{dir, "", "", false},
+	}
+
+	for _, test := range tests {
+		archive, member, ok := splitArchive(test.path)
+		if archive != test.archive || member != test.member || ok != test.ok {
+			t.Errorf("at input '%s' expected ('%s', '%s', %t) but got ('%s', '%s', %t)",
+				test.path, test.archive, test.member, test.ok, archive, member, ok)
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "x.tar.gz")
+	writeTestTarGz(t, tarPath)
+
+	dst := filepath.Join(dir, "out")
+	if err := os.Mkdir(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	nums := make(chan int64, 1024)
+	errs := make(chan error, 1024)
+	for _, member := range []string{"d", "l"} {
+		if err := extractArchive(filepath.Join(tarPath, member), dst, nil, nums, errs); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	for path, content := range map[string]string{"d/b.txt": "b\n", "d/e/c.txt": "c\n"} {
+		b, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(path)))
+		if err != nil {
+			t.Errorf("reading extracted file: %s", err)
+		} else if string(b) != content {
+			t.Errorf("at input '%s' expected '%q' but got '%q'", path, content, b)
+		}
+	}
+
+	if target, err := os.Readlink(filepath.Join(dst, "l")); err != nil || target != "a.txt" {
+		t.Errorf("expected link to 'a.txt' but got '%s' (%v)", target, err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected unsafe member to be skipped")
+	}
+}
+
+// writeTestTar writes a tar archive with the given members, where regular
+// files contain their size in bytes of 'x'.
+func writeTestTar(t *testing.T, path string, members []*tar.Header) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := tar.NewWriter(f)
+	for _, hdr := range members {
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, strings.Repeat("x", int(hdr.Size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchiveLinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		members []*tar.Header
+	}{
+		{"absolute", []*tar.Header{
+			{Name: "evil", Linkname: outside, Typeflag: tar.TypeSymlink},
+			{Name: "evil/pwned", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg},
+		}},
+		{"parent", []*tar.Header{
+			{Name: "up", Linkname: "../outside", Typeflag: tar.TypeSymlink},
+			{Name: "up/pwned", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg},
+		}},
+		{"dot", []*tar.Header{
+			{Name: "s", Linkname: ".", Typeflag: tar.TypeSymlink},
+			{Name: "t", Linkname: "s/../outside", Typeflag: tar.TypeSymlink},
+			{Name: "t/pwned", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg},
+		}},
+	}
+
+	for _, test := range tests {
+		tarPath := filepath.Join(dir, test.name+".tar")
+		writeTestTar(t, tarPath, test.members)
+
+		dst := filepath.Join(dir, test.name)
+		if err := os.Mkdir(dst, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		nums := make(chan int64, 1024)
+		errs := make(chan error, 1024)
+		if err := extractArchive(tarPath, dst, nil, nums, errs); err != nil {
+			t.Fatalf("at input '%s' unexpected error: %s", test.name, err)
+		}
+		close(errs)
+		if len(errs) == 0 {
+			t.Errorf("at input '%s' expected errors for unsafe members", test.name)
+		}
+
+		if _, err := os.Lstat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+			t.Errorf("at input '%s' expected no file written outside the destination", test.name)
+		}
+	}
+}
+
+func TestCreateArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src dir")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "f.txt"), []byte("f\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.zip", "a.tar", "a.tar.gz"} {
+		dst := filepath.Join(dir, name)
+
+		nums := make(chan int64, 1024)
+		errs := make(chan error, 1024)
+		if err := createArchive([]string{src}, dst, nil, nums, errs); err != nil {
+			t.Fatalf("unexpected error at input '%s': %s", name, err)
+		}
+		close(errs)
+		for err := range errs {
+			t.Errorf("unexpected error at input '%s': %s", name, err)
+		}
+
+		files, err := readdirArchive(filepath.Join(dst, "src dir", "sub"))
+		if err != nil {
+			t.Fatalf("unexpected error at input '%s': %s", name, err)
+		}
+		if len(files) != 1 || files[0].Name() != "f.txt" || files[0].Size() != 2 {
+			t.Errorf("at input '%s' expected 'f.txt' in archive but got '%v'", name, files)
+		}
+	}
+}
+
+func TestExtractAsync(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tarPath := filepath.Join(dir, "x.tar")
+	writeTestTar(t, tarPath, []*tar.Header{
+		{Name: "evil", Linkname: outside, Typeflag: tar.TypeSymlink},
+		{Name: "evil/pwned", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg},
+		{Name: "ok.txt", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg},
+	})
+
+	// the server is not running, so that reloading clients fails silently
+	socketPath := gSocketPath
+	gSocketPath = filepath.Join(dir, "lf.sock")
+	defer func() { gSocketPath = socketPath }()
+
+	app := &app{ui: &ui{exprChan: make(chan expr, 1024)}}
+	app.nav = &nav{
+		copyJobsChan:  make(chan int, 1024),
+		copyBytesChan: make(chan int64, 1024),
+		copyTotalChan: make(chan int64, 1024),
+	}
+
+	dst := filepath.Join(dir, "out")
+	app.nav.extractAsync(app, tarPath, dst)
+	close(app.ui.exprChan)
+
+	var unsafe bool
+	for e := range app.ui.exprChan {
+		if e, ok := e.(*callExpr); ok && e.name == "echoerr" && strings.Contains(e.args[0], "leaves the destination directory") {
+			unsafe = true
+		}
+	}
+	if !unsafe {
+		t.Errorf("expected error for unsafe members")
+	}
+
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("expected no file written outside the destination")
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, "ok.txt")); err != nil || string(b) != "xx" {
+		t.Errorf("expected safe member to be extracted but got '%q' (%v)", b, err)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -17,14 +20,16 @@ import (
 )
 
 type State struct {
-	mutex sync.Mutex
-	data  map[string]string
+	mutex  sync.Mutex
+	data   map[string]string
+	values map[string]any // for queries of the JSON protocol
 }
 
 var gState State
 
 func init() {
 	gState.data = make(map[string]string)
+	gState.values = make(map[string]any)
 }
 
 func run() {
@@ -159,7 +164,12 @@ func readExpr() <-chan expr {
 			c, err = net.Dial("unix", gSocketPath)
 		}
 
-		if _, err := fmt.Fprintf(c, "conn %d\n", gClientID); err != nil {
+		info, err := json.Marshal(newClientInfo())
+		if err != nil {
+			log.Printf("encoding client info: %s", err)
+		}
+
+		if _, err := fmt.Fprintf(c, "conn %d %s\n", gClientID, info); err != nil {
 			log.Printf("registering with server: %s", err)
 			return
 		}
@@ -175,15 +185,23 @@ func readExpr() <-chan expr {
 			// blocked when running a synchronous shell command ("$" or "!").
 			// This is important since `query` is often the result of the user
 			// running `$lf -remote "query $id <something>"`.
-			if word, rest := splitWord(s.Text()); word == "query" {
-				gState.mutex.Lock()
-				state := gState.data[rest]
-				gState.mutex.Unlock()
+			switch word, rest := splitWord(s.Text()); word {
+			case "query":
+				refreshState()
+				state, _, _ := lookupState(rest)
 				if _, err := fmt.Fprintln(c, state); err != nil {
 					log.Printf("sending response to server: %s", err)
 					return
 				}
-			} else {
+			case "query-json":
+				refreshState()
+				if _, err := c.Write(queryState(rest)); err != nil {
+					log.Printf("sending response to server: %s", err)
+					return
+				}
+			case "subscribed":
+				setEvents(strings.Fields(rest))
+			default:
 				p := newParser(strings.NewReader(s.Text()))
 				if p.parse() {
 					ch <- p.expr
@@ -202,14 +220,38 @@ func readExpr() <-chan expr {
 }
 
 func remote(req string) (string, error) {
-	c, err := net.Dial("unix", gSocketPath)
+	c, err := remoteConn(req)
 	if err != nil {
-		return "", fmt.Errorf("connecting to server: %w", err)
+		return "", err
 	}
 	defer c.Close()
 
+	resp, err := io.ReadAll(c)
+	if err != nil {
+		return "", fmt.Errorf("reading response from server: %w", err)
+	}
+
+	return string(resp), nil
+}
+
+// remoteConn sends a command to the server and returns the connection to read
+// the response, which is used directly for responses streamed by the server.
+func remoteConn(req string) (net.Conn, error) {
+	c, err := dialServer()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to server: %w", err)
+	}
+
+	if gConnectAddr != "" {
+		if req, err = authRequest(c, req); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
 	if _, err := fmt.Fprintln(c, req); err != nil {
-		return "", fmt.Errorf("sending command to server: %w", err)
+		c.Close()
+		return nil, fmt.Errorf("sending command to server: %w", err)
 	}
 
 	// XXX: Standard net.Conn interface does not include a CloseWrite method
@@ -222,12 +264,60 @@ func remote(req string) (string, error) {
 		c.CloseWrite()
 	case *net.UnixConn:
 		c.CloseWrite()
+	case *tls.Conn:
+		c.CloseWrite()
 	}
 
-	resp, err := io.ReadAll(c)
+	return c, nil
+}
+
+// dialServer connects to the server over the socket, or over TCP when the
+// `-connect` address is given.
+func dialServer() (net.Conn, error) {
+	if gConnectAddr == "" {
+		return net.Dial("unix", gSocketPath)
+	}
+
+	if !gTLS {
+		return net.Dial("tcp", gConnectAddr)
+	}
+
+	// certificates in the config directory are trusted for self-signed
+	// servers, otherwise the system certificates are used
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if b, err := os.ReadFile(gCertPath); err == nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", gCertPath)
+		}
+	}
+	return tls.Dial("tcp", gConnectAddr, config)
+}
+
+// authRequest authenticates the connection with the token in the config
+// directory and returns the request to be sent afterwards. The token is added
+// to JSON requests, and sent with the `auth` command before other requests.
+func authRequest(c net.Conn, req string) (string, error) {
+	token, err := readToken()
 	if err != nil {
-		return "", fmt.Errorf("reading response from server: %w", err)
+		return "", err
 	}
 
-	return string(resp), nil
+	if !strings.HasPrefix(req, "{") {
+		if _, err := fmt.Fprintf(c, "auth %s\n", token); err != nil {
+			return "", fmt.Errorf("sending token to server: %w", err)
+		}
+		return req, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(req), &fields); err != nil {
+		return "", fmt.Errorf("parsing request: %w", err)
+	}
+	fields["token"], _ = json.Marshal(string(token))
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("encoding request: %w", err)
+	}
+	return string(b), nil
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// clientInfo is the metadata of a client sent to the server with the `conn`
+// command. The current directory is kept up to date with `cd` events, which
+// are always sent by clients for this reason.
+type clientInfo struct {
+	ID       int       `json:"id"`
+	PID      int       `json:"pid"`
+	TTY      string    `json:"tty"`
+	Cwd      string    `json:"cwd"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started,omitzero"`
+}
+
+// newClientInfo returns the metadata of this client.
+func newClientInfo() clientInfo {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("getting hostname: %s", err)
+	}
+	return clientInfo{
+		ID:       gClientID,
+		PID:      os.Getpid(),
+		TTY:      ttyName(),
+		Hostname: hostname,
+		Started:  time.Now(),
+	}
+}
+
+// clientList is the result of the `list` command for the JSON protocol.
+type clientList struct {
+	Clients []int        `json:"clients"`
+	Info    []clientInfo `json:"info"`
+}
+
+// listClients returns the metadata of the given clients as a table.
+func listClients(infos []clientInfo) string {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	t.Init(b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(t, "id\tpid\ttty\thostname\tstarted\tcwd")
+	for _, info := range infos {
+		var started string
+		if !info.Started.IsZero() {
+			started = info.Started.Format(time.DateTime)
+		}
+		fmt.Fprintf(t, "%d\t%d\t%s\t%s\t%s\t%s\n", info.ID, info.PID, info.TTY, info.Hostname, started, sanitizeName(info.Cwd))
+	}
+	t.Flush()
+
+	return b.String()
+}
+
+// cdPath returns the path of a `cd` event sent by a client.
+func cdPath(payload string) (string, bool) {
+	var ev struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil || ev.Path == "" {
+		return "", false
+	}
+	return ev.Path, true
+}
+
+// selectorKeys are the metadata fields which clients can be selected with
+// instead of an id in `send` and `query` commands.
+var selectorKeys = []string{"id", "pid", "tty", "cwd", "hostname"}
+
+// clientSelector selects the clients whose metadata match all of its fields.
+type clientSelector map[string]string
+
+// parseSelector parses a selector given as `key=value` (e.g. `cwd=/path`).
+func parseSelector(s string) (clientSelector, bool) {
+	key, val, ok := strings.Cut(s, "=")
+	if !ok || !slices.Contains(selectorKeys, key) {
+		return nil, false
+	}
+	return clientSelector{key: val}, true
+}
+
+func (sel clientSelector) validate() error {
+	if len(sel) == 0 {
+		return errors.New("selector should not be empty")
+	}
+	for key := range sel {
+		if !slices.Contains(selectorKeys, key) {
+			return fmt.Errorf("unknown selector key: %s", key)
+		}
+	}
+	return nil
+}
+
+func (sel clientSelector) String() string {
+	var fields []string
+	for _, key := range slices.Sorted(maps.Keys(sel)) {
+		fields = append(fields, key+"="+sel[key])
+	}
+	return strings.Join(fields, " ")
+}
+
+func (sel clientSelector) matches(info clientInfo) bool {
+	for key, val := range sel {
+		var got string
+		switch key {
+		case "id":
+			got = fmt.Sprint(info.ID)
+		case "pid":
+			got = fmt.Sprint(info.PID)
+		case "tty":
+			got = info.TTY
+		case "cwd":
+			// trailing separators are ignored since users often type them
+			if info.Cwd == "" || filepath.Clean(val) != filepath.Clean(info.Cwd) {
+				return false
+			}
+			continue
+		case "hostname":
+			got = info.Hostname
+		}
+		if got != val {
+			return false
+		}
+	}
+	return true
+}
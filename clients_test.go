@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestClientSelector(t *testing.T) {
+	info := clientInfo{ID: 42, PID: 42, TTY: "/dev/pts/3", Cwd: "/home/user", Hostname: "host"}
+
+	tests := []struct {
+		s     string
+		valid bool
+		match bool
+	}{
+		{"id=42", true, true},
+		{"pid=43", true, false},
+		{"tty=/dev/pts/3", true, true},
+		{"tty=/dev/pts/30", true, false},
+		{"cwd=/home/user/", true, true},
+		{"cwd=/home", true, false},
+		{"hostname=host", true, true},
+		{"cwd=", true, false},
+		{"user=foo", false, false},
+		{"ratios=1:2", false, false},
+		{"42", false, false},
+	}
+
+	for _, test := range tests {
+		sel, ok := parseSelector(test.s)
+		if ok != test.valid {
+			t.Errorf("at input '%s' expected valid '%t' but got '%t'", test.s, test.valid, ok)
+			continue
+		}
+		if ok && sel.matches(info) != test.match {
+			t.Errorf("at input '%s' expected match '%t' but got '%t'", test.s, test.match, !test.match)
+		}
+	}
+
+	// all fields of a selector should match
+	sel := clientSelector{"tty": "/dev/pts/3", "hostname": "other"}
+	if sel.matches(info) {
+		t.Errorf("at input '%s' expected no match", sel)
+	}
+}
@@ -65,6 +65,23 @@ func parseStyles() styleMap {
 		"ex=01;32",
 	}
 
+	// colors of git states in the `git` info column, specific to lf
+	defaultColors = append(defaultColors,
+		"gm=33",
+		"gs=32",
+		"gu=31",
+		"gi=90",
+		"gc=01;31",
+	)
+
+	// colors of compare states of files, specific to lf
+	defaultColors = append(defaultColors,
+		"dl=32",
+		"dr=35",
+		"ds=90",
+		"dd=33",
+	)
+
 	sm.parseGNU(strings.Join(defaultColors, ":"))
 
 	if env := os.Getenv("LSCOLORS"); env != "" {
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v3"
+)
+
+// The `compare` command shows two directories in the panes of the dual layout
+// and marks the files below them according to whether they exist only on one
+// side, or on both sides with the same or different contents. The directory
+// trees are compared in the background, and the state of each file is kept by
+// its path on both sides.
+
+// A compareState describes how a file differs from the file with the same
+// relative path on the other side of a comparison.
+type compareState byte
+
+const (
+	compareOnlyLeft  compareState = iota + 1 // File exists only on the left side.
+	compareOnlyRight                         // File exists only on the right side.
+	compareSame                              // Files are considered equal.
+	compareDiffer                            // Files differ in type, size, time or contents.
+)
+
+// styleKey returns the key of the color used for files in the given state.
+func (s compareState) styleKey() string {
+	switch s {
+	case compareOnlyLeft:
+		return "dl"
+	case compareOnlyRight:
+		return "dr"
+	case compareSame:
+		return "ds"
+	case compareDiffer:
+		return "dd"
+	}
+	return ""
+}
+
+func isValidCompareMethod(method string) bool {
+	switch method {
+	case "size", "hash":
+		return true
+	}
+	return false
+}
+
+type comparison struct {
+	left     string
+	right    string
+	method   string // `size` to compare size and time, or `hash` to compare contents
+	loading  bool
+	canceled atomic.Bool
+	states   map[string]compareState
+}
+
+// compareResult is sent when comparing is finished.
+type compareResult struct {
+	cmp    *comparison
+	states map[string]compareState
+	counts [compareDiffer + 1]int // number of non-directory files in each state
+	errs   int
+}
+
+func (r *compareResult) String() string {
+	s := fmt.Sprintf("%d different, %d only left, %d only right, %d same",
+		r.counts[compareDiffer], r.counts[compareOnlyLeft], r.counts[compareOnlyRight], r.counts[compareSame])
+	if r.errs > 0 {
+		s += fmt.Sprintf(" (%d errors, see log)", r.errs)
+	}
+	return s
+}
+
+// compare shows the given directories in the left and right panes of the dual
+// layout and starts comparing them in the background. A running comparison is
+// canceled.
+func (nav *nav) compare(left, right, method string) error {
+	if !isDualLayout() {
+		return errors.New("requires 'layout' to be 'dual'")
+	}
+
+	curr, other := left, right
+	if nav.paneInd == 1 {
+		curr, other = right, left
+	}
+	if err := nav.cd(curr); err != nil {
+		return err
+	}
+	if nav.pane.path != other {
+		nav.pane.path = other
+		nav.pane.jumpList = append(nav.pane.jumpList[:nav.pane.jumpListInd+1], other)
+		nav.pane.jumpListInd = len(nav.pane.jumpList) - 1
+	}
+
+	nav.stopCompare()
+
+	cmp := &comparison{left: left, right: right, method: method, loading: true}
+	nav.comparison = cmp
+	go func() {
+		nav.compareChan <- cmp.run()
+	}()
+	return nil
+}
+
+// stopCompare cancels a running comparison and clears the marks.
+func (nav *nav) stopCompare() {
+	if nav.comparison != nil {
+		nav.comparison.canceled.Store(true)
+		nav.comparison = nil
+	}
+}
+
+// compareStates returns the states of the files of the last comparison.
+func (nav *nav) compareStates() map[string]compareState {
+	if nav.comparison == nil {
+		return nil
+	}
+	return nav.comparison.states
+}
+
+func (cmp *comparison) run() *compareResult {
+	r := &compareResult{cmp: cmp, states: make(map[string]compareState)}
+	r.compareDirs(cmp.left, cmp.right)
+	return r
+}
+
+// otherSide returns the directory on the other side of the comparison for the
+// given directory, which should be one of the compared directories or inside
+// them.
+func (cmp *comparison) otherSide(dir string) (string, error) {
+	for _, p := range [][2]string{{cmp.left, cmp.right}, {cmp.right, cmp.left}} {
+		if rel, err := filepath.Rel(p[0], dir); err == nil && filepath.IsLocal(rel) {
+			return filepath.Join(p[1], rel), nil
+		}
+	}
+	return "", fmt.Errorf("not inside a compared directory: %s", dir)
+}
+
+func (r *compareResult) fail(err error) {
+	log.Printf("compare: %s", err)
+	r.errs++
+}
+
+// compareDirs compares the contents of the given directories recursively and
+// reports whether they are the same.
+func (r *compareResult) compareDirs(left, right string) bool {
+	lfs, rfs := getFS(left), getFS(right)
+
+	lnames, err := lfs.Readdirnames(left, -1)
+	if err != nil {
+		r.fail(err)
+		return false
+	}
+	rnames, err := rfs.Readdirnames(right, -1)
+	if err != nil {
+		r.fail(err)
+		return false
+	}
+
+	names := slices.Concat(lnames, rnames)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	same := true
+	for _, name := range names {
+		if r.cmp.canceled.Load() {
+			return false
+		}
+
+		lpath, rpath := filepath.Join(left, name), filepath.Join(right, name)
+		linfo, lerr := lfs.Lstat(lpath)
+		rinfo, rerr := rfs.Lstat(rpath)
+
+		switch {
+		case lerr != nil && rerr != nil:
+			continue
+		case rerr != nil:
+			r.markTree(lfs, lpath, compareOnlyLeft)
+			same = false
+		case lerr != nil:
+			r.markTree(rfs, rpath, compareOnlyRight)
+			same = false
+		default:
+			state := r.compareFiles(lpath, linfo, rpath, rinfo)
+			r.states[lpath] = state
+			r.states[rpath] = state
+			if !linfo.IsDir() || !rinfo.IsDir() {
+				r.counts[state]++
+			}
+			same = same && state == compareSame
+		}
+	}
+
+	return same
+}
+
+// markTree marks the given file and all files below it with the given state.
+func (r *compareResult) markTree(fsys fileSystem, root string, state compareState) {
+	err := walkFS(fsys, root, func(path string, info os.FileInfo, err error) error {
+		if r.cmp.canceled.Load() {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			r.fail(err)
+			return nil
+		}
+		r.states[path] = state
+		if !info.IsDir() {
+			r.counts[state]++
+		}
+		return nil
+	})
+	if err != nil {
+		r.fail(err)
+	}
+}
+
+func (r *compareResult) compareFiles(lpath string, linfo os.FileInfo, rpath string, rinfo os.FileInfo) compareState {
+	ltype, rtype := linfo.Mode().Type(), rinfo.Mode().Type()
+
+	if ltype != rtype {
+		// contents of a directory replaced by another type of file exist only
+		// on one side
+		if linfo.IsDir() {
+			r.markTree(getFS(lpath), lpath, compareOnlyLeft)
+		}
+		if rinfo.IsDir() {
+			r.markTree(getFS(rpath), rpath, compareOnlyRight)
+		}
+		return compareDiffer
+	}
+
+	switch {
+	case linfo.IsDir():
+		if r.compareDirs(lpath, rpath) {
+			return compareSame
+		}
+		return compareDiffer
+	case ltype&os.ModeSymlink != 0:
+		ltarget, lerr := getFS(lpath).Readlink(lpath)
+		rtarget, rerr := getFS(rpath).Readlink(rpath)
+		if lerr != nil || rerr != nil {
+			r.fail(errors.Join(lerr, rerr))
+			return compareDiffer
+		}
+		if ltarget == rtarget {
+			return compareSame
+		}
+		return compareDiffer
+	case !linfo.Mode().IsRegular():
+		return compareSame
+	case linfo.Size() != rinfo.Size():
+		return compareDiffer
+	case r.cmp.method == "hash":
+		lsum, lerr := hashFile(lpath, "sha256")
+		rsum, rerr := hashFile(rpath, "sha256")
+		if lerr != nil || rerr != nil {
+			r.fail(errors.Join(lerr, rerr))
+			return compareDiffer
+		}
+		if bytes.Equal(lsum, rsum) {
+			return compareSame
+		}
+		return compareDiffer
+	}
+
+	// modification times are compared in seconds, since sub-second precision
+	// is not kept by all file systems
+	if linfo.ModTime().Truncate(time.Second).Equal(rinfo.ModTime().Truncate(time.Second)) {
+		return compareSame
+	}
+	return compareDiffer
+}
+
+// compareSelect selects the files in the current directory which exist only
+// in the current pane or differ from the other side.
+func (nav *nav) compareSelect() error {
+	cmp := nav.comparison
+	if cmp == nil {
+		return errors.New("no comparison")
+	}
+	if cmp.loading {
+		return errors.New("comparison is still running")
+	}
+
+	dir := nav.currDir()
+	if _, err := cmp.otherSide(dir.path); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, f := range dir.files {
+		switch cmp.states[f.path] {
+		case compareOnlyLeft, compareOnlyRight, compareDiffer:
+			if _, ok := nav.selections[f.path]; !ok {
+				nav.toggleSelection(f.path)
+			}
+			count++
+		}
+	}
+
+	if count == 0 {
+		return errors.New("no differences")
+	}
+	return nil
+}
+
+// comparePlan returns the files to copy from the current directory to the
+// other side of the comparison grouped by their destination directories.
+// Only the differing files are copied from directories with different
+// contents.
+func (nav *nav) comparePlan() (map[string][]string, error) {
+	cmp := nav.comparison
+	if cmp == nil {
+		return nil, errors.New("no comparison")
+	}
+	if cmp.loading {
+		return nil, errors.New("comparison is still running")
+	}
+
+	src := nav.currDir().path
+	dst, err := cmp.otherSide(src)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make(map[string][]string)
+	if err := cmp.plan(src, dst, plan); err != nil {
+		return nil, err
+	}
+	if len(plan) == 0 {
+		return nil, errors.New("no differences")
+	}
+	return plan, nil
+}
+
+func (cmp *comparison) plan(src, dst string, plan map[string][]string) error {
+	names, err := getFS(src).Readdirnames(src, -1)
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		path := filepath.Join(src, name)
+		switch cmp.states[path] {
+		case compareOnlyLeft, compareOnlyRight:
+			plan[dst] = append(plan[dst], path)
+		case compareDiffer:
+			dstPath := filepath.Join(dst, name)
+			sinfo, serr := getFS(path).Lstat(path)
+			dinfo, derr := getFS(dstPath).Lstat(dstPath)
+			if serr == nil && derr == nil && sinfo.IsDir() && dinfo.IsDir() {
+				if err := cmp.plan(path, dstPath, plan); err != nil {
+					return err
+				}
+				continue
+			}
+			plan[dst] = append(plan[dst], path)
+		}
+	}
+
+	return nil
+}
+
+// copyPreserve returns the attributes preserved when copying differences. The
+// modification times are always preserved when comparing by size and time, as
+// otherwise the copied files would still differ from their sources.
+func (cmp *comparison) copyPreserve() []string {
+	if cmp.method == "size" && !slices.Contains(gOpts.preserve, "timestamps") {
+		return append(slices.Clone(gOpts.preserve), "timestamps")
+	}
+	return gOpts.preserve
+}
+
+// compareCopyAsync copies the differing files planned by [nav.comparePlan] to
+// the other side, overwriting different files, and compares the directories
+// again afterwards.
+func (nav *nav) compareCopyAsync(app *app, cmp *comparison, plan map[string][]string, preserve []string) {
+	for _, dstDir := range slices.Sorted(maps.Keys(plan)) {
+		nav.copyAsync(app, plan[dstDir], dstDir, conflictOverwrite, preserve)
+	}
+
+	nav.compareChan <- cmp.run()
+}
+
+func (sm styleMap) compareStyle(state compareState) (tcell.Style, bool) {
+	st, ok := sm.styles[state.styleKey()]
+	return st, ok
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	root := t.TempDir()
+	left, right := filepath.Join(root, "left"), filepath.Join(root, "right")
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		path    string
+		content string
+		mtime   time.Time
+	}{
+		{"left/same.txt", "same", mtime},
+		{"right/same.txt", "same", mtime},
+		{"left/size.txt", "left", mtime},
+		{"right/size.txt", "right", mtime},
+		{"left/time.txt", "time", mtime},
+		{"right/time.txt", "time", mtime.Add(time.Hour)},
+		{"left/content.txt", "abcd", mtime},
+		{"right/content.txt", "efgh", mtime},
+		{"left/only.txt", "left", mtime},
+		{"right/only.txt/f.txt", "right", mtime},
+		{"left/new/f.txt", "new", mtime},
+		{"left/sub/same.txt", "same", mtime},
+		{"right/sub/same.txt", "same", mtime},
+		{"left/sub/old.txt", "old", mtime},
+		{"right/sub/old.txt", "new", mtime},
+		{"left/eq/f.txt", "eq", mtime},
+		{"right/eq/f.txt", "eq", mtime},
+		{"right/extra.txt", "extra", mtime},
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, f.mtime, f.mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		state  compareState
+	}{
+		{"size", "left/same.txt", compareSame},
+		{"size", "right/same.txt", compareSame},
+		{"size", "left/size.txt", compareDiffer},
+		{"size", "left/time.txt", compareDiffer},
+		{"size", "left/content.txt", compareSame},
+		{"size", "left/only.txt", compareDiffer},
+		{"size", "right/only.txt", compareDiffer},
+		{"size", "right/only.txt/f.txt", compareOnlyRight},
+		{"size", "left/new", compareOnlyLeft},
+		{"size", "left/new/f.txt", compareOnlyLeft},
+		{"size", "left/sub", compareSame},
+		{"size", "left/sub/same.txt", compareSame},
+		{"size", "right/sub/old.txt", compareSame},
+		{"size", "left/eq", compareSame},
+		{"size", "right/extra.txt", compareOnlyRight},
+		{"hash", "left/time.txt", compareSame},
+		{"hash", "left/content.txt", compareDiffer},
+		{"hash", "left/sub/old.txt", compareDiffer},
+		{"hash", "right/sub", compareDiffer},
+	}
+
+	results := make(map[string]*compareResult)
+	for _, method := range []string{"size", "hash"} {
+		cmp := &comparison{left: left, right: right, method: method}
+		results[method] = cmp.run()
+		cmp.states = results[method].states
+	}
+
+	for _, test := range tests {
+		if got := results[test.method].states[filepath.Join(root, test.path)]; got != test.state {
+			t.Errorf("at input '%s' with method '%s' expected '%d' but got '%d'", test.path, test.method, test.state, got)
+		}
+	}
+
+	r := results["size"]
+	if r.counts != [...]int{0, 1, 2, 5, 3} || r.errs != 0 {
+		t.Errorf("expected counts '[0 1 2 5 3]' without errors but got '%v' with %d errors", r.counts, r.errs)
+	}
+
+	cmp := &comparison{left: left, right: right, method: "hash", states: results["hash"].states}
+	plan := make(map[string][]string)
+	if err := cmp.plan(left, right, plan); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string][]string{
+		right: {
+			filepath.Join(left, "content.txt"),
+			filepath.Join(left, "new"),
+			filepath.Join(left, "only.txt"),
+			filepath.Join(left, "size.txt"),
+		},
+		filepath.Join(right, "sub"): {filepath.Join(left, "sub", "old.txt")},
+	}
+	if len(plan) != len(want) {
+		t.Errorf("expected plan '%v' but got '%v'", want, plan)
+	}
+	for dst, srcs := range want {
+		if !slices.Equal(plan[dst], srcs) {
+			t.Errorf("at destination '%s' expected '%v' but got '%v'", dst, srcs, plan[dst])
+		}
+	}
+
+	if dir, err := cmp.otherSide(filepath.Join(right, "sub")); err != nil || dir != filepath.Join(left, "sub") {
+		t.Errorf("expected other side '%s' but got '%s' (%v)", filepath.Join(left, "sub"), dir, err)
+	}
+	if _, err := cmp.otherSide(root); err == nil {
+		t.Errorf("expected error for directory outside of the comparison")
+	}
+}
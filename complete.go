@@ -21,30 +21,46 @@ var (
 		"cmap",
 		"cmd",
 		"addcustominfo",
+		"archive",
 		"bottom",
+		"bulk-rename",
 		"calcdirsize",
 		"cd",
 		"clear",
 		"clearmaps",
+		"compare",
+		"compare-copy",
+		"compare-select",
 		"copy",
 		"cut",
 		"down",
 		"delete",
+		"du",
+		"duplicates-select",
 		"draw",
 		"echo",
 		"echoerr",
 		"echomsg",
+		"extract",
 		"filter",
 		"find",
 		"find-back",
+		"find-duplicates",
 		"find-next",
 		"find-prev",
+		"flatten",
+		"fuzzy-find",
 		"glob-select",
 		"glob-unselect",
+		"grep",
 		"half-down",
 		"half-up",
 		"high",
 		"invert",
+		"job-cancel",
+		"job-pause",
+		"job-resume",
+		"jobs",
 		"jump-next",
 		"jump-prev",
 		"load",
@@ -56,13 +72,18 @@ var (
 		"open",
 		"page-down",
 		"page-up",
+		"pane-copy",
+		"pane-move",
+		"pane-switch",
 		"paste",
 		"push",
 		"quit",
 		"read",
+		"redo",
 		"redraw",
 		"reload",
 		"rename",
+		"rename-pattern",
 		"scroll-down",
 		"scroll-up",
 		"search",
@@ -77,11 +98,23 @@ var (
 		"shell-wait",
 		"source",
 		"sync",
+		"tab-close",
+		"tab-goto",
+		"tab-new",
+		"tab-next",
+		"tab-prev",
 		"tag",
 		"tag-toggle",
 		"toggle",
 		"top",
+		"trash-empty",
+		"trash-list",
+		"trash-restore",
+		"tree-close",
+		"tree-open",
+		"tree-toggle",
 		"tty-write",
+		"undo",
 		"unselect",
 		"up",
 		"updir",
@@ -397,10 +430,18 @@ func completeCmd(s string) (matches []compMatch, longest string) {
 			matches, longest = matchCmdFile(f[2], false)
 		case "borderstyle":
 			matches, longest = matchWord(f[2], []string{"box", "roundbox", "outline", "roundoutline", "separators"})
+		case "copyverify":
+			matches, longest = matchWord(f[2], []string{"none", "sha256"})
+		case "deletemethod":
+			matches, longest = matchWord(f[2], []string{"remove", "trash"})
 		case "filtermethod", "searchmethod":
 			matches, longest = matchWord(f[2], []string{"glob", "regex", "text"})
 		case "info":
-			matches, longest = matchList(f[2], []string{"atime", "btime", "ctime", "custom", "group", "perm", "size", "time", "user"})
+			matches, longest = matchList(f[2], []string{"atime", "btime", "ctime", "custom", "git", "group", "perm", "size", "time", "user"})
+		case "layout":
+			matches, longest = matchWord(f[2], []string{"dual", "miller", "tree"})
+		case "pasteconflict":
+			matches, longest = matchWord(f[2], []string{"ask", "newer", "overwrite", "rename", "skip"})
 		case "preserve":
 			matches, longest = matchList(f[2], []string{"mode", "timestamps"})
 		case "selmode":
@@ -430,7 +471,7 @@ func completeCmd(s string) (matches []compMatch, longest string) {
 		}
 		switch f[2] {
 		case "info":
-			matches, longest = matchList(f[3], []string{"atime", "btime", "ctime", "custom", "group", "perm", "size", "time", "user"})
+			matches, longest = matchList(f[3], []string{"atime", "btime", "ctime", "custom", "git", "group", "perm", "size", "time", "user"})
 		case "sortby":
 			matches, longest = matchWord(f[3], []string{"atime", "btime", "ctime", "custom", "ext", "name", "natural", "size", "time"})
 		default:
@@ -443,14 +484,25 @@ func completeCmd(s string) (matches []compMatch, longest string) {
 			matches, longest = matchCmd(f[2])
 		}
 	case "cmd":
-	case "cd":
+	case "cd", "du", "find-duplicates", "tab-new":
 		if len(f) == 2 {
 			matches, longest = matchCmdFile(f[1], true)
 		}
+	case "compare":
+		switch len(f) {
+		case 2, 3:
+			matches, longest = matchCmdFile(f[len(f)-1], true)
+		case 4:
+			matches, longest = matchWord(f[3], []string{"hash", "size"})
+		}
 	case "addcustominfo", "select", "source":
 		if len(f) == 2 {
 			matches, longest = matchCmdFile(f[1], false)
 		}
+	case "paste", "pane-copy", "pane-move":
+		if len(f) == 2 {
+			matches, longest = matchWord(f[1], []string{"ask", "newer", "overwrite", "rename", "skip"})
+		}
 	case "toggle":
 		matches, longest = matchCmdFile(f[len(f)-1], false)
 	default:
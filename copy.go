@@ -1,17 +1,71 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+)
+
+type conflictPolicy string
 
-	"github.com/djherbis/times"
+const (
+	conflictRename    conflictPolicy = "rename"
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictSkip      conflictPolicy = "skip"
+	conflictNewer     conflictPolicy = "newer"
+	conflictAsk       conflictPolicy = "ask"
 )
 
+func isValidConflictPolicy(policy conflictPolicy) bool {
+	switch policy {
+	case conflictRename, conflictOverwrite, conflictSkip, conflictNewer, conflictAsk:
+		return true
+	}
+	return false
+}
+
+const invalidConflictErrorMessage = `pasteconflict: value should either be 'rename', 'overwrite', 'skip', 'newer' or 'ask'`
+
+func isValidVerifyMethod(method string) bool {
+	switch method {
+	case "none", "sha256":
+		return true
+	}
+	return false
+}
+
+func newHash(method string) hash.Hash {
+	switch method {
+	case "sha256":
+		return sha256.New()
+	}
+	panic("unknown verify method: " + method)
+}
+
+func hashFile(path, method string) ([]byte, error) {
+	f, err := getFS(path).Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash(method)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
 type ProgressWriter struct {
 	writer io.Writer
 	nums   chan<- int64
@@ -34,12 +88,22 @@ func copySize(srcs []string) (int64, error) {
 	var total int64
 
 	for _, src := range srcs {
-		_, err := os.Lstat(src)
+		if isArchiveMember(src) {
+			size, err := archiveSize(src)
+			if err != nil {
+				return total, err
+			}
+			total += size
+			continue
+		}
+
+		fsys := getFS(src)
+		_, err := fsys.Lstat(src)
 		if os.IsNotExist(err) {
 			return total, fmt.Errorf("src does not exist: %q", src)
 		}
 
-		err = filepath.Walk(src, func(_ string, info os.FileInfo, err error) error {
+		err = walkFS(fsys, src, func(_ string, info os.FileInfo, err error) error {
 			if err != nil {
 				return fmt.Errorf("walk: %w", err)
 			}
@@ -54,8 +118,15 @@ func copySize(srcs []string) (int64, error) {
 	return total, nil
 }
 
-func copyFile(src, dst string, preserve []string, info os.FileInfo, nums chan<- int64, errs chan<- error) {
-	r, err := os.Open(src)
+// copyVerifyHook is called with the temporary file of a copy before it is read
+// back for verification. It is only set in tests to corrupt copies.
+var copyVerifyHook func(tmp string)
+
+// copyFile copies src to a temporary file next to dst, which is renamed to dst
+// only after the copy is complete, so that a failed or canceled copy never
+// leaves a partially written dst behind.
+func copyFile(src, dst string, preserve []string, info os.FileInfo, j *job, nums chan<- int64, errs chan<- error) {
+	r, err := getFS(src).Open(src)
 	if err != nil {
 		errs <- err
 		return
@@ -66,39 +137,257 @@ func copyFile(src, dst string, preserve []string, info os.FileInfo, nums chan<-
 	if slices.Contains(preserve, "mode") {
 		dstMode = info.Mode()
 	}
-	w, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, dstMode)
+	dstFS := getFS(dst)
+	w, tmp, err := createTemp(dstFS, dst, dstMode)
 	if err != nil {
 		errs <- err
 		return
 	}
 
-	if _, err := io.Copy(NewProgressWriter(w, nums), r); err != nil {
-		errs <- err
+	var reader io.Reader = &jobReader{r, j}
+	var srcHash hash.Hash
+	if verify := j.verifyMethod(); verify != "" {
+		srcHash = newHash(verify)
+		reader = io.TeeReader(reader, srcHash)
+	}
+
+	if _, err := io.Copy(NewProgressWriter(w, nums), reader); err != nil {
+		if !errors.Is(err, errJobCanceled) {
+			errs <- err
+		}
 		w.Close()
-		if err = os.Remove(dst); err != nil {
+		if err = dstFS.Remove(tmp); err != nil {
 			errs <- err
 		}
 		return
 	}
 
+	// files on remote hosts are read back from the server for verification
+	// without syncing, which is not supported by all servers
+	if f, ok := w.(*os.File); ok && srcHash != nil {
+		if err := f.Sync(); err != nil {
+			errs <- err
+			w.Close()
+			if err = dstFS.Remove(tmp); err != nil {
+				errs <- err
+			}
+			return
+		}
+	}
+
 	if err := w.Close(); err != nil {
 		errs <- err
-		if err = os.Remove(dst); err != nil {
+		if err = dstFS.Remove(tmp); err != nil {
+			errs <- err
+		}
+		return
+	}
+
+	if srcHash != nil {
+		if copyVerifyHook != nil {
+			copyVerifyHook(tmp)
+		}
+		dstSum, err := hashFile(tmp, j.verifyMethod())
+		if err == nil && !bytes.Equal(srcHash.Sum(nil), dstSum) {
+			err = fmt.Errorf("checksum mismatch: %s", dst)
+		}
+		j.addVerified(err == nil)
+		if err != nil {
+			errs <- fmt.Errorf("verify: %w", err)
+			if err = dstFS.Remove(tmp); err != nil {
+				errs <- err
+			}
+			return
+		}
+	}
+
+	if err := dstFS.Rename(tmp, dst); err != nil {
+		errs <- err
+		if err = dstFS.Remove(tmp); err != nil {
 			errs <- err
 		}
 		return
 	}
 
 	if slices.Contains(preserve, "timestamps") {
-		atime := times.Get(info).AccessTime()
+		atime, _, _ := fileTimes(info)
+		mtime := info.ModTime()
+		if err := dstFS.Chtimes(dst, atime, mtime); err != nil {
+			errs <- err
+		}
+	}
+}
+
+// dupPath returns a path that does not exist yet for a duplicate of the
+// existing path, named according to the dupfilefmt option.
+func dupPath(path string) string {
+	fsys := getFS(path)
+	lstat, err := fsys.Lstat(path)
+	if err != nil {
+		return path
+	}
+
+	dir := filepath.Dir(path)
+	file := filepath.Base(path)
+	ext := getFileExtension(lstat)
+	basename := file[:len(file)-len(ext)]
+	var newPath string
+	for i := 1; !os.IsNotExist(err); i++ {
+		file = strings.ReplaceAll(gOpts.dupfilefmt, "%f", basename+ext)
+		file = strings.ReplaceAll(file, "%b", basename)
+		file = strings.ReplaceAll(file, "%e", ext)
+		file = strings.ReplaceAll(file, "%n", strconv.Itoa(i))
+		newPath = filepath.Join(dir, file)
+		_, err = fsys.Lstat(newPath)
+	}
+	return newPath
+}
+
+func copyTree(src, dst string, preserve []string, dirInfos map[string]os.FileInfo, j *job, nums chan<- int64, errs chan<- error) error {
+	srcFS, dstFS := getFS(src), getFS(dst)
+	err := walkFS(srcFS, src, func(path string, info os.FileInfo, err error) error {
+		if err := j.wait(); err != nil {
+			return err
+		}
+		if err != nil {
+			errs <- fmt.Errorf("walk: %w", err)
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			errs <- fmt.Errorf("relative: %w", err)
+			return nil
+		}
+		newPath := filepath.Join(dst, rel)
+
+		// Existing directories are merged, and conflicts for other files are
+		// resolved according to the conflict policy of the job. Conflicts for
+		// src itself are already resolved by the caller.
+		if lstat, err := dstFS.Lstat(newPath); err == nil && rel != "." && !(info.IsDir() && lstat.IsDir()) {
+			switch j.resolve(info, lstat, newPath) {
+			case conflictSkip:
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				nums <- info.Size()
+				return nil
+			case conflictRename:
+				newPath = dupPath(newPath)
+				if info.IsDir() {
+					if err := copyTree(path, newPath, preserve, dirInfos, j, nums, errs); err != nil {
+						return err
+					}
+					return filepath.SkipDir
+				}
+			case conflictOverwrite:
+				if info.IsDir() || lstat.IsDir() {
+					errs <- fmt.Errorf("cannot overwrite %s: file type mismatch", newPath)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			dstMode := os.ModePerm
+			if slices.Contains(preserve, "mode") {
+				dstMode = info.Mode()
+			}
+			if err := dstFS.MkdirAll(newPath, dstMode); err != nil {
+				errs <- fmt.Errorf("mkdir: %w", err)
+			}
+			if slices.Contains(preserve, "timestamps") {
+				dirInfos[newPath] = info
+			}
+			nums <- info.Size()
+		case info.Mode()&os.ModeSymlink != 0:
+			if rlink, err := srcFS.Readlink(path); err != nil {
+				errs <- fmt.Errorf("symlink: %w", err)
+			} else {
+				// Create the link with a temporary name first to replace an
+				// existing file atomically when overwriting.
+				tmp := tempPath(newPath)
+				if err := dstFS.Symlink(rlink, tmp); err != nil {
+					errs <- fmt.Errorf("symlink: %w", err)
+				} else if err := dstFS.Rename(tmp, newPath); err != nil {
+					errs <- fmt.Errorf("symlink: %w", err)
+					dstFS.Remove(tmp)
+				}
+			}
+			nums <- info.Size()
+		default:
+			copyFile(path, newPath, preserve, info, j, nums, errs)
+		}
+		return nil
+	})
+	if errors.Is(err, errJobCanceled) {
+		return err
+	}
+	if err != nil {
+		errs <- fmt.Errorf("walk: %w", err)
+	}
+	return nil
+}
+
+func restoreDirTimes(dirInfos map[string]os.FileInfo, errs chan<- error) {
+	for path, info := range dirInfos {
+		atime, _, _ := fileTimes(info)
 		mtime := info.ModTime()
-		if err := os.Chtimes(dst, atime, mtime); err != nil {
+		if err := getFS(path).Chtimes(path, atime, mtime); err != nil {
 			errs <- err
 		}
 	}
 }
 
-func copyAll(srcs []string, dstDir string, preserve []string) (nums chan int64, errs chan error) {
+// copyDst returns the destination for copying or moving src into dstDir after
+// resolving a possible conflict with an existing file. The merge result is
+// true when src and the destination are both directories to be merged, and
+// ok is false when src should be skipped.
+func copyDst(src, dstDir string, j *job) (dst string, merge, ok bool, err error) {
+	dst = filepath.Join(dstDir, filepath.Base(src))
+
+	dstStat, err := getFS(dst).Lstat(dst)
+	if err != nil {
+		return dst, false, true, nil
+	}
+
+	srcStat, err := getFS(src).Lstat(src)
+	if err != nil {
+		return "", false, false, err
+	}
+
+	if os.SameFile(srcStat, dstStat) {
+		return dupPath(dst), false, true, nil
+	}
+
+	// Directories are merged unless a rename is requested, except that
+	// skipping is applied to the whole directory when asked interactively.
+	asked := j.policy() == conflictAsk
+	dirs := srcStat.IsDir() && dstStat.IsDir()
+
+	switch action := j.resolve(srcStat, dstStat, dst); {
+	case action == conflictRename:
+		return dupPath(dst), false, true, nil
+	case dirs && (action == conflictOverwrite || !asked):
+		return dst, true, true, nil
+	case action == conflictSkip:
+		return "", false, false, nil
+	case srcStat.IsDir() || dstStat.IsDir():
+		return "", false, false, fmt.Errorf("cannot overwrite %s: file type mismatch", dst)
+	}
+
+	return dst, false, true, nil
+}
+
+// copyAll copies srcs into dstDir in the background. The copied callback, if
+// given, is called for each source copied without errors to a new destination,
+// which excludes merged directories and overwritten files. If the job is
+// canceled, the partially copied source is removed from a new destination and
+// the remaining sources are skipped.
+func copyAll(srcs []string, dstDir string, preserve []string, j *job, copied func(src, dst string)) (nums chan int64, errs chan error) {
 	nums = make(chan int64, 1024)
 	errs = make(chan error, 1024)
 
@@ -106,82 +395,214 @@ func copyAll(srcs []string, dstDir string, preserve []string) (nums chan int64,
 		dirInfos := make(map[string]os.FileInfo)
 
 		for _, src := range srcs {
-			file := filepath.Base(src)
-			dst := filepath.Join(dstDir, file)
-
-			if lstat, err := os.Lstat(dst); err == nil {
-				ext := getFileExtension(lstat)
-				basename := file[:len(file)-len(ext)]
-				var newPath string
-				for i := 1; !os.IsNotExist(err); i++ {
-					file = strings.ReplaceAll(gOpts.dupfilefmt, "%f", basename+ext)
-					file = strings.ReplaceAll(file, "%b", basename)
-					file = strings.ReplaceAll(file, "%e", ext)
-					file = strings.ReplaceAll(file, "%n", strconv.Itoa(i))
-					newPath = filepath.Join(dstDir, file)
-					_, err = os.Lstat(newPath)
+			if j.wait() != nil {
+				break
+			}
+			j.next(src)
+
+			// members of archives are extracted, resolving conflicts for
+			// each extracted file separately
+			if isArchiveMember(src) {
+				if err := extractArchive(src, dstDir, j, nums, errs); errors.Is(err, errJobCanceled) {
+					break
 				}
-				dst = newPath
+				continue
 			}
 
+			dst, merge, ok, err := copyDst(src, dstDir, j)
+			if err != nil {
+				errs <- err
+				continue
+			}
+			if !ok {
+				continue
+			}
+			_, err = getFS(dst).Lstat(dst)
+			fresh := os.IsNotExist(err)
+
 			if rel, err := filepath.Rel(src, dst); err == nil && rel != "." && filepath.IsLocal(rel) {
 				errs <- fmt.Errorf("cannot copy %s into a subdirectory of itself", src)
 				continue
 			}
 
-			err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					errs <- fmt.Errorf("walk: %w", err)
-					return nil
-				}
-				rel, err := filepath.Rel(src, path)
-				if err != nil {
-					errs <- fmt.Errorf("relative: %w", err)
-					return nil
+			srcErrs := make(chan error)
+			failed := make(chan bool)
+			go func() {
+				ok := true
+				for err := range srcErrs {
+					ok = false
+					errs <- err
 				}
-				newPath := filepath.Join(dst, rel)
-				switch {
-				case info.IsDir():
-					dstMode := os.ModePerm
-					if slices.Contains(preserve, "mode") {
-						dstMode = info.Mode()
-					}
-					if err := os.MkdirAll(newPath, dstMode); err != nil {
-						errs <- fmt.Errorf("mkdir: %w", err)
-					}
-					if slices.Contains(preserve, "timestamps") {
-						dirInfos[newPath] = info
-					}
-					nums <- info.Size()
-				case info.Mode()&os.ModeSymlink != 0:
-					if rlink, err := os.Readlink(path); err != nil {
-						errs <- fmt.Errorf("symlink: %w", err)
-					} else {
-						if err := os.Symlink(rlink, newPath); err != nil {
-							errs <- fmt.Errorf("symlink: %w", err)
-						}
+				failed <- !ok
+			}()
+
+			err = copyTree(src, dst, preserve, dirInfos, j, nums, srcErrs)
+			close(srcErrs)
+
+			if errors.Is(err, errJobCanceled) {
+				<-failed
+				if fresh {
+					if err := getFS(dst).RemoveAll(dst); err != nil {
+						errs <- err
 					}
-					nums <- info.Size()
-				default:
-					copyFile(path, newPath, preserve, info, nums, errs)
+					maps.DeleteFunc(dirInfos, func(path string, _ os.FileInfo) bool {
+						return path == dst || strings.HasPrefix(path, dst+string(filepath.Separator))
+					})
 				}
-				return nil
-			})
-			if err != nil {
-				errs <- fmt.Errorf("walk: %w", err)
+				break
+			}
+
+			if !<-failed && fresh && !merge && copied != nil {
+				copied(src, dst)
 			}
 		}
 
-		for path, info := range dirInfos {
-			atime := times.Get(info).AccessTime()
-			mtime := info.ModTime()
-			if err := os.Chtimes(path, atime, mtime); err != nil {
-				errs <- err
+		restoreDirTimes(dirInfos, errs)
+
+		close(errs)
+	}()
+
+	return nums, errs
+}
+
+// copyTo copies src to the path dst in the background. An existing file at dst
+// is only replaced once the copy is complete. If the job is canceled, the
+// partially copied dst is removed unless it existed before.
+func copyTo(src, dst string, preserve []string, j *job) (nums chan int64, errs chan error) {
+	nums = make(chan int64, 1024)
+	errs = make(chan error, 1024)
+
+	go func() {
+		dirInfos := make(map[string]os.FileInfo)
+
+		_, err := getFS(dst).Lstat(dst)
+		fresh := os.IsNotExist(err)
+
+		if err := copyTree(src, dst, preserve, dirInfos, j, nums, errs); errors.Is(err, errJobCanceled) {
+			if fresh {
+				if err := getFS(dst).RemoveAll(dst); err != nil {
+					errs <- err
+				}
 			}
+			close(errs)
+			return
 		}
 
+		restoreDirTimes(dirInfos, errs)
+
 		close(errs)
 	}()
 
 	return nums, errs
 }
+
+// moveMerge moves the contents of the directory src into the existing directory
+// dst, resolving conflicts according to the conflict policy of the job. The
+// src directory is removed if nothing is left inside afterwards. The moved
+// callback, if given, is called for each file moved to a new destination, which
+// excludes overwritten files.
+func moveMerge(src, dst string, j *job, moved func(src, dst string)) error {
+	srcFS, dstFS := getFS(src), getFS(dst)
+	names, err := srcFS.Readdirnames(src, -1)
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := j.wait(); err != nil {
+			return err
+		}
+
+		s := filepath.Join(src, name)
+		d := filepath.Join(dst, name)
+
+		srcStat, err := srcFS.Lstat(s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		dstStat, err := dstFS.Lstat(d)
+		if err != nil {
+			if err := movePath(s, d); err != nil {
+				errs = append(errs, err)
+			} else if moved != nil {
+				moved(s, d)
+			}
+			continue
+		}
+
+		if srcStat.IsDir() && dstStat.IsDir() {
+			if err := moveMerge(s, d, j, moved); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		switch j.resolve(srcStat, dstStat, d) {
+		case conflictRename:
+			dup := dupPath(d)
+			if err := movePath(s, dup); err != nil {
+				errs = append(errs, err)
+			} else if moved != nil {
+				moved(s, dup)
+			}
+		case conflictOverwrite:
+			if srcStat.IsDir() || dstStat.IsDir() {
+				errs = append(errs, fmt.Errorf("cannot overwrite %s: file type mismatch", d))
+				continue
+			}
+			if err := renamePath(s, d); err != nil {
+				if !errCrossDevice(err) {
+					errs = append(errs, err)
+					continue
+				}
+				// the copy replaces d only once it is complete
+				if err := copyPath(s, d, []string{"mode", "timestamps"}); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if err := srcFS.Remove(s); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		// Fails if some files are skipped, which should be kept in src.
+		srcFS.Remove(src)
+	}
+
+	return errors.Join(errs...)
+}
+
+// copyPath synchronously copies src to dst, discarding progress updates.
+func copyPath(src, dst string, preserve []string) error {
+	nums := make(chan int64, 1024)
+	errs := make(chan error, 1024)
+
+	done := make(chan error)
+	go func() {
+		var all []error
+		for err := range errs {
+			all = append(all, err)
+		}
+		done <- errors.Join(all...)
+	}()
+	go func() {
+		for range nums {
+		}
+	}()
+
+	dirInfos := make(map[string]os.FileInfo)
+	copyTree(src, dst, preserve, dirInfos, nil, nums, errs)
+	restoreDirTimes(dirInfos, errs)
+
+	close(nums)
+	close(errs)
+
+	return <-done
+}
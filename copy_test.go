@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashFile(t *testing.T) {
+	tests := []struct {
+		content string
+		exp     string
+	}{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, "file")
+		if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+		sum, err := hashFile(path, "sha256")
+		if err != nil {
+			t.Errorf("at input '%s' unexpected error: %s", test.content, err)
+			continue
+		}
+		if got := hex.EncodeToString(sum); got != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.content, test.exp, got)
+		}
+	}
+}
+
+func TestCreateTemp(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "a")
+
+	seen := make(map[string]bool)
+	for range 2 {
+		w, tmp, err := createTemp(localFS{}, dst, 0o600)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		w.Close()
+
+		if filepath.Dir(tmp) != filepath.Dir(dst) || !strings.HasPrefix(filepath.Base(tmp), ".a.lf-partial-") {
+			t.Errorf("expected temporary file next to '%s' but got '%s'", dst, tmp)
+		}
+		if seen[tmp] {
+			t.Errorf("expected a new temporary file but got '%s' again", tmp)
+		}
+		seen[tmp] = true
+	}
+}
+
+func TestCopyFileVerifyMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("foo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copyVerifyHook = func(tmp string) {
+		if err := os.WriteFile(tmp, []byte("bar"), 0o644); err != nil {
+			t.Error(err)
+		}
+	}
+	defer func() { copyVerifyHook = nil }()
+
+	j := newJob(1, "copy", []string{src}, dir)
+	j.verify = "sha256"
+
+	nums := make(chan int64, 1024)
+	errs := make(chan error, 1024)
+	copyFile(src, dst, nil, info, j, nums, errs)
+	close(errs)
+
+	var got []error
+	for err := range errs {
+		got = append(got, err)
+	}
+	if len(got) != 1 || !strings.Contains(got[0].Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch but got '%v'", got)
+	}
+	if j.failed != 1 || j.verified != 0 {
+		t.Errorf("expected 1 mismatched file but got %d verified and %d mismatched", j.verified, j.failed)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "src" {
+		t.Errorf("expected only 'src' to be left but got %v", entries)
+	}
+}
+
+func TestCopyToOverwrite(t *testing.T) {
+	tests := []struct {
+		cancel bool
+		exp    string
+	}{
+		{false, "new"},
+		{true, "old"},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src")
+		dst := filepath.Join(dir, "dst")
+		if err := os.WriteFile(src, []byte("new"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		j := newJob(1, "move", []string{src}, dir)
+		if test.cancel {
+			j.stop()
+		}
+
+		nums, errs := copyTo(src, dst, nil, j)
+		go func() {
+			for range nums {
+			}
+		}()
+		for range errs {
+		}
+		close(nums)
+
+		if b, err := os.ReadFile(dst); err != nil || string(b) != test.exp {
+			t.Errorf("at cancel '%t' expected '%s' but got '%s' (%v)", test.cancel, test.exp, b, err)
+		}
+	}
+}
+
+func TestMoveMerge(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	for _, path := range []string{"src/a", "src/sub/b", "dst/sub/c"} {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []journalEntry
+	err := moveMerge(src, dst, newJob(1, "move", []string{src}, dir), func(src, dst string) {
+		got = append(got, journalEntry{src, dst})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := []journalEntry{
+		{filepath.Join(src, "a"), filepath.Join(dst, "a")},
+		{filepath.Join(src, "sub", "b"), filepath.Join(dst, "sub", "b")},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected moved files '%v' but got '%v'", exp, got)
+	}
+	if _, err := os.Lstat(src); !os.IsNotExist(err) {
+		t.Errorf("expected '%s' to be removed", src)
+	}
+}
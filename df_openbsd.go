@@ -17,3 +17,13 @@ func diskFree(wd string) string {
 	// Available blocks * size per block = available space in bytes
 	return "df: " + humanize(int64(stat.F_bavail)*int64(stat.F_bsize))
 }
+
+func deviceID(path string) (uint64, error) {
+	var stat unix.Stat_t
+
+	if err := unix.Lstat(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Dev), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log"
 
 	"golang.org/x/sys/windows"
@@ -21,3 +22,7 @@ func diskFree(wd string) string {
 	}
 	return "df: " + humanize(int64(free))
 }
+
+func deviceID(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The `du` command scans the directory tree below a directory in the
+// background to show where the space is used, similar to ncdu. The sizes of
+// all directories in the tree are kept, so that subdirectories can be entered
+// without scanning them again. Directories in the tree are sorted by size with
+// the percentage of the total shown in the info column, and their sizes are
+// updated whenever they are reloaded, for instance when `watch` detects
+// changes.
+var gDu = struct {
+	sync.Mutex
+	root string
+	tree *duNode
+}{}
+
+// duBarWidth is the number of characters of the percentage bars.
+const duBarWidth = 10
+
+// duNode is a scanned directory.
+type duNode struct {
+	entry int64              // size of the directory entry itself
+	files int64              // total size of the files directly inside
+	size  int64              // total size of the directory tree
+	dirs  map[string]*duNode // subdirectories by name
+}
+
+func (n *duNode) update() {
+	n.size = n.entry + n.files
+	for _, c := range n.dirs {
+		n.size += c.size
+	}
+}
+
+// duLookup returns the node of the given path in the tree scanned at root, or
+// nil if the path is not inside the tree. The lock of gDu should be held.
+func duLookup(path string) *duNode {
+	if gDu.tree == nil {
+		return nil
+	}
+
+	rel, err := filepath.Rel(gDu.root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil
+	}
+	if rel == "." {
+		return gDu.tree
+	}
+
+	n := gDu.tree
+	for name := range strings.SplitSeq(rel, string(filepath.Separator)) {
+		if n = n.dirs[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// duChain updates the sizes of the directories from the given path up to the
+// root of the tree and returns their paths. The lock of gDu should be held.
+func duChain(path string) []string {
+	var nodes []*duNode
+	var paths []string
+	for p := path; ; p = filepath.Dir(p) {
+		if n := duLookup(p); n != nil {
+			nodes = append(nodes, n)
+			paths = append(paths, p)
+		}
+		if p == gDu.root || p == filepath.Dir(p) {
+			break
+		}
+	}
+
+	for _, n := range nodes {
+		n.update()
+	}
+	return paths
+}
+
+func isDuPath(path string) bool {
+	gDu.Lock()
+	defer gDu.Unlock()
+
+	return duLookup(path) != nil
+}
+
+// duSize returns the size of a file counted in the `du` mode, where symbolic
+// links are not counted.
+func duSize(f *file) int64 {
+	switch {
+	case f.linkState != notLink:
+		return 0
+	case f.IsDir():
+		return max(f.dirSize, 0)
+	}
+	return f.Size()
+}
+
+// duOrder sorts the files by size with the largest ones first and returns the
+// total size of all files.
+func duOrder(files, allFiles []*file) int64 {
+	slices.SortStableFunc(files, func(f1, f2 *file) int {
+		return cmp.Compare(duSize(f2), duSize(f1))
+	})
+
+	var total int64
+	for _, f := range allFiles {
+		total += duSize(f)
+	}
+	return total
+}
+
+// duBar returns the percentage of the given size in the total with a bar.
+func duBar(size, total int64) string {
+	var frac float64
+	if total > 0 {
+		frac = float64(size) / float64(total)
+	}
+	n := int(frac*duBarWidth + 0.5)
+	return fmt.Sprintf(" %5.1f%% [%s%s]", 100*frac, strings.Repeat("#", n), strings.Repeat(" ", duBarWidth-n))
+}
+
+// duScanner scans directory trees concurrently, where each subdirectory is
+// scanned in a new goroutine as long as there are less than sem running.
+type duScanner struct {
+	fsys fileSystem
+	sem  chan struct{}
+	wait func() error
+	errs atomic.Int64
+}
+
+func (s *duScanner) scan(path string, info os.FileInfo) (*duNode, error) {
+	if err := s.wait(); err != nil {
+		return nil, err
+	}
+
+	n := &duNode{entry: info.Size(), dirs: make(map[string]*duNode)}
+
+	names, err := s.fsys.Readdirnames(path, -1)
+	if err != nil {
+		log.Printf("du: %s", err)
+		s.errs.Add(1)
+		n.update()
+		return n, nil
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var canceled atomic.Bool
+
+	for _, name := range names {
+		if canceled.Load() {
+			break
+		}
+
+		p := filepath.Join(path, name)
+		st, err := s.fsys.Lstat(p)
+		if err != nil {
+			log.Printf("du: %s", err)
+			s.errs.Add(1)
+			continue
+		}
+
+		if !st.IsDir() {
+			if st.Mode()&os.ModeSymlink == 0 {
+				n.files += st.Size()
+			}
+			continue
+		}
+
+		scanDir := func() {
+			c, err := s.scan(p, st)
+			if err != nil {
+				canceled.Store(true)
+				return
+			}
+			mutex.Lock()
+			n.dirs[name] = c
+			mutex.Unlock()
+		}
+
+		select {
+		case s.sem <- struct{}{}:
+			wg.Go(func() {
+				defer func() { <-s.sem }()
+				scanDir()
+			})
+		default:
+			scanDir()
+		}
+	}
+
+	wg.Wait()
+
+	if canceled.Load() {
+		return nil, errJobCanceled
+	}
+
+	n.update()
+	return n, nil
+}
+
+// duScan is a directory tree scanned with `du`, which is stopped when the
+// `du` mode is left.
+type duScan struct {
+	root    string
+	job     *job
+	stopped atomic.Bool
+}
+
+// duResult is sent when scanning a directory is finished, which is either the
+// root of the tree or a directory created after scanning the tree.
+type duResult struct {
+	scan *duScan
+	path string
+	node *duNode
+	errs int64
+	err  error
+}
+
+func (s *duScan) run(path string, j *job) *duResult {
+	r := &duResult{scan: s, path: path}
+
+	fsys := getFS(path)
+	info, err := fsys.Lstat(path)
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	sc := &duScanner{
+		fsys: fsys,
+		sem:  make(chan struct{}, runtime.NumCPU()),
+		wait: func() error {
+			if s.stopped.Load() {
+				return errJobCanceled
+			}
+			return j.wait()
+		},
+	}
+	r.node, r.err = sc.scan(path, info)
+	r.errs = sc.errs.Load()
+	return r
+}
+
+func (r *duResult) String() string {
+	s := fmt.Sprintf("%s in %s", humanize(r.node.size), r.path)
+	if r.errs > 0 {
+		s += fmt.Sprintf(" (%d errors, see log)", r.errs)
+	}
+	return s
+}
+
+// startDu starts scanning the directory tree at path in the background as a
+// job, which replaces a previous scan.
+func (nav *nav) startDu(path string) {
+	nav.stopDu()
+
+	s := &duScan{root: path}
+	s.job = nav.jobs.add("du", []string{path}, "")
+	nav.du = s
+
+	go func() {
+		defer nav.jobs.remove(s.job)
+		nav.duChan <- s.run(path, s.job)
+	}()
+}
+
+// stopDu stops scanning and shows the directories in the tree as usual.
+func (nav *nav) stopDu() {
+	s := nav.du
+	if s == nil {
+		return
+	}
+	s.stopped.Store(true)
+	nav.du = nil
+
+	gDu.Lock()
+	gDu.root, gDu.tree = "", nil
+	gDu.Unlock()
+
+	for path, d := range nav.dirCache {
+		if rel, err := filepath.Rel(s.root, path); err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		for _, f := range d.allFiles {
+			f.dirSize = -1
+		}
+		nav.duSort(d)
+	}
+}
+
+// duDone adds the scanned directory of the given result to the tree. Errors
+// are only returned for the root of the tree, which stops the `du` mode.
+func (nav *nav) duDone(r *duResult) error {
+	s := nav.du
+	if r.err != nil {
+		if r.path == s.root {
+			nav.stopDu()
+			return r.err
+		}
+		log.Printf("du: %s", r.err)
+		return nil
+	}
+
+	gDu.Lock()
+	var paths []string
+	if r.path == s.root {
+		gDu.root, gDu.tree = r.path, r.node
+	} else if parent := duLookup(filepath.Dir(r.path)); parent != nil {
+		parent.dirs[filepath.Base(r.path)] = r.node
+		paths = duChain(filepath.Dir(r.path))
+	}
+	gDu.Unlock()
+
+	if r.path == s.root {
+		for path := range nav.dirCache {
+			if isDuPath(path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	nav.duRefresh(paths)
+	return nil
+}
+
+// duUpdate updates the sizes in the tree after the given directory is loaded,
+// where new subdirectories are scanned in the background.
+func (nav *nav) duUpdate(d *dir) {
+	if nav.du == nil || getGrep(d.path) != nil || getDuplicates(d.path) {
+		return
+	}
+
+	gDu.Lock()
+	n := duLookup(d.path)
+	if n == nil {
+		gDu.Unlock()
+		return
+	}
+
+	var files int64
+	var missing []string
+	seen := make(map[string]bool)
+	for _, f := range d.allFiles {
+		// nested files in flattened directories are counted in subdirectories
+		if f.linkState != notLink || strings.ContainsRune(f.Name(), filepath.Separator) {
+			continue
+		}
+		if !f.IsDir() {
+			files += f.Size()
+			continue
+		}
+		seen[f.Name()] = true
+		if _, ok := n.dirs[f.Name()]; !ok {
+			missing = append(missing, f.path)
+		}
+	}
+	for name := range n.dirs {
+		if !seen[name] {
+			delete(n.dirs, name)
+		}
+	}
+	n.files = files
+	paths := duChain(d.path)
+	gDu.Unlock()
+
+	for _, path := range missing {
+		s := nav.du
+		go func() {
+			nav.duChan <- s.run(path, nil)
+		}()
+	}
+
+	nav.duRefresh(paths)
+}
+
+// duRefresh updates the sizes of the subdirectories in the cached directories
+// at the given paths from the tree and sorts them again.
+func (nav *nav) duRefresh(paths []string) {
+	gDu.Lock()
+	var dirs []*dir
+	for _, path := range paths {
+		d, ok := nav.dirCache[path]
+		if !ok {
+			continue
+		}
+		n := duLookup(path)
+		if n == nil {
+			continue
+		}
+		for _, f := range d.allFiles {
+			if f.linkState != notLink || !f.IsDir() {
+				continue
+			}
+			if c, ok := n.dirs[f.Name()]; ok {
+				f.dirSize = c.size
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	gDu.Unlock()
+
+	for _, d := range dirs {
+		nav.duSort(d)
+	}
+}
+
+func (nav *nav) duSort(d *dir) {
+	name := d.name()
+	d.sort()
+	d.sel(name, nav.height)
+}
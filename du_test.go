@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDuBar(t *testing.T) {
+	tests := []struct {
+		size  int64
+		total int64
+		exp   string
+	}{
+		{0, 0, "   0.0% [          ]"},
+		{1, 4, "  25.0% [###       ]"},
+		{1, 3, "  33.3% [###       ]"},
+		{4, 4, " 100.0% [##########]"},
+	}
+
+	for _, test := range tests {
+		if got := duBar(test.size, test.total); got != test.exp {
+			t.Errorf("at input (%d, %d) expected '%s' but got '%s'", test.size, test.total, test.exp, got)
+		}
+	}
+}
+
+func TestDuScan(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{
+		"a":       100,
+		"d/b":     200,
+		"d/e/c":   300,
+		"d/e/f/g": 400,
+		"h/i":     500,
+	}
+	for path, size := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(root, "l")); err != nil {
+		t.Fatal(err)
+	}
+
+	dirSize := func(path string) int64 {
+		info, err := os.Lstat(filepath.Join(root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	s := &duScan{root: root}
+	r := s.run(root, nil)
+	if r.err != nil {
+		t.Fatalf("unexpected error: %s", r.err)
+	}
+
+	oldTree := gDu.tree
+	oldRoot := gDu.root
+	gDu.root, gDu.tree = root, r.node
+	t.Cleanup(func() { gDu.root, gDu.tree = oldRoot, oldTree })
+
+	tests := []struct {
+		path string
+		exp  int64
+	}{
+		{"d/e/f", dirSize("d/e/f") + 400},
+		{"d/e", dirSize("d/e") + dirSize("d/e/f") + 700},
+		{"d", dirSize("d") + dirSize("d/e") + dirSize("d/e/f") + 900},
+		{".", dirSize(".") + dirSize("d") + dirSize("d/e") + dirSize("d/e/f") + dirSize("h") + 1500},
+	}
+
+	for _, test := range tests {
+		n := duLookup(filepath.Join(root, test.path))
+		if n == nil {
+			t.Errorf("at input '%s' expected a scanned directory", test.path)
+			continue
+		}
+		if n.size != test.exp {
+			t.Errorf("at input '%s' expected size %d but got %d", test.path, test.exp, n.size)
+		}
+	}
+
+	if n := duLookup(filepath.Dir(root)); n != nil {
+		t.Errorf("expected no scanned directory outside of the root")
+	}
+
+	// sizes of the parent directories are updated after changes
+	n := duLookup(filepath.Join(root, "d", "e"))
+	old := r.node.size
+	n.files += 1000
+	duChain(filepath.Join(root, "d", "e"))
+	if r.node.size != old+1000 {
+		t.Errorf("expected updated size %d but got %d", old+1000, r.node.size)
+	}
+}
+
+func TestDuScanCanceled(t *testing.T) {
+	root := t.TempDir()
+	for i := range runtime.NumCPU() + 2 {
+		if err := os.MkdirAll(filepath.Join(root, string(rune('a'+i)), "x"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := newJob(1, "du", []string{root}, "")
+	if err := j.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &duScan{root: root}
+	if r := s.run(root, j); !errors.Is(r.err, errJobCanceled) {
+		t.Errorf("expected canceled error but got '%v'", r.err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// The `find-duplicates` command replaces the listing of a directory with the
+// regular files below it which have the same contents, similar to flattened
+// directories. Candidates are grouped by size first, so that only files with
+// the same size are hashed. Duplicate listings are kept for each path and
+// looked up when the directory is loaded, which happens in the background.
+var gDuplicates = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+func getDuplicates(path string) bool {
+	gDuplicates.Lock()
+	defer gDuplicates.Unlock()
+
+	return gDuplicates.paths[path]
+}
+
+func setDuplicates(path string, val bool) {
+	gDuplicates.Lock()
+	defer gDuplicates.Unlock()
+
+	if val {
+		gDuplicates.paths[path] = true
+	} else {
+		delete(gDuplicates.paths, path)
+	}
+}
+
+// duplicateTags are shown alternately in the tag column for the files of
+// consecutive groups of duplicates, unless the files are tagged by the user.
+var duplicateTags = [2]string{"=", "+"}
+
+// readdirDuplicates returns the non-empty regular files in the directory tree
+// at path which have the same size and the same SHA-256 hash as another file.
+// Files are named with their paths relative to the directory, and each group
+// of duplicates is numbered starting from 1 with larger files first. Symbolic
+// links are not followed.
+func readdirDuplicates(path string) ([]*file, error) {
+	fsys := getFS(path)
+	sizes := make(map[int64][]string)
+
+	err := walkFS(fsys, path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			log.Printf("find-duplicates: %s", err)
+			return nil
+		}
+		if info.Mode().IsRegular() && info.Size() > 0 {
+			sizes[info.Size()] = append(sizes[info.Size()], p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for _, paths := range sizes {
+		if len(paths) > 1 {
+			candidates = append(candidates, paths...)
+		}
+	}
+
+	sums := hashFiles(candidates)
+
+	// groups of larger files which take more space are listed first
+	keys := slices.Collect(maps.Keys(sizes))
+	slices.SortFunc(keys, func(a, b int64) int { return cmp.Compare(b, a) })
+
+	var groups [][]string
+	for _, size := range keys {
+		bySum := make(map[string][]string)
+		var order []string
+		for _, p := range sizes[size] {
+			sum, ok := sums[p]
+			if !ok {
+				continue
+			}
+			if _, ok := bySum[sum]; !ok {
+				order = append(order, sum)
+			}
+			bySum[sum] = append(bySum[sum], p)
+		}
+		for _, sum := range order {
+			if len(bySum[sum]) > 1 {
+				groups = append(groups, bySum[sum])
+			}
+		}
+	}
+
+	var files []*file
+	for i, group := range groups {
+		for _, p := range group {
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				continue
+			}
+			f := newFile(p)
+			if os.IsNotExist(f.err) {
+				continue
+			}
+			f.FileInfo = &flatInfo{FileInfo: f.FileInfo, name: rel, group: i + 1}
+			files = append(files, f)
+		}
+	}
+
+	return files, nil
+}
+
+// hashFiles hashes the given files concurrently and returns the hashes of the
+// files which could be read.
+func hashFiles(paths []string) map[string]string {
+	sums := make(map[string]string, len(paths))
+	ch := make(chan string)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for range runtime.NumCPU() {
+		wg.Go(func() {
+			for p := range ch {
+				sum, err := hashFile(p, "sha256")
+				if err != nil {
+					log.Printf("find-duplicates: %s", err)
+					continue
+				}
+				mutex.Lock()
+				sums[p] = string(sum)
+				mutex.Unlock()
+			}
+		})
+	}
+
+	for _, p := range paths {
+		ch <- p
+	}
+	close(ch)
+	wg.Wait()
+
+	return sums
+}
+
+// duplicateGroup returns the group of a file listed by `find-duplicates`, or
+// zero for other files.
+func duplicateGroup(f *file) int {
+	if fi, ok := f.FileInfo.(*flatInfo); ok {
+		return fi.group
+	}
+	return 0
+}
+
+// duplicateOrder moves the files of each group of duplicates next to the first
+// file of the group in the given order, keeping the order within the groups.
+func duplicateOrder(files []*file) {
+	rank := make(map[int]int)
+	for _, f := range files {
+		if _, ok := rank[duplicateGroup(f)]; !ok {
+			rank[duplicateGroup(f)] = len(rank)
+		}
+	}
+
+	slices.SortStableFunc(files, func(f1, f2 *file) int {
+		return cmp.Compare(rank[duplicateGroup(f1)], rank[duplicateGroup(f2)])
+	})
+}
+
+// duplicateTag returns the tag shown for a file listed by `find-duplicates`.
+func duplicateTag(f *file) string {
+	group := duplicateGroup(f)
+	if group == 0 {
+		return ""
+	}
+	return duplicateTags[(group-1)%2]
+}
+
+// selectDuplicates selects all files listed by `find-duplicates` in the
+// current directory except the first one of each group, and returns the number
+// of selected files.
+func (nav *nav) selectDuplicates() (int, error) {
+	dir := nav.currDir()
+	if !getDuplicates(dir.path) {
+		return 0, errors.New("not a listing of duplicates")
+	}
+
+	count := 0
+	kept := make(map[int]bool)
+	for _, f := range dir.files {
+		group := duplicateGroup(f)
+		if group == 0 {
+			continue
+		}
+		if !kept[group] {
+			kept[group] = true
+			continue
+		}
+		if _, ok := nav.selections[f.path]; !ok {
+			nav.toggleSelection(f.path)
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReaddirDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a":       "same",
+		"b/a":     "same",
+		"b/c/a":   "same",
+		"x":       "other",
+		"y":       "othe2",
+		"z":       "longer file",
+		"b/z":     "longer file",
+		"empty":   "",
+		"b/empty": "",
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(dir, "l")); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := readdirDuplicates(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	groups := make(map[int][]string)
+	for _, f := range list {
+		if f.path != filepath.Join(dir, f.Name()) {
+			t.Errorf("expected path '%s' but got '%s'", filepath.Join(dir, f.Name()), f.path)
+		}
+		g := duplicateGroup(f)
+		groups[g] = append(groups[g], filepath.ToSlash(f.Name()))
+	}
+	for _, g := range groups {
+		slices.Sort(g)
+	}
+
+	exp := map[int][]string{
+		1: {"b/z", "z"},
+		2: {"a", "b/a", "b/c/a"},
+	}
+	if len(groups) != len(exp) {
+		t.Errorf("expected groups '%v' but got '%v'", exp, groups)
+	}
+	for g, names := range exp {
+		if !slices.Equal(groups[g], names) {
+			t.Errorf("at group %d expected '%v' but got '%v'", g, names, groups[g])
+		}
+	}
+}
+
+func TestDuplicateOrder(t *testing.T) {
+	tests := []struct {
+		groups []int
+		exp    []string
+	}{
+		{[]int{1, 2, 1, 2}, []string{"0", "2", "1", "3"}},
+		{[]int{2, 1, 1, 2}, []string{"0", "3", "1", "2"}},
+		{[]int{3, 1, 2, 1, 3, 2}, []string{"0", "4", "1", "3", "2", "5"}},
+	}
+
+	for _, test := range tests {
+		var files []*file
+		for i, g := range test.groups {
+			name := string(rune('0' + i))
+			files = append(files, &file{FileInfo: &flatInfo{FileInfo: &fakeStat{name: name}, name: name, group: g}})
+		}
+
+		duplicateOrder(files)
+
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		if !slices.Equal(names, test.exp) {
+			t.Errorf("at input '%v' expected '%v' but got '%v'", test.groups, test.exp, names)
+		}
+	}
+}
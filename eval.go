@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -202,6 +203,12 @@ func (e *setExpr) eval(app *app, _ []string) {
 		gOpts.cleaner = replaceTilde(e.val)
 	case "copyfmt":
 		gOpts.copyfmt = e.val
+	case "copyverify":
+		if !isValidVerifyMethod(e.val) {
+			app.ui.echoerr("copyverify: value should either be 'none' or 'sha256'")
+			return
+		}
+		gOpts.copyverify = e.val
 	case "cursoractivefmt":
 		gOpts.cursoractivefmt = e.val
 	case "cursorparentfmt":
@@ -229,6 +236,12 @@ func (e *setExpr) eval(app *app, _ []string) {
 		app.ui.renew()
 		app.nav.resize(app.ui)
 		app.ui.loadFile(app, true)
+	case "deletemethod":
+		if e.val != "remove" && e.val != "trash" {
+			app.ui.echoerr("deletemethod: value should either be 'remove' or 'trash'")
+			return
+		}
+		gOpts.deletemethod = e.val
 	case "dupfilefmt":
 		gOpts.dupfilefmt = e.val
 	case "errorfmt":
@@ -284,9 +297,9 @@ func (e *setExpr) eval(app *app, _ []string) {
 		toks := strings.Split(e.val, ":")
 		for _, s := range toks {
 			switch s {
-			case "size", "time", "atime", "btime", "ctime", "perm", "user", "group", "custom":
+			case "size", "time", "atime", "btime", "ctime", "perm", "user", "group", "custom", "git":
 			default:
-				app.ui.echoerr("info: should consist of 'size', 'time', 'atime', 'btime', 'ctime', 'perm', 'user', 'group' or 'custom' separated with colon")
+				app.ui.echoerr("info: should consist of 'size', 'time', 'atime', 'btime', 'ctime', 'perm', 'user', 'group', 'custom' or 'git' separated with colon")
 				return
 			}
 		}
@@ -295,6 +308,27 @@ func (e *setExpr) eval(app *app, _ []string) {
 		gOpts.infotimefmtnew = e.val
 	case "infotimefmtold":
 		gOpts.infotimefmtold = e.val
+	case "layout":
+		if e.val != "miller" && e.val != "dual" && e.val != "tree" {
+			app.ui.echoerr("layout: value should either be 'miller', 'dual' or 'tree'")
+			return
+		}
+		gOpts.layout = e.val
+		if isDualLayout() {
+			app.nav.initPane()
+		}
+		if !isTreeLayout() {
+			for _, path := range clearTree() {
+				if d, ok := app.nav.dirCache[path]; ok {
+					app.nav.reloadTree(d)
+				}
+			}
+		}
+		app.nav.sort()
+		app.ui.renew()
+		app.nav.resize(app.ui)
+		app.ui.sxScreen.forceClear = true
+		app.ui.loadFile(app, true)
 	case "menufmt":
 		gOpts.menufmt = e.val
 	case "menuheaderfmt":
@@ -305,6 +339,12 @@ func (e *setExpr) eval(app *app, _ []string) {
 		gOpts.numbercursorfmt = e.val
 	case "numberfmt":
 		gOpts.numberfmt = e.val
+	case "pasteconflict":
+		if !isValidConflictPolicy(conflictPolicy(e.val)) {
+			app.ui.echoerr(invalidConflictErrorMessage)
+			return
+		}
+		gOpts.pasteconflict = conflictPolicy(e.val)
 	case "period":
 		n, err := strconv.Atoi(e.val)
 		if err != nil {
@@ -361,7 +401,7 @@ func (e *setExpr) eval(app *app, _ []string) {
 			return
 		}
 		gOpts.ratios = rats
-		app.ui.wins = getWins(app.ui.screen)
+		app.ui.wins = getWins(app.ui.screen, app.ui.tabbar)
 		app.nav.resize(app.ui)
 		app.ui.loadFile(app, true)
 	case "rulerfile", "norulerfile", "rulerfile!":
@@ -508,7 +548,7 @@ func (e *setExpr) eval(app *app, _ []string) {
 
 func (e *setLocalExpr) eval(app *app, _ []string) {
 	var err error
-	e.path, err = filepath.Abs(replaceTilde(e.path))
+	e.path, err = absPath(e.path)
 	if err != nil {
 		app.ui.echoerrf("setlocal: %s", err)
 		return
@@ -559,9 +599,9 @@ func (e *setLocalExpr) eval(app *app, _ []string) {
 		toks := strings.Split(e.val, ":")
 		for _, s := range toks {
 			switch s {
-			case "size", "time", "atime", "btime", "ctime", "perm", "user", "group", "custom":
+			case "size", "time", "atime", "btime", "ctime", "perm", "user", "group", "custom", "git":
 			default:
-				app.ui.echoerr("info: should consist of 'size', 'time', 'atime', 'btime', 'ctime', 'perm', 'user', 'group' or 'custom' separated with colon")
+				app.ui.echoerr("info: should consist of 'size', 'time', 'atime', 'btime', 'ctime', 'perm', 'user', 'group', 'custom' or 'git' separated with colon")
 				return
 			}
 		}
@@ -644,6 +684,7 @@ func preChdir(app *app) {
 
 func onChdir(app *app) {
 	app.nav.addJumpList()
+	emitEvent("cd", map[string]any{"path": app.nav.currDir().path})
 	if cmd, ok := gOpts.cmds["on-cd"]; ok {
 		cmd.eval(app, nil)
 	}
@@ -681,6 +722,9 @@ func onRedraw(app *app) {
 
 func onSelect(app *app) {
 	app.nav.preload()
+	if curr := app.nav.currFile(); curr != nil {
+		emitEvent("cursor", map[string]any{"path": curr.path})
+	}
 	if cmd, ok := gOpts.cmds["on-select"]; ok {
 		cmd.eval(app, nil)
 	}
@@ -748,6 +792,11 @@ func update(app *app) {
 		} else if old != dir.ind {
 			app.ui.loadFile(app, true)
 		}
+	case app.ui.cmdPrefix == "rename-pattern: ":
+		app.ui.menu = app.previewPatternRenames(app.ui.cmdAccLeft + app.ui.cmdAccRight)
+	case app.ui.cmdPrefix == "fuzzy-find: ":
+		app.nav.fuzzy.setQuery(app.ui.cmdAccLeft + app.ui.cmdAccRight)
+		app.listFuzzy()
 	case gOpts.incfilter && app.ui.cmdPrefix == "filter: ":
 		filter := app.ui.cmdAccLeft + app.ui.cmdAccRight
 		dir := app.nav.currDir()
@@ -800,6 +849,11 @@ func normal(app *app) {
 	app.cmdHistoryInd = 0
 	app.cmdHistoryInput = nil
 
+	if app.nav.fuzzy != nil {
+		app.nav.fuzzy.stop()
+		app.nav.fuzzy = nil
+	}
+
 	app.ui.cmdAccLeft = ""
 	app.ui.cmdAccRight = ""
 	app.ui.cmdPrefix = ""
@@ -813,6 +867,9 @@ func insert(app *app, arg string) {
 	case gOpts.incfilter && app.ui.cmdPrefix == "filter: ":
 		app.ui.cmdAccLeft += arg
 		update(app)
+	case app.ui.cmdPrefix == "rename-pattern: " || app.ui.cmdPrefix == "fuzzy-find: ":
+		app.ui.cmdAccLeft += arg
+		update(app)
 	case app.ui.cmdPrefix == "find: ":
 		app.nav.find = app.ui.cmdAccLeft + arg + app.ui.cmdAccRight
 
@@ -878,6 +935,39 @@ func insert(app *app, arg string) {
 			app.nav.unselect()
 			app.ui.loadFile(app, true)
 		}
+	case strings.HasPrefix(app.ui.cmdPrefix, "paste: "):
+		normal(app)
+
+		switch arg {
+		case "o", "O":
+			app.answerConflict(conflictOverwrite, arg == "O")
+		case "r", "R":
+			app.answerConflict(conflictRename, arg == "R")
+		case "S":
+			app.answerConflict(conflictSkip, true)
+		default:
+			app.answerConflict(conflictSkip, false)
+		}
+	case strings.HasPrefix(app.ui.cmdPrefix, "bulk-rename"):
+		normal(app)
+
+		if arg != "y" {
+			app.nav.renamePlan = nil
+			return
+		}
+		if err := app.commitRenames("bulk-rename"); err != nil {
+			app.ui.echoerrf("bulk-rename: %s", err)
+		}
+	case strings.HasPrefix(app.ui.cmdPrefix, "trash-empty"):
+		normal(app)
+
+		if arg == "y" {
+			if err := emptyTrash(app.nav.currDir().path); err != nil {
+				app.ui.echoerrf("trash-empty: %s", err)
+				return
+			}
+			app.ui.echo("trash-empty: trash is emptied")
+		}
 	case strings.HasPrefix(app.ui.cmdPrefix, "replace"):
 		normal(app)
 
@@ -901,7 +991,7 @@ func insert(app *app, arg string) {
 		normal(app)
 
 		if arg == "y" {
-			if err := os.MkdirAll(filepath.Dir(app.nav.renameNewPath), os.ModePerm); err != nil {
+			if err := getFS(app.nav.renameNewPath).MkdirAll(filepath.Dir(app.nav.renameNewPath), os.ModePerm); err != nil {
 				app.ui.echoerrf("rename: %s", err)
 				return
 			}
@@ -991,7 +1081,7 @@ func insert(app *app, arg string) {
 func cd(app *app, path string) error {
 	wd := app.nav.currDir().path
 
-	path, err := filepath.Abs(replaceTilde(path))
+	path, err := absPath(path)
 	if err != nil {
 		return fmt.Errorf("getting absolute path: %w", err)
 	}
@@ -1097,7 +1187,8 @@ func (e *callExpr) eval(app *app, _ []string) {
 			return
 		}
 
-		if curr.IsDir() {
+		// archives are opened as read-only directories
+		if curr.IsDir() || isArchiveFile(curr) {
 			resetIncCmd(app)
 			preChdir(app)
 			err := app.nav.open()
@@ -1115,6 +1206,11 @@ func (e *callExpr) eval(app *app, _ []string) {
 				return
 			}
 
+			if isArchiveMember(curr.path) {
+				app.ui.echoerrf("open: %s, copy the file out of the archive first", errArchiveReadOnly)
+				return
+			}
+
 			if cmd, ok := gOpts.cmds["open"]; ok {
 				cmd.eval(app, e.args)
 			}
@@ -1176,13 +1272,13 @@ func (e *callExpr) eval(app *app, _ []string) {
 			app.nav.toggle()
 		} else {
 			for _, path := range e.args {
-				path, err := filepath.Abs(replaceTilde(path))
+				path, err := absPath(path)
 				if err != nil {
 					app.ui.echoerrf("toggle: %s", err)
 					continue
 				}
 
-				if _, err := os.Lstat(path); os.IsNotExist(err) {
+				if _, err := getFS(path).Lstat(path); os.IsNotExist(err) {
 					app.ui.echoerrf("toggle: %s", err)
 					continue
 				}
@@ -1229,6 +1325,83 @@ func (e *callExpr) eval(app *app, _ []string) {
 				return
 			}
 		}
+	case "archive":
+		if cmd, ok := gOpts.cmds["archive"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if len(e.args) != 1 {
+			app.ui.echoerr("archive: requires an argument")
+			return
+		}
+
+		list, err := app.nav.currFileOrSelections()
+		if err != nil {
+			app.ui.echoerrf("archive: %s", err)
+			return
+		}
+		if slices.ContainsFunc(list, isArchiveMember) {
+			app.ui.echoerr("archive: files inside archives should be extracted first")
+			return
+		}
+
+		dst := replaceTilde(e.args[0])
+		if !filepath.IsAbs(dst) {
+			dst = filepath.Join(app.nav.currDir().path, dst)
+		}
+		if _, _, ok := splitArchive(filepath.Dir(dst)); ok {
+			app.ui.echoerrf("archive: %s", errArchiveReadOnly)
+			return
+		}
+		if isRemote(dst) || slices.ContainsFunc(list, isRemote) {
+			app.ui.echoerr("archive: not supported on remote hosts")
+			return
+		}
+		if !canCreateArchive(dst) {
+			app.ui.echoerr("archive: name should end with '.zip', '.tar', '.tar.gz' or '.tgz'")
+			return
+		}
+		if _, err := getFS(dst).Lstat(dst); err == nil {
+			app.ui.echoerrf("archive: %s already exists", dst)
+			return
+		}
+
+		app.nav.unselect()
+		go app.nav.archiveAsync(app, list, dst)
+	case "extract":
+		if cmd, ok := gOpts.cmds["extract"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		curr := app.nav.currFile()
+		if curr == nil {
+			app.ui.echoerr("extract: empty directory")
+			return
+		}
+		if !isArchiveFile(curr) {
+			app.ui.echoerrf("extract: not an archive: %s", curr.Name())
+			return
+		}
+
+		dstDir := app.nav.currDir().path
+		if len(e.args) > 0 {
+			dstDir = replaceTilde(e.args[0])
+			if !filepath.IsAbs(dstDir) {
+				dstDir = filepath.Join(app.nav.currDir().path, dstDir)
+			}
+		}
+		if _, _, ok := splitArchive(dstDir); ok {
+			app.ui.echoerrf("extract: %s", errArchiveReadOnly)
+			return
+		}
+		if isRemote(dstDir) {
+			app.ui.echoerr("extract: not supported on remote hosts")
+			return
+		}
+
+		go app.nav.extractAsync(app, curr.path, dstDir)
 	case "cut":
 		if err := app.nav.save(clipboardCut); err != nil {
 			app.ui.echoerrf("cut: %s", err)
@@ -1249,9 +1422,19 @@ func (e *callExpr) eval(app *app, _ []string) {
 	case "paste":
 		if cmd, ok := gOpts.cmds["paste"]; ok {
 			cmd.eval(app, e.args)
-		} else if err := app.nav.paste(app); err != nil {
-			app.ui.echoerrf("paste: %s", err)
-			return
+		} else {
+			conflict := gOpts.pasteconflict
+			if len(e.args) > 0 {
+				conflict = conflictPolicy(e.args[0])
+				if !isValidConflictPolicy(conflict) {
+					app.ui.echoerr(invalidConflictErrorMessage)
+					return
+				}
+			}
+			if err := app.nav.paste(app, conflict); err != nil {
+				app.ui.echoerrf("paste: %s", err)
+				return
+			}
 		}
 		app.ui.loadFile(app, true)
 	case "clear":
@@ -1308,6 +1491,11 @@ func (e *callExpr) eval(app *app, _ []string) {
 				return
 			}
 
+			if slices.ContainsFunc(list, isArchiveMember) {
+				app.ui.echoerrf("delete: %s", errArchiveReadOnly)
+				return
+			}
+
 			if app.ui.cmdPrefix == ">" {
 				return
 			}
@@ -1318,6 +1506,73 @@ func (e *callExpr) eval(app *app, _ []string) {
 				app.ui.cmdPrefix = "delete " + strconv.Itoa(len(list)) + " items? [y/N] "
 			}
 		}
+	case "bulk-rename":
+		if cmd, ok := gOpts.cmds["bulk-rename"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if app.ui.cmdPrefix == ">" {
+			return
+		}
+
+		paths := app.nav.renameTargets()
+		if len(paths) == 0 {
+			app.ui.echoerr("bulk-rename: empty directory")
+			return
+		}
+
+		renames, err := app.bulkRename(paths)
+		if err != nil {
+			app.ui.echoerrf("bulk-rename: %s", err)
+			return
+		}
+		if len(renames) == 0 {
+			app.ui.echo("bulk-rename: no files renamed")
+			return
+		}
+
+		steps, err := planRenames(renames, func(path string) bool {
+			_, err := getFS(path).Lstat(path)
+			return err == nil
+		})
+		if err != nil {
+			app.ui.echoerrf("bulk-rename: %s", err)
+			return
+		}
+
+		normal(app)
+		app.nav.renamePlan = steps
+		app.ui.menu = listRenames(renames, app.nav.currDir().path, app.ui.msgWin.y)
+		app.ui.cmdPrefix = "bulk-rename: rename " + strconv.Itoa(len(renames)) + " files? [y/N] "
+	case "rename-pattern":
+		if cmd, ok := gOpts.cmds["rename-pattern"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if app.ui.cmdPrefix == ">" {
+			return
+		}
+		normal(app)
+		app.ui.cmdPrefix = "rename-pattern: "
+		app.ui.cmdAccLeft = strings.Join(e.args, " ")
+		update(app)
+	case "fuzzy-find":
+		if cmd, ok := gOpts.cmds["fuzzy-find"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if app.ui.cmdPrefix == ">" {
+			return
+		}
+		normal(app)
+		dir := app.nav.currDir()
+		app.nav.fuzzy = newFuzzyFinder(dir.path, getHidden(dir.path), gOpts.hiddenfiles, app.nav.fuzzyChan)
+		app.ui.cmdPrefix = "fuzzy-find: "
+		app.ui.cmdAccLeft = strings.Join(e.args, " ")
+		update(app)
 	case "rename":
 		if cmd, ok := gOpts.cmds["rename"]; ok {
 			cmd.eval(app, e.args)
@@ -1336,6 +1591,10 @@ func (e *callExpr) eval(app *app, _ []string) {
 				app.ui.echoerr("rename: empty directory")
 				return
 			}
+			if isArchiveMember(curr.path) {
+				app.ui.echoerrf("rename: %s", errArchiveReadOnly)
+				return
+			}
 			if app.ui.cmdPrefix == ">" {
 				return
 			}
@@ -1350,6 +1609,483 @@ func (e *callExpr) eval(app *app, _ []string) {
 				app.ui.cmdAccRight = extension
 			}
 		}
+	case "trash-list":
+		if cmd, ok := gOpts.cmds["trash-list"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		entries, err := readTrash(app.nav.currDir().path)
+		if err != nil {
+			app.ui.echoerrf("trash-list: %s", err)
+		}
+		if len(entries) == 0 {
+			app.ui.echo("trash-list: trash is empty")
+			return
+		}
+		app.runPager(listTrash(entries))
+	case "trash-restore":
+		if cmd, ok := gOpts.cmds["trash-restore"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		wd := app.nav.currDir().path
+		entries, err := readTrash(wd)
+		if err != nil {
+			app.ui.echoerrf("trash-restore: %s", err)
+			return
+		}
+
+		// Restore the most recently trashed entry for each given path, or all
+		// entries trashed from the current directory when no path is given.
+		var restore []trashEntry
+		if len(e.args) == 0 {
+			for _, entry := range entries {
+				if filepath.Dir(entry.path) == wd {
+					restore = append(restore, entry)
+				}
+			}
+		} else {
+			for _, arg := range e.args {
+				path := replaceTilde(arg)
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(wd, path)
+				}
+				path = filepath.Clean(path)
+				i := len(entries) - 1
+				for i >= 0 && entries[i].path != path {
+					i--
+				}
+				if i < 0 {
+					app.ui.echoerrf("trash-restore: not found in trash: %s", path)
+					return
+				}
+				restore = append(restore, entries[i])
+			}
+		}
+
+		if len(restore) == 0 {
+			app.ui.echoerr("trash-restore: no files to restore")
+			return
+		}
+
+		for _, entry := range restore {
+			if err := untrashFile(entry.trashed, entry.path); err != nil {
+				app.ui.echoerrf("trash-restore: %s", err)
+				return
+			}
+		}
+
+		if gSingleMode {
+			app.nav.renew()
+			app.ui.loadFile(app, true)
+		} else {
+			if _, err := remote("send load"); err != nil {
+				app.ui.echoerrf("trash-restore: %s", err)
+				return
+			}
+		}
+		app.ui.echo(fmt.Sprintf("trash-restore: %d items restored", len(restore)))
+	case "trash-empty":
+		if cmd, ok := gOpts.cmds["trash-empty"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		entries, err := readTrash(app.nav.currDir().path)
+		if err != nil {
+			app.ui.echoerrf("trash-empty: %s", err)
+			return
+		}
+		if len(entries) == 0 {
+			app.ui.echo("trash-empty: trash is empty")
+			return
+		}
+		if app.ui.cmdPrefix == ">" {
+			return
+		}
+		normal(app)
+		app.ui.cmdPrefix = "trash-empty: remove " + strconv.Itoa(len(entries)) + " items permanently? [y/N] "
+	case "flatten":
+		if cmd, ok := gOpts.cmds["flatten"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		dir := app.nav.currDir()
+		depth := -1
+		if len(e.args) == 0 {
+			if getFlatten(dir.path) != 0 {
+				depth = 0
+			}
+		} else {
+			n, err := strconv.Atoi(e.args[0])
+			if err != nil || n < 0 {
+				app.ui.echoerrf("flatten: invalid depth: %s", e.args[0])
+				return
+			}
+			depth = n
+		}
+		setFlatten(dir.path, depth)
+		dir.loading = true
+		go func() {
+			app.nav.dirChan <- newDir(dir.path)
+		}()
+	case "tree-open", "tree-close", "tree-toggle":
+		if cmd, ok := gOpts.cmds[e.name]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if !isTreeLayout() {
+			app.ui.echoerrf("%s: requires 'layout' to be 'tree'", e.name)
+			return
+		}
+		dir := app.nav.currDir()
+		curr := app.nav.currFile()
+		if curr == nil {
+			return
+		}
+		name := curr.Name()
+		expanded := isTreeExpanded(dir.path, name)
+		switch {
+		case e.name == "tree-open" || (e.name == "tree-toggle" && !expanded):
+			if !curr.IsDir() || expanded {
+				return
+			}
+			setTreeExpanded(dir.path, name, true)
+		case expanded:
+			setTreeExpanded(dir.path, name, false)
+		case e.name == "tree-close":
+			// collapse the parent directory when the cursor is on a collapsed entry
+			parent := filepath.Dir(name)
+			if parent == "." {
+				return
+			}
+			setTreeExpanded(dir.path, parent, false)
+			dir.sel(parent, app.nav.height)
+		default:
+			return
+		}
+		app.nav.reloadTree(dir)
+	case "grep":
+		if cmd, ok := gOpts.cmds["grep"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		dir := app.nav.currDir()
+		var re *regexp.Regexp
+		if len(e.args) != 0 {
+			var err error
+			re, err = compileGrep(strings.Join(e.args, " "))
+			if err != nil {
+				app.ui.echoerrf("grep: %s", err)
+				return
+			}
+		} else if getGrep(dir.path) == nil {
+			app.ui.echoerr("grep: requires an argument")
+			return
+		}
+		setGrep(dir.path, re)
+		dir.loading = true
+		go func() {
+			d := newDir(dir.path)
+			// discard the results of a search that has been replaced in the meantime
+			if getGrep(dir.path) == re {
+				app.nav.dirChan <- d
+			}
+		}()
+	case "find-duplicates":
+		if cmd, ok := gOpts.cmds["find-duplicates"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		path := app.nav.currDir().path
+		val := !getDuplicates(path)
+		if len(e.args) != 0 {
+			p, err := absPath(e.args[0])
+			if err != nil {
+				app.ui.echoerrf("find-duplicates: %s", err)
+				return
+			}
+			if p != path {
+				app.changeContext("find-duplicates", func() error { return app.nav.cd(p) })
+				if app.nav.currDir().path != p {
+					return
+				}
+			}
+			path, val = p, true
+		}
+		setDuplicates(path, val)
+		dir := app.nav.currDir()
+		dir.loading = true
+		go func() {
+			d := newDir(dir.path)
+			// discard the results of a search that has been cleared in the meantime
+			if getDuplicates(dir.path) == val {
+				app.nav.dirChan <- d
+			}
+		}()
+	case "du":
+		if cmd, ok := gOpts.cmds["du"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		path := app.nav.currDir().path
+		if len(e.args) == 0 && app.nav.du != nil {
+			app.nav.stopDu()
+			return
+		}
+		if len(e.args) != 0 {
+			p, err := absPath(e.args[0])
+			if err != nil {
+				app.ui.echoerrf("du: %s", err)
+				return
+			}
+			if p != path {
+				app.changeContext("du", func() error { return app.nav.cd(p) })
+				if app.nav.currDir().path != p {
+					return
+				}
+			}
+			path = p
+		}
+		app.nav.startDu(path)
+		app.ui.echo("du: scanning...")
+	case "duplicates-select":
+		if cmd, ok := gOpts.cmds["duplicates-select"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		count, err := app.nav.selectDuplicates()
+		if err != nil {
+			app.ui.echoerrf("duplicates-select: %s", err)
+			return
+		}
+		app.ui.loadFile(app, true)
+		app.ui.echo(fmt.Sprintf("duplicates-select: %d files selected", count))
+	case "tab-new":
+		if cmd, ok := gOpts.cmds["tab-new"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		path := app.nav.currDir().path
+		if len(e.args) != 0 {
+			p, err := absPath(e.args[0])
+			if err != nil {
+				app.ui.echoerrf("tab-new: %s", err)
+				return
+			}
+			path = p
+		}
+		app.changeContext("tab-new", func() error { return app.nav.newTab(path) })
+	case "tab-close":
+		if cmd, ok := gOpts.cmds["tab-close"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		app.changeContext("tab-close", app.nav.closeTab)
+	case "tab-next", "tab-prev":
+		if cmd, ok := gOpts.cmds[e.name]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if len(app.nav.tabs) == 1 {
+			return
+		}
+		n := len(app.nav.tabs)
+		count := max(e.count, 1) % n
+		if e.name == "tab-prev" {
+			count = n - count
+		}
+		ind := (app.nav.tabInd + count) % n
+		app.changeContext(e.name, func() error { return app.nav.switchTab(ind) })
+	case "tab-goto":
+		if cmd, ok := gOpts.cmds["tab-goto"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if len(e.args) != 1 {
+			app.ui.echoerr("tab-goto: requires a tab number")
+			return
+		}
+		n, err := strconv.Atoi(e.args[0])
+		if err != nil {
+			app.ui.echoerrf("tab-goto: invalid tab number: %s", e.args[0])
+			return
+		}
+		app.changeContext("tab-goto", func() error { return app.nav.switchTab(n - 1) })
+	case "pane-switch":
+		if cmd, ok := gOpts.cmds["pane-switch"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		app.changeContext("pane-switch", app.nav.switchPane)
+	case "compare":
+		if cmd, ok := gOpts.cmds["compare"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if len(e.args) == 0 {
+			app.nav.stopCompare()
+			return
+		}
+		if len(e.args) > 3 || len(e.args) < 2 {
+			app.ui.echoerr("compare: requires two directories and an optional method")
+			return
+		}
+		method := "size"
+		if len(e.args) == 3 {
+			method = e.args[2]
+			if !isValidCompareMethod(method) {
+				app.ui.echoerr("compare: method should either be 'size' or 'hash'")
+				return
+			}
+		}
+		var dirs [2]string
+		for i, arg := range e.args[:2] {
+			path, err := absPath(arg)
+			if err != nil {
+				app.ui.echoerrf("compare: %s", err)
+				return
+			}
+			if info, err := getFS(path).Stat(path); err != nil {
+				app.ui.echoerrf("compare: %s", err)
+				return
+			} else if !info.IsDir() {
+				app.ui.echoerrf("compare: not a directory: %s", path)
+				return
+			}
+			dirs[i] = path
+		}
+		if !isDualLayout() {
+			(&setExpr{"layout", "dual"}).eval(app, nil)
+		}
+		prev := app.nav.comparison
+		app.changeContext("compare", func() error { return app.nav.compare(dirs[0], dirs[1], method) })
+		if app.nav.comparison != prev {
+			app.ui.echo("compare: comparing...")
+		}
+	case "compare-select":
+		if cmd, ok := gOpts.cmds["compare-select"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if err := app.nav.compareSelect(); err != nil {
+			app.ui.echoerrf("compare-select: %s", err)
+			return
+		}
+		app.ui.loadFile(app, true)
+	case "compare-copy":
+		if cmd, ok := gOpts.cmds["compare-copy"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		plan, err := app.nav.comparePlan()
+		if err != nil {
+			app.ui.echoerrf("compare-copy: %s", err)
+			return
+		}
+		go app.nav.compareCopyAsync(app, app.nav.comparison, plan, app.nav.comparison.copyPreserve())
+	case "pane-copy", "pane-move":
+		if cmd, ok := gOpts.cmds[e.name]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		dstDir, err := app.nav.paneTarget()
+		if err != nil {
+			app.ui.echoerrf("%s: %s", e.name, err)
+			return
+		}
+		conflict := gOpts.pasteconflict
+		if len(e.args) > 0 {
+			conflict = conflictPolicy(e.args[0])
+			if !isValidConflictPolicy(conflict) {
+				app.ui.echoerr(invalidConflictErrorMessage)
+				return
+			}
+		}
+		list, err := app.nav.currFileOrSelections()
+		if err != nil {
+			app.ui.echoerrf("%s: %s", e.name, err)
+			return
+		}
+		if e.name == "pane-copy" {
+			go app.nav.copyAsync(app, list, dstDir, conflict, gOpts.preserve)
+		} else {
+			go app.nav.moveAsync(app, list, dstDir, conflict)
+		}
+	case "jobs":
+		if cmd, ok := gOpts.cmds["jobs"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if app.nav.jobs.len() == 0 {
+			app.ui.echo("jobs: no jobs running")
+			return
+		}
+		app.runPager(app.nav.jobs.String())
+	case "job-cancel", "job-pause", "job-resume":
+		if cmd, ok := gOpts.cmds[e.name]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		if len(e.args) != 1 {
+			app.ui.echoerrf("%s: requires a job id", e.name)
+			return
+		}
+		id, err := strconv.Atoi(e.args[0])
+		if err != nil {
+			app.ui.echoerrf("%s: invalid job id: %s", e.name, e.args[0])
+			return
+		}
+		j, err := app.nav.jobs.get(id)
+		if err != nil {
+			app.ui.echoerrf("%s: %s", e.name, err)
+			return
+		}
+		switch e.name {
+		case "job-cancel":
+			err = j.stop()
+		case "job-pause":
+			err = j.pause()
+		case "job-resume":
+			err = j.unpause()
+		}
+		if err != nil {
+			app.ui.echoerrf("%s: %s", e.name, err)
+			return
+		}
+	case "undo":
+		if cmd, ok := gOpts.cmds["undo"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		go app.nav.undo(app)
+	case "redo":
+		if cmd, ok := gOpts.cmds["redo"]; ok {
+			cmd.eval(app, e.args)
+			return
+		}
+
+		go app.nav.redo(app)
 	case "read":
 		if app.ui.cmdPrefix == ">" {
 			return
@@ -1575,13 +2311,16 @@ func (e *callExpr) eval(app *app, _ []string) {
 		}
 
 		path := replaceTilde(e.args[0])
-		lstat, err := os.Lstat(path)
-		if err != nil {
+		var lstat os.FileInfo
+		var err error
+		if isArchiveMember(path) {
+			lstat = &fakeStat{name: filepath.Base(path)}
+		} else if lstat, err = getFS(path).Lstat(path); err != nil {
 			app.ui.echoerrf("select: %s", err)
 			return
 		}
 
-		path, err = filepath.Abs(replaceTilde(e.args[0]))
+		path, err = absPath(e.args[0])
 		if err != nil {
 			app.ui.echoerrf("select: %s", err)
 			return
@@ -1626,7 +2365,7 @@ func (e *callExpr) eval(app *app, _ []string) {
 			return
 		}
 
-		path, err := filepath.Abs(replaceTilde(k))
+		path, err := absPath(k)
 		if err != nil {
 			app.ui.echoerrf("addcustominfo: %s", err)
 			return
@@ -1732,12 +2471,26 @@ func (e *callExpr) eval(app *app, _ []string) {
 		if app.ui.cmdPrefix == ">" {
 			return
 		}
+		if strings.HasPrefix(app.ui.cmdPrefix, "paste: ") {
+			normal(app)
+			app.answerConflict(conflictSkip, false)
+			return
+		}
 		normal(app)
 	case "cmd-complete":
+		if app.moveFuzzy(1) {
+			return
+		}
 		app.doComplete()
 	case "cmd-menu-complete":
+		if app.moveFuzzy(1) {
+			return
+		}
 		app.menuComplete(1)
 	case "cmd-menu-complete-back":
+		if app.moveFuzzy(-1) {
+			return
+		}
 		app.menuComplete(-1)
 	case "cmd-menu-accept":
 		exitCompMenu(app)
@@ -1850,15 +2603,11 @@ func (e *callExpr) eval(app *app, _ []string) {
 				app.ui.echoerr("rename: empty directory")
 				return
 			}
-			wd, err := os.Getwd()
-			if err != nil {
-				log.Printf("getting current directory: %s", err)
-				return
-			}
+			wd := app.nav.currDir().path
 
 			oldPath := filepath.Join(wd, curr.Name())
 			newPath := filepath.Clean(replaceTilde(s))
-			if !filepath.IsAbs(newPath) {
+			if !filepath.IsAbs(newPath) && !isRemote(newPath) {
 				newPath = filepath.Join(wd, newPath)
 			}
 			if oldPath == newPath {
@@ -1868,17 +2617,17 @@ func (e *callExpr) eval(app *app, _ []string) {
 			app.nav.renameNewPath = newPath
 
 			newDir := filepath.Dir(newPath)
-			if _, err := os.Stat(newDir); os.IsNotExist(err) {
+			if _, err := getFS(newDir).Stat(newDir); os.IsNotExist(err) {
 				app.ui.cmdPrefix = "create '" + newDir + "'? [y/N] "
 				return
 			}
 
-			oldStat, err := os.Lstat(oldPath)
+			oldStat, err := getFS(oldPath).Lstat(oldPath)
 			if err != nil {
 				app.ui.echoerrf("rename: %s", err)
 				return
 			}
-			if newStat, err := os.Lstat(newPath); !os.IsNotExist(err) && !os.SameFile(oldStat, newStat) {
+			if newStat, err := getFS(newPath).Lstat(newPath); !os.IsNotExist(err) && !os.SameFile(oldStat, newStat) {
 				app.ui.cmdPrefix = "replace '" + newPath + "'? [y/N] "
 				return
 			}
@@ -1897,6 +2646,47 @@ func (e *callExpr) eval(app *app, _ []string) {
 				}
 			}
 			app.ui.loadFile(app, true)
+		case "rename-pattern: ":
+			app.ui.cmdPrefix = ""
+
+			p, err := parseRenamePattern(s)
+			if err != nil {
+				app.ui.echoerrf("rename-pattern: %s", err)
+				return
+			}
+			renames, err := patternRenames(p, app.nav.renameTargets())
+			if err != nil {
+				app.ui.echoerrf("rename-pattern: %s", err)
+				return
+			}
+			if len(renames) == 0 {
+				app.ui.echo("rename-pattern: no files renamed")
+				return
+			}
+			steps, err := planRenames(renames, func(path string) bool {
+				_, err := getFS(path).Lstat(path)
+				return err == nil
+			})
+			if err != nil {
+				app.ui.echoerrf("rename-pattern: %s", err)
+				return
+			}
+
+			app.nav.renamePlan = steps
+			if err := app.commitRenames("rename-pattern"); err != nil {
+				app.ui.echoerrf("rename-pattern: %s", err)
+			}
+		case "fuzzy-find: ":
+			app.ui.cmdPrefix = ""
+
+			path, ok := app.nav.fuzzy.selected()
+			app.nav.fuzzy.stop()
+			app.nav.fuzzy = nil
+			if !ok {
+				app.ui.echoerrf("fuzzy-find: pattern not found: %s", s)
+				return
+			}
+			(&callExpr{"select", []string{path}, 1}).eval(app, nil)
 		default:
 			log.Printf("entering unknown execution prefix: %q", app.ui.cmdPrefix)
 		}
@@ -1911,6 +2701,9 @@ func (e *callExpr) eval(app *app, _ []string) {
 		}
 		normal(app)
 	case "cmd-history-next":
+		if app.moveFuzzy(1) {
+			return
+		}
 		if !slices.Contains([]string{":", "$", "!", "%", "&"}, app.ui.cmdPrefix) {
 			return
 		}
@@ -1939,6 +2732,9 @@ func (e *callExpr) eval(app *app, _ []string) {
 			}
 		}
 	case "cmd-history-prev":
+		if app.moveFuzzy(-1) {
+			return
+		}
 		if !slices.Contains([]string{":", "$", "!", "%", "&", ""}, app.ui.cmdPrefix) {
 			return
 		}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients send events to the server over a separate connection, which are
+// forwarded as JSON objects on a single line to the connections subscribed to
+// them with the `subscribe` command. The server tells each client which events
+// are subscribed with a `subscribed` command, so that clients do not send any
+// events other than `cd` when there are no subscribers.
+var eventNames = []string{"cd", "cursor", "selection", "mode", "op-start", "op-finish"}
+
+// gEvents holds the events subscribed for this client and the queue of events
+// to be sent to the server.
+var gEvents = struct {
+	sync.Mutex
+	wanted map[string]bool
+	queue  chan []byte
+	start  sync.Once
+}{queue: make(chan []byte, 256)}
+
+// setEvents sets the events subscribed for this client.
+func setEvents(names []string) {
+	gEvents.Lock()
+	defer gEvents.Unlock()
+
+	gEvents.wanted = make(map[string]bool)
+	for _, name := range names {
+		gEvents.wanted[name] = true
+	}
+}
+
+// eventWanted reports whether the given event should be sent to the server,
+// where `cd` events are always sent to keep the metadata of clients up to date.
+func eventWanted(name string) bool {
+	if gSingleMode {
+		return false
+	}
+
+	gEvents.Lock()
+	defer gEvents.Unlock()
+
+	return name == "cd" || gEvents.wanted[name]
+}
+
+// emitEvent queues an event with the given fields if it is subscribed. Events
+// are dropped instead of blocking the caller when the queue is full.
+func emitEvent(name string, fields map[string]any) {
+	if !eventWanted(name) {
+		return
+	}
+
+	ev := map[string]any{"client": gClientID, "event": name}
+	for k, v := range fields {
+		ev[k] = v
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("event: %s", err)
+		return
+	}
+
+	gEvents.start.Do(func() { go sendEvents() })
+
+	select {
+	case gEvents.queue <- fmt.Appendf(nil, "event %d %s %s\n", gClientID, name, b):
+	default:
+		log.Printf("event: queue is full, dropping %s", name)
+	}
+}
+
+// sendEvents writes queued events to the server, connecting again when the
+// connection is lost.
+func sendEvents() {
+	var c net.Conn
+	for line := range gEvents.queue {
+		if c == nil {
+			var err error
+			if c, err = net.Dial("unix", gSocketPath); err != nil {
+				log.Printf("event: connecting server: %s", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+		}
+		if _, err := c.Write(line); err != nil {
+			log.Printf("event: sending to server: %s", err)
+			c.Close()
+			c = nil
+		}
+	}
+}
+
+// opEvent emits an event for a file operation started or finished in the
+// background, where job is zero for operations not managed as jobs.
+func opEvent(name string, job int, op string, paths []string, dstDir string, status string) {
+	fields := map[string]any{"op": op}
+	if job > 0 {
+		fields["job"] = job
+	}
+	if name == "op-start" {
+		fields["paths"] = paths
+		if dstDir != "" {
+			fields["destination"] = dstDir
+		}
+	} else {
+		fields["status"] = status
+	}
+	emitEvent(name, fields)
+}
+
+// checkEvents emits events for changes of the selection and the mode since
+// the last call, which happens after commands are evaluated.
+func (app *app) checkEvents() {
+	if eventWanted("selection") {
+		paths := app.nav.currSelections()
+		if !slices.Equal(paths, app.lastSelections) {
+			app.lastSelections = paths
+			emitEvent("selection", map[string]any{"paths": append([]string{}, paths...)})
+		}
+	}
+
+	if eventWanted("mode") {
+		if mode := app.mode(); mode != app.lastMode {
+			app.lastMode = mode
+			emitEvent("mode", map[string]any{"mode": mode})
+		}
+	}
+}
+
+// allClients is the client id of subscriptions to the events of all clients.
+const allClients = -1
+
+// subscriberTimeout is the time to wait for writing an event to a subscriber,
+// which is removed when it does not read events in time.
+const subscriberTimeout = time.Second
+
+// parseSubscribe parses the arguments of the `subscribe` command, which are a
+// client id or `all` followed by event names.
+func parseSubscribe(s string) (int, []string, error) {
+	word, rest := splitWord(s)
+	if word == "" {
+		return 0, nil, errors.New("requires a client id or 'all'")
+	}
+
+	id := allClients
+	if word != "all" {
+		var err error
+		if id, err = strconv.Atoi(word); err != nil {
+			return 0, nil, errors.New("client id should be a number or 'all'")
+		}
+	}
+
+	events := strings.Fields(rest)
+	if err := validateEvents(events); err != nil {
+		return 0, nil, err
+	}
+	return id, events, nil
+}
+
+func validateEvents(events []string) error {
+	for _, name := range events {
+		if !slices.Contains(eventNames, name) {
+			return fmt.Errorf("unknown event: %s", name)
+		}
+	}
+	return nil
+}
+
+// subscriber is a connection subscribed to the events of a client or all
+// clients.
+type subscriber struct {
+	c      net.Conn
+	client int
+	events []string
+}
+
+// subList is the list of subscribers kept by the server, along with the
+// events last announced to each client.
+type subList struct {
+	subs      []*subscriber
+	announced map[int]string
+}
+
+func (l *subList) add(sub *subscriber) {
+	l.subs = append(l.subs, sub)
+}
+
+// wanted returns the events subscribed for the given client.
+func (l *subList) wanted(id int) []string {
+	var events []string
+	for _, sub := range l.subs {
+		if sub.client == id || sub.client == allClients {
+			events = append(events, sub.events...)
+		}
+	}
+	slices.Sort(events)
+	return slices.Compact(events)
+}
+
+// announce sends the subscribed events to the clients for which they have
+// changed since the last announcement.
+func (l *subList) announce(connList map[int]net.Conn) {
+	if l.announced == nil {
+		l.announced = make(map[int]string)
+	}
+
+	for id, c := range connList {
+		events := strings.Join(l.wanted(id), " ")
+		if events == l.announced[id] {
+			continue
+		}
+		if _, err := fmt.Fprintln(c, "subscribed "+events); err != nil {
+			log.Printf("failed to send subscribed events to client %v: %s", id, err)
+			continue
+		}
+		l.announced[id] = events
+	}
+}
+
+// publish writes an event of a client to its subscribers and reports whether
+// any subscribers are removed, which happens when they cannot be written.
+func (l *subList) publish(id int, name, payload string) bool {
+	removed := false
+	for _, sub := range l.subs {
+		if sub.client != id && sub.client != allClients || !slices.Contains(sub.events, name) {
+			continue
+		}
+		sub.c.SetWriteDeadline(time.Now().Add(subscriberTimeout))
+		if _, err := fmt.Fprintln(sub.c, payload); err != nil {
+			log.Printf("removing subscriber: %s", err)
+			sub.c.Close()
+			sub.c = nil
+			removed = true
+		}
+	}
+	l.subs = slices.DeleteFunc(l.subs, func(sub *subscriber) bool { return sub.c == nil })
+	return removed
+}
+
+// drop closes the connections subscribed to the given client, which are not
+// sent any more events after the client is disconnected.
+func (l *subList) drop(id int) {
+	delete(l.announced, id)
+	l.subs = slices.DeleteFunc(l.subs, func(sub *subscriber) bool {
+		if sub.client == id {
+			sub.c.Close()
+			return true
+		}
+		return false
+	})
+}
+
+func (l *subList) closeAll() {
+	for _, sub := range l.subs {
+		sub.c.Close()
+	}
+	l.subs = nil
+}
+
+// isSubscribe reports whether the given remote command is a `subscribe`
+// command, whose events are streamed until the server closes the connection.
+func isSubscribe(cmd string) bool {
+	word, _ := splitWord(cmd)
+	return word == "subscribe"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestParseSubscribe(t *testing.T) {
+	tests := []struct {
+		s      string
+		id     int
+		events []string
+		fail   bool
+	}{
+		{"all", allClients, nil, false},
+		{"all cd cursor", allClients, []string{"cd", "cursor"}, false},
+		{"42 op-start op-finish", 42, []string{"op-start", "op-finish"}, false},
+		{"", 0, nil, true},
+		{"foo cd", 0, nil, true},
+		{"42 cd bogus", 0, nil, true},
+	}
+
+	for _, test := range tests {
+		id, events, err := parseSubscribe(test.s)
+		if (err != nil) != test.fail {
+			t.Errorf("at input '%s' expected failure '%t' but got error '%v'", test.s, test.fail, err)
+			continue
+		}
+		if id != test.id || !slices.Equal(events, test.events) {
+			t.Errorf("at input '%s' expected '%d %v' but got '%d %v'", test.s, test.id, test.events, id, events)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	startManage()
+
+	// fake client reporting the subscribed events it is told
+	client, server := net.Pipe()
+	go handleConn(server, nil)
+	fmt.Fprintln(client, "conn 9")
+	announced := make(chan string)
+	go func() {
+		s := bufio.NewScanner(client)
+		for s.Scan() {
+			announced <- s.Text()
+		}
+	}()
+	waitClient(9)
+
+	sub, subServer := net.Pipe()
+	defer sub.Close()
+	go handleConn(subServer, nil)
+	fmt.Fprintln(sub, "subscribe 9 cursor cd")
+
+	if got := <-announced; got != "subscribed cd cursor" {
+		t.Errorf("expected 'subscribed cd cursor' but got '%s'", got)
+	}
+
+	ev, evServer := net.Pipe()
+	defer ev.Close()
+	go handleConn(evServer, nil)
+
+	// only subscribed events of the client are forwarded
+	fmt.Fprintln(ev, `event 9 mode {"client":9,"event":"mode","mode":"normal"}`)
+	fmt.Fprintln(ev, `event 8 cd {"client":8,"event":"cd","path":"/b"}`)
+	fmt.Fprintln(ev, `event 9 cd {"client":9,"event":"cd","path":"/a"}`)
+
+	r := bufio.NewReader(sub)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := `{"client":9,"event":"cd","path":"/a"}` + "\n"; line != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, line)
+	}
+
+	// subscribers are closed when the client is dropped
+	fmt.Fprintln(ev, "drop 9")
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("expected end of events but got '%v'", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Directories can be flattened with the `flatten` command to list all files
+// in the directory tree up to a given depth. The depth is kept for each path
+// and looked up when the directory is loaded, which happens in the background.
+// A negative depth means no limit.
+var gFlatten = struct {
+	sync.Mutex
+	depths map[string]int
+}{depths: make(map[string]int)}
+
+func getFlatten(path string) int {
+	gFlatten.Lock()
+	defer gFlatten.Unlock()
+
+	return gFlatten.depths[path]
+}
+
+func setFlatten(path string, depth int) {
+	gFlatten.Lock()
+	defer gFlatten.Unlock()
+
+	if depth == 0 {
+		delete(gFlatten.depths, path)
+	} else {
+		gFlatten.depths[path] = depth
+	}
+}
+
+// flatInfo names a file in a flattened directory with its path relative to the
+// directory, so that it is displayed, sorted and selected as such.
+type flatInfo struct {
+	os.FileInfo
+	name    string
+	matches []grepMatch // matching lines for files listed by `grep`
+	group   int         // group of duplicates for files listed by `find-duplicates`
+}
+
+func (fi *flatInfo) Name() string { return fi.name }
+
+// baseFile returns the file with its base name for files in flattened or tree
+// directories, which is used for matching colors and icons and for display in
+// the tree layout.
+func baseFile(f *file) *file {
+	if fi, ok := f.FileInfo.(*flatInfo); ok {
+		g := *f
+		g.FileInfo = fi.FileInfo
+		return &g
+	}
+	return f
+}
+
+// readdirFlat reads all files in the directory tree at path up to the given
+// depth. Symbolic links to directories are not followed.
+func readdirFlat(path string, depth int) ([]*file, error) {
+	var files []*file
+
+	err := walkFS(getFS(path), path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			log.Printf("reading directory: %s", err)
+			return nil
+		}
+		if p == path {
+			return nil
+		}
+
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return nil
+		}
+
+		f := newFile(p)
+		if os.IsNotExist(f.err) {
+			return nil
+		}
+		f.FileInfo = &flatInfo{FileInfo: f.FileInfo, name: rel}
+		files = append(files, f)
+
+		if info.IsDir() && depth > 0 && strings.Count(rel, string(filepath.Separator))+1 >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// isHiddenPath reports whether a file is hidden, also considering the parent
+// directories of files in flattened directories.
+func isHiddenPath(f *file, dirPath string, hiddenfiles []string) bool {
+	name := f.Name()
+	if !strings.ContainsRune(name, filepath.Separator) {
+		return isHidden(f, dirPath, hiddenfiles)
+	}
+
+	parent := dirPath
+	for _, elem := range strings.Split(name, string(filepath.Separator)) {
+		if isHidden(&fakeStat{name: elem}, parent, hiddenfiles) {
+			return true
+		}
+		parent = filepath.Join(parent, elem)
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReaddirFlat(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"a/b/c", "d"} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"x", "a/y", "a/b/z"} {
+		if err := os.WriteFile(filepath.Join(dir, path), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		depth int
+		exp   []string
+	}{
+		{1, []string{"a", "d", "x"}},
+		{2, []string{"a", "a/b", "a/y", "d", "x"}},
+		{-1, []string{"a", "a/b", "a/b/c", "a/b/z", "a/y", "d", "x"}},
+	}
+
+	for _, test := range tests {
+		files, err := readdirFlat(dir, test.depth)
+		if err != nil {
+			t.Errorf("at depth %d unexpected error: %s", test.depth, err)
+			continue
+		}
+
+		var names []string
+		for _, f := range files {
+			names = append(names, filepath.ToSlash(f.Name()))
+			if f.path != filepath.Join(dir, f.Name()) {
+				t.Errorf("at depth %d expected path '%s' but got '%s'", test.depth, filepath.Join(dir, f.Name()), f.path)
+			}
+		}
+		slices.Sort(names)
+
+		if !slices.Equal(names, test.exp) {
+			t.Errorf("at depth %d expected '%v' but got '%v'", test.depth, test.exp, names)
+		}
+	}
+}
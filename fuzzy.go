@@ -0,0 +1,350 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// fuzzyFinder holds the state of the `fuzzy-find` command. The directory tree
+// is walked in the background and the found paths are sent to the main loop
+// in batches, where they are scored against the query as they arrive.
+type fuzzyFinder struct {
+	root    string
+	paths   []string // paths relative to root, with a trailing separator for directories
+	done    bool
+	cancel  chan struct{}
+	query   string
+	matches []fuzzyMatch
+	ind     int
+}
+
+type fuzzyMatch struct {
+	path  string
+	score int
+}
+
+type fuzzyBatch struct {
+	finder *fuzzyFinder
+	paths  []string
+	done   bool
+}
+
+func newFuzzyFinder(root string, hidden bool, hiddenfiles []string, ch chan<- fuzzyBatch) *fuzzyFinder {
+	ff := &fuzzyFinder{
+		root:   root,
+		cancel: make(chan struct{}),
+	}
+	go ff.walk(hidden, hiddenfiles, ch)
+	return ff
+}
+
+func (ff *fuzzyFinder) stop() {
+	close(ff.cancel)
+}
+
+// walk collects the paths in the directory tree, skipping hidden files when
+// hidden files are not shown, and files ignored by `.gitignore` files along
+// with `.git` directories.
+func (ff *fuzzyFinder) walk(hidden bool, hiddenfiles []string, ch chan<- fuzzyBatch) {
+	rules := make(ignoreRules)
+	rules.loadParents(ff.root)
+
+	var batch []string
+	last := time.Now()
+
+	send := func(done bool) bool {
+		select {
+		case ch <- fuzzyBatch{ff, batch, done}:
+			batch = nil
+			last = time.Now()
+			return true
+		case <-ff.cancel:
+			return false
+		}
+	}
+
+	err := walkFS(getFS(ff.root), ff.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == ff.root {
+				return err
+			}
+			log.Printf("fuzzy-find: %s", err)
+			return nil
+		}
+
+		if path == ff.root {
+			rules.load(path)
+			return nil
+		}
+
+		skip := func() error {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := info.Name()
+		if info.IsDir() && name == ".git" {
+			return filepath.SkipDir
+		}
+		if !hidden && isHidden(&fakeStat{name: name}, filepath.Dir(path), hiddenfiles) {
+			return skip()
+		}
+		if rules.ignored(path, info.IsDir()) {
+			return skip()
+		}
+
+		rel, err := filepath.Rel(ff.root, path)
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			rules.load(path)
+			rel += string(filepath.Separator)
+		}
+		batch = append(batch, rel)
+
+		if len(batch) >= 1000 || time.Since(last) > 100*time.Millisecond {
+			if !send(false) {
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("fuzzy-find: %s", err)
+	}
+
+	send(true)
+}
+
+// add appends a batch of paths and scores them against the current query. The
+// selected match is kept when the order of matches changes.
+func (ff *fuzzyFinder) add(paths []string, done bool) {
+	ff.paths = append(ff.paths, paths...)
+	ff.done = done
+
+	if ff.query == "" || len(paths) == 0 {
+		return
+	}
+
+	var selected string
+	if ff.ind < len(ff.matches) {
+		selected = ff.matches[ff.ind].path
+	}
+
+	ff.matches = append(ff.matches, scorePaths(ff.query, paths)...)
+	sortMatches(ff.matches)
+
+	ff.ind = max(slices.IndexFunc(ff.matches, func(m fuzzyMatch) bool { return m.path == selected }), 0)
+}
+
+// setQuery rescores all paths found so far against the given query.
+func (ff *fuzzyFinder) setQuery(query string) {
+	ff.query = query
+	ff.matches = nil
+	ff.ind = 0
+
+	if query != "" {
+		ff.matches = scorePaths(query, ff.paths)
+		sortMatches(ff.matches)
+	}
+}
+
+// move changes the selected match in the given direction with wraparound.
+func (ff *fuzzyFinder) move(direction int) {
+	if len(ff.matches) == 0 {
+		return
+	}
+	ff.ind = (ff.ind + direction + len(ff.matches)) % len(ff.matches)
+}
+
+// selected returns the full path of the selected match.
+func (ff *fuzzyFinder) selected() (string, bool) {
+	if ff.ind >= len(ff.matches) {
+		return "", false
+	}
+	return filepath.Join(ff.root, ff.matches[ff.ind].path), true
+}
+
+// list renders the ranked matches in the menu, scrolling to keep the selected
+// match visible within the given number of lines.
+func (ff *fuzzyFinder) list(maxLines int) (string, *menuSelect) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "fuzzy-find: %d/%d", len(ff.matches), len(ff.paths))
+	if !ff.done {
+		b.WriteString(" (searching)")
+	}
+	b.WriteByte('\n')
+
+	if len(ff.matches) == 0 {
+		return b.String(), nil
+	}
+
+	height := max(maxLines-1, 1)
+	beg := max(ff.ind-height+1, 0)
+	end := min(beg+height, len(ff.matches))
+
+	var selection *menuSelect
+	for i, m := range ff.matches[beg:end] {
+		s := sanitizeName(m.path)
+		if beg+i == ff.ind {
+			selection = &menuSelect{0, i + 1, s}
+		}
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+
+	return b.String(), selection
+}
+
+// listFuzzy shows the matches of the active fuzzy finder in the menu.
+func (app *app) listFuzzy() {
+	app.ui.menu, app.ui.menuSelect = app.nav.fuzzy.list(app.ui.msgWin.y)
+}
+
+// moveFuzzy changes the selected match when the fuzzy finder prompt is active,
+// which is used for completion and history keys in the prompt.
+func (app *app) moveFuzzy(direction int) bool {
+	if app.ui.cmdPrefix != "fuzzy-find: " {
+		return false
+	}
+	app.nav.fuzzy.move(direction)
+	app.listFuzzy()
+	return true
+}
+
+func scorePaths(query string, paths []string) []fuzzyMatch {
+	terms := strings.Fields(query)
+
+	var matches []fuzzyMatch
+	for _, path := range paths {
+		total := 0
+		ok := true
+		for _, term := range terms {
+			score, found := fuzzyScore(term, path)
+			if !found {
+				ok = false
+				break
+			}
+			total += score
+		}
+		if ok {
+			matches = append(matches, fuzzyMatch{path, total})
+		}
+	}
+	return matches
+}
+
+// sortMatches sorts matches by descending score, preferring shorter paths for
+// equal scores.
+func sortMatches(matches []fuzzyMatch) {
+	slices.SortStableFunc(matches, func(m1, m2 fuzzyMatch) int {
+		if c := cmp.Compare(m2.score, m1.score); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(len(m1.path), len(m2.path)); c != 0 {
+			return c
+		}
+		return cmp.Compare(m1.path, m2.path)
+	})
+}
+
+// fuzzyScore reports whether the characters of the pattern appear in order in
+// the given path, and scores the match. Matches in the file name are preferred
+// over matches in the parent directories. Case and diacritics are handled
+// according to the `ignorecase`, `smartcase`, `ignoredia` and `smartdia`
+// options as in searching.
+func fuzzyScore(pattern, path string) (int, bool) {
+	if gOpts.ignorecase {
+		lpattern := strings.ToLower(pattern)
+		if !gOpts.smartcase || lpattern == pattern {
+			pattern = lpattern
+			path = strings.ToLower(path)
+		}
+	}
+	if gOpts.ignoredia {
+		lpattern := removeDiacritics(pattern)
+		if !gOpts.smartdia || lpattern == pattern {
+			pattern = lpattern
+			path = removeDiacritics(path)
+		}
+	}
+
+	p := []rune(pattern)
+	r := []rune(path)
+	if len(p) == 0 {
+		return 0, true
+	}
+
+	name := len(r) - 1
+	for name > 0 && r[name-1] != filepath.Separator {
+		name--
+	}
+	if score, ok := scoreRunes(p, r, max(name, 0)); ok {
+		return score + 20, true
+	}
+	return scoreRunes(p, r, 0)
+}
+
+// scoreRunes scores the shortest occurrence of the pattern ending at the first
+// possible position after the given offset, where matches at word boundaries
+// and consecutive matches are preferred and gaps are penalized.
+func scoreRunes(p, r []rune, off int) (int, bool) {
+	end := -1
+	for i, j := off, 0; i < len(r); i++ {
+		if r[i] == p[j] {
+			j++
+			if j == len(p) {
+				end = i
+				break
+			}
+		}
+	}
+	if end == -1 {
+		return 0, false
+	}
+
+	beg := end
+	for i, j := end, len(p)-1; i >= off; i-- {
+		if r[i] == p[j] {
+			j--
+			if j < 0 {
+				beg = i
+				break
+			}
+		}
+	}
+
+	score := 0
+	prev := -2
+	for i, j := beg, 0; i <= end && j < len(p); i++ {
+		if r[i] != p[j] {
+			score--
+			continue
+		}
+		score += 16
+		switch {
+		case i == 0 || r[i-1] == filepath.Separator:
+			score += 10
+		case strings.ContainsRune("_-. ", r[i-1]) || (unicode.IsLower(r[i-1]) && unicode.IsUpper(r[i])):
+			score += 8
+		}
+		if i == prev+1 {
+			score += 6
+		}
+		prev = i
+		j++
+	}
+
+	return score, true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	gOpts.ignorecase = true
+	gOpts.smartcase = true
+
+	tests := []struct {
+		pattern string
+		s       string
+		ok      bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "a/b/c", true},
+		{"abc", "acb", false},
+		{"ABC", "abc", false},
+		{"abc", "ABC", true},
+		{"", "abc", true},
+	}
+
+	for _, test := range tests {
+		if _, ok := fuzzyScore(test.pattern, filepath.FromSlash(test.s)); ok != test.ok {
+			t.Errorf("at input '%s' and '%s' expected '%t' but got '%t'", test.pattern, test.s, test.ok, ok)
+		}
+	}
+
+	ranks := []struct {
+		query string
+		paths []string
+		exp   []string
+	}{
+		{"main", []string{"domain/x.go", "src/main.go"}, []string{"src/main.go", "domain/x.go"}},
+		{"main", []string{"main/util.go", "cmd/main.go"}, []string{"cmd/main.go", "main/util.go"}},
+		{"mg", []string{"mango", "main.go"}, []string{"main.go", "mango"}},
+		{"ab", []string{"xaxb", "ab", "xab"}, []string{"ab", "xab", "xaxb"}},
+		{"a b", []string{"b/a", "a", "ab"}, []string{"ab", "b/a"}},
+	}
+
+	for _, test := range ranks {
+		var paths []string
+		for _, p := range test.paths {
+			paths = append(paths, filepath.FromSlash(p))
+		}
+
+		matches := scorePaths(test.query, paths)
+		sortMatches(matches)
+
+		var got []string
+		for _, m := range matches {
+			got = append(got, filepath.ToSlash(m.path))
+		}
+		if !slices.Equal(got, test.exp) {
+			t.Errorf("at query '%s' expected '%v' but got '%v'", test.query, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Git states of files shown in the `git` info column, in increasing order of
+// precedence when aggregated for directories.
+const (
+	gitIgnored    = '!'
+	gitUntracked  = '?'
+	gitStaged     = 'S'
+	gitModified   = 'M'
+	gitConflicted = 'U'
+)
+
+// gitStyleKeys are the keys of the colors used for git states in `styleMap`.
+var gitStyleKeys = map[byte]string{
+	gitIgnored:    "gi",
+	gitUntracked:  "gu",
+	gitStaged:     "gs",
+	gitModified:   "gm",
+	gitConflicted: "gc",
+}
+
+func gitPriority(state byte) int {
+	return strings.IndexByte("!?SMU", state)
+}
+
+// gitStatus is the status of a git repository, which is loaded in the
+// background for each repository root.
+type gitStatus struct {
+	root   string
+	branch string
+	ahead  int
+	behind int
+	files  map[string]byte // states of files relative to the root, untracked and ignored directories end with a slash
+	dirs   map[string]byte // states of directories aggregated from the files below them
+}
+
+// gitRepo is the cached status of a repository. A stale status is reloaded the
+// next time it is used.
+type gitRepo struct {
+	status  *gitStatus
+	loading bool
+	stale   bool
+}
+
+// gitData is the information of the repository of the current directory
+// available in the ruler file as `{{.Git}}`.
+type gitData struct {
+	Branch string
+	Ahead  int
+	Behind int
+}
+
+func (d *gitData) String() string {
+	if d == nil {
+		return ""
+	}
+
+	s := d.Branch
+	if d.Ahead > 0 {
+		s += fmt.Sprintf(" ↑%d", d.Ahead)
+	}
+	if d.Behind > 0 {
+		s += fmt.Sprintf(" ↓%d", d.Behind)
+	}
+	return s
+}
+
+func loadGitStatus(root string) *gitStatus {
+	cmd := exec.Command("git", "--no-optional-locks", "-C", root, "status",
+		"--porcelain=v1", "-z", "--branch", "--ignored=matching", "--untracked-files=normal")
+
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("git status: %s", err)
+		return &gitStatus{root: root}
+	}
+
+	return parseGitStatus(root, string(out))
+}
+
+// parseGitStatus parses the output of `git status --porcelain=v1 -z --branch`.
+func parseGitStatus(root, out string) *gitStatus {
+	s := &gitStatus{
+		root:  root,
+		files: make(map[string]byte),
+		dirs:  make(map[string]byte),
+	}
+
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+
+		if branch, ok := strings.CutPrefix(e, "## "); ok {
+			s.parseBranch(branch)
+			continue
+		}
+
+		if len(e) < 4 {
+			continue
+		}
+
+		xy, name := e[:2], e[3:]
+
+		// renamed and copied entries are followed by the original path
+		if xy[0] == 'R' || xy[0] == 'C' {
+			i++
+		}
+
+		state := gitState(xy)
+		s.files[name] = state
+
+		if state == gitIgnored {
+			continue
+		}
+		for d := path.Dir(strings.TrimSuffix(name, "/")); d != "."; d = path.Dir(d) {
+			if gitPriority(state) > gitPriority(s.dirs[d]) {
+				s.dirs[d] = state
+			}
+		}
+	}
+
+	return s
+}
+
+func (s *gitStatus) parseBranch(line string) {
+	switch {
+	case strings.HasPrefix(line, "No commits yet on "):
+		s.branch = strings.TrimPrefix(line, "No commits yet on ")
+		return
+	case strings.HasPrefix(line, "HEAD (no branch)"):
+		s.branch = "HEAD"
+		return
+	}
+
+	line, track, _ := strings.Cut(line, " [")
+	s.branch, _, _ = strings.Cut(line, "...")
+
+	for _, t := range strings.Split(strings.TrimSuffix(track, "]"), ", ") {
+		if n, ok := strings.CutPrefix(t, "ahead "); ok {
+			s.ahead, _ = strconv.Atoi(n)
+		} else if n, ok := strings.CutPrefix(t, "behind "); ok {
+			s.behind, _ = strconv.Atoi(n)
+		}
+	}
+}
+
+func gitState(xy string) byte {
+	x, y := xy[0], xy[1]
+	switch {
+	case xy == "??":
+		return gitUntracked
+	case xy == "!!":
+		return gitIgnored
+	case x == 'U' || y == 'U' || xy == "AA" || xy == "DD":
+		return gitConflicted
+	case y != ' ':
+		return gitModified
+	default:
+		return gitStaged
+	}
+}
+
+// state returns the git state of the given file, or zero if it is unchanged
+// or outside of the repository.
+func (s *gitStatus) state(p string, isDir bool) byte {
+	rel, err := filepath.Rel(s.root, p)
+	if err != nil || !filepath.IsLocal(rel) {
+		return 0
+	}
+	rel = filepath.ToSlash(rel)
+
+	if isDir {
+		if state, ok := s.files[rel+"/"]; ok {
+			return state
+		}
+		if state, ok := s.dirs[rel]; ok {
+			return state
+		}
+	} else if state, ok := s.files[rel]; ok {
+		return state
+	}
+
+	// files in untracked and ignored directories are not listed separately
+	for d := path.Dir(rel); d != "."; d = path.Dir(d) {
+		if state, ok := s.files[d+"/"]; ok {
+			return state
+		}
+	}
+
+	return 0
+}
+
+// gitRoot returns the root of the git repository containing the given
+// directory, or an empty string if there is none.
+func (nav *nav) gitRoot(dir string) string {
+	if root, ok := nav.gitRoots[dir]; ok {
+		return root
+	}
+
+	// git is not run for directories on remote hosts
+	var root string
+	for d := dir; !isRemote(d); d = filepath.Dir(d) {
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+			root = d
+			break
+		}
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+
+	nav.gitRoots[dir] = root
+	return root
+}
+
+// gitStatus returns the status of the repository containing the given
+// directory, and starts loading it in the background if it is not loaded yet
+// or stale. The previous status is returned while loading.
+func (nav *nav) gitStatus(dir string) *gitStatus {
+	root := nav.gitRoot(dir)
+	if root == "" {
+		return nil
+	}
+
+	repo, ok := nav.gitRepos[root]
+	if !ok {
+		repo = &gitRepo{stale: true}
+		nav.gitRepos[root] = repo
+	}
+
+	if repo.stale && !repo.loading {
+		repo.stale = false
+		repo.loading = true
+		go func() {
+			nav.gitChan <- loadGitStatus(root)
+		}()
+	}
+
+	return repo.status
+}
+
+// invalidateGit marks the status of the repository containing the given
+// directory as stale, which is used for changes reported by the `watch` option
+// and reloaded directories.
+func (nav *nav) invalidateGit(dir string) {
+	if len(nav.gitRepos) == 0 {
+		return
+	}
+	if repo, ok := nav.gitRepos[nav.gitRoot(dir)]; ok {
+		repo.stale = true
+	}
+}
+
+// gitData returns the repository information of the current directory for the
+// ruler.
+func (nav *nav) gitData() *gitData {
+	s := nav.gitStatus(nav.currDir().path)
+	if s == nil || s.files == nil {
+		return nil
+	}
+	return &gitData{s.branch, s.ahead, s.behind}
+}
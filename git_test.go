@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGitStatus(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+
+	out := "## main...origin/main [ahead 2, behind 1]\x00" +
+		" M a/b/modified.go\x00" +
+		"M  a/staged.go\x00" +
+		"MM both.go\x00" +
+		"R  new.go\x00old.go\x00" +
+		"UU conflict.go\x00" +
+		"?? untracked/\x00" +
+		"!! build/\x00"
+
+	s := parseGitStatus(root, out)
+
+	if s.branch != "main" || s.ahead != 2 || s.behind != 1 {
+		t.Errorf("branch: got (%q, %d, %d), want (%q, %d, %d)", s.branch, s.ahead, s.behind, "main", 2, 1)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		exp   byte
+	}{
+		{"a/b/modified.go", false, gitModified},
+		{"a/staged.go", false, gitStaged},
+		{"both.go", false, gitModified},
+		{"new.go", false, gitStaged},
+		{"old.go", false, 0},
+		{"conflict.go", false, gitConflicted},
+		{"unchanged.go", false, 0},
+		{"a", true, gitModified},
+		{"a/b", true, gitModified},
+		{"untracked", true, gitUntracked},
+		{"untracked/x/y.go", false, gitUntracked},
+		{"build", true, gitIgnored},
+		{"build/out.o", false, gitIgnored},
+		{"../outside.go", false, 0},
+	}
+
+	for _, test := range tests {
+		if got := s.state(filepath.Join(root, filepath.FromSlash(test.path)), test.isDir); got != test.exp {
+			t.Errorf("at input '%s' expected '%c' but got '%c'", test.path, test.exp, got)
+		}
+	}
+}
+
+func TestParseGitBranch(t *testing.T) {
+	tests := []struct {
+		line   string
+		branch string
+		ahead  int
+		behind int
+	}{
+		{"main", "main", 0, 0},
+		{"main...origin/main", "main", 0, 0},
+		{"dev...origin/dev [behind 3]", "dev", 0, 3},
+		{"dev...origin/dev [ahead 4]", "dev", 4, 0},
+		{"No commits yet on main", "main", 0, 0},
+		{"HEAD (no branch)", "HEAD", 0, 0},
+	}
+
+	for _, test := range tests {
+		var s gitStatus
+		s.parseBranch(test.line)
+		if s.branch != test.branch || s.ahead != test.ahead || s.behind != test.behind {
+			t.Errorf("at input '%s' expected (%q, %d, %d) but got (%q, %d, %d)",
+				test.line, test.branch, test.ahead, test.behind, s.branch, s.ahead, s.behind)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v3"
+)
+
+// The `grep` command replaces the listing of a directory with the files below
+// it whose contents match a pattern, similar to flattened directories. The
+// pattern is kept for each path and looked up when the directory is loaded, so
+// that the files are searched in the background.
+var gGrep = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+func getGrep(path string) *regexp.Regexp {
+	gGrep.Lock()
+	defer gGrep.Unlock()
+
+	return gGrep.patterns[path]
+}
+
+func setGrep(path string, re *regexp.Regexp) {
+	gGrep.Lock()
+	defer gGrep.Unlock()
+
+	if re == nil {
+		delete(gGrep.patterns, path)
+	} else {
+		gGrep.patterns[path] = re
+	}
+}
+
+// grepMaxMatches is the maximum number of matching lines kept for each file to
+// show in the preview.
+const grepMaxMatches = 1000
+
+// grepMatch is a matching line of a file with the byte ranges of the matches.
+type grepMatch struct {
+	line int
+	text string
+	locs [][]int
+}
+
+// compileGrep compiles the pattern of the `grep` command, which ignores case
+// according to the `ignorecase` and `smartcase` options as in searching.
+func compileGrep(pattern string) (*regexp.Regexp, error) {
+	if gOpts.ignorecase && (!gOpts.smartcase || strings.ToLower(pattern) == pattern) {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// readdirGrep searches the regular files in the directory tree at path
+// concurrently and returns the files with matching lines. Files ignored by
+// `.gitignore` files, `.git` directories and binary files are skipped, and
+// symbolic links are not followed.
+func readdirGrep(path string, re *regexp.Regexp) ([]*file, error) {
+	paths := make(chan string)
+
+	var files []*file
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for range runtime.NumCPU() {
+		wg.Go(func() {
+			for p := range paths {
+				matches, err := grepFile(p, re)
+				if err != nil {
+					log.Printf("grep: %s", err)
+					continue
+				}
+				if len(matches) == 0 {
+					continue
+				}
+
+				rel, err := filepath.Rel(path, p)
+				if err != nil {
+					continue
+				}
+
+				f := newFile(p)
+				if os.IsNotExist(f.err) {
+					continue
+				}
+				f.FileInfo = &flatInfo{FileInfo: f.FileInfo, name: rel, matches: matches}
+
+				mutex.Lock()
+				files = append(files, f)
+				mutex.Unlock()
+			}
+		})
+	}
+
+	rules := make(ignoreRules)
+	rules.loadParents(path)
+
+	err := walkFS(getFS(path), path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			log.Printf("grep: %s", err)
+			return nil
+		}
+
+		if info.IsDir() {
+			if p != path && (info.Name() == ".git" || rules.ignored(p, true)) {
+				return filepath.SkipDir
+			}
+			rules.load(p)
+			return nil
+		}
+
+		if info.Mode().IsRegular() && !rules.ignored(p, false) {
+			paths <- p
+		}
+		return nil
+	})
+
+	close(paths)
+	wg.Wait()
+
+	return files, err
+}
+
+// grepFile returns the matching lines of the file at path, or nothing if the
+// file seems to be binary.
+func grepFile(path string, re *regexp.Regexp) ([]grepMatch, error) {
+	f, err := getFS(path).Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	// similar to git, files with a null byte at the beginning are treated as binary
+	if head, _ := reader.Peek(8000); bytes.IndexByte(head, 0) != -1 {
+		return nil, nil
+	}
+
+	var matches []grepMatch
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			line = strings.TrimRight(line, "\r\n")
+			if locs := re.FindAllStringIndex(line, -1); locs != nil {
+				matches = append(matches, grepMatch{n, line, locs})
+				if len(matches) == grepMaxMatches {
+					break
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return matches, err
+		}
+	}
+
+	return matches, nil
+}
+
+// grepMatches returns the matching lines of a file listed by the `grep`
+// command.
+func grepMatches(f *file) []grepMatch {
+	if fi, ok := f.FileInfo.(*flatInfo); ok {
+		return fi.matches
+	}
+	return nil
+}
+
+// printGrep shows the matching lines of a file in the preview with their line
+// numbers, where the matches are highlighted.
+func (win *win) printGrep(screen tcell.Screen, matches []grepMatch) {
+	width := len(fmt.Sprint(matches[len(matches)-1].line))
+
+	st := tcell.StyleDefault
+	for i, m := range matches {
+		if i > win.h-1 {
+			break
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "\033[33m%*d\033[0m ", width, m.line)
+		prev := 0
+		for _, loc := range m.locs {
+			b.WriteString(sanitizePreview(m.text[prev:loc[0]]))
+			b.WriteString("\033[7m" + sanitizePreview(m.text[loc[0]:loc[1]]) + "\033[0m")
+			prev = loc[1]
+		}
+		b.WriteString(sanitizePreview(m.text[prev:]))
+
+		st = win.print(screen, 0, i, st, b.String())
+	}
+}
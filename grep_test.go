@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestReaddirGrep(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "ignored"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		".gitignore":    "ignored/\n*.log\n",
+		"x.conf":        "port = 80\nhost = x\nport = 81\n",
+		"a/y.conf":      "host = y\n",
+		"a/b/z.conf":    "# port\n",
+		"a/debug.log":   "port\n",
+		"ignored/w":     "port\n",
+		"a/b/bin.dat":   "port\x00\n",
+		"a/b/crlf.conf": "port\r\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readdirGrep(dir, regexp.MustCompile("port"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var names []string
+	for _, f := range got {
+		names = append(names, filepath.ToSlash(f.Name()))
+	}
+	slices.Sort(names)
+
+	exp := []string{"a/b/crlf.conf", "a/b/z.conf", "x.conf"}
+	if !slices.Equal(names, exp) {
+		t.Errorf("expected '%v' but got '%v'", exp, names)
+	}
+
+	for _, f := range got {
+		if f.Name() != "x.conf" {
+			continue
+		}
+		matches := grepMatches(f)
+		if len(matches) != 2 || matches[0].line != 1 || matches[1].line != 3 || matches[1].text != "port = 81" {
+			t.Errorf("unexpected matches for x.conf: %v", matches)
+		}
+	}
+}
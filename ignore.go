@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a single pattern from a `.gitignore` file, which applies to
+// paths relative to the directory containing the file.
+type ignoreRule struct {
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	nested  bool // whether the pattern matches the full relative path instead of the name
+}
+
+// parseIgnoreRule parses a line of a `.gitignore` file. Blank lines and
+// comments return nil.
+func parseIgnoreRule(base, line string) *ignoreRule {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	r := &ignoreRule{base: base}
+
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		r.nested = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile("^" + ignorePatternToRegexp(line) + "$")
+	if err != nil {
+		return nil
+	}
+	r.re = re
+
+	return r
+}
+
+// ignorePatternToRegexp converts a `.gitignore` glob pattern to a regular
+// expression, where `**` matches any number of directories.
+func ignorePatternToRegexp(pattern string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(pattern[i+1:], ']')
+			if j == -1 {
+				b.WriteString(`\[`)
+				break
+			}
+			class := pattern[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += j + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
+
+func (r *ignoreRule) match(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	if !r.nested {
+		rel = rel[strings.LastIndexByte(rel, '/')+1:]
+	}
+	return r.re.MatchString(rel)
+}
+
+// ignoreRules holds the rules of `.gitignore` files for each directory.
+type ignoreRules map[string][]*ignoreRule
+
+// load reads the `.gitignore` file in the given directory if there is one.
+func (rules ignoreRules) load(dir string) {
+	path := filepath.Join(dir, ".gitignore")
+	f, err := getFS(path).Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if r := parseIgnoreRule(dir, s.Text()); r != nil {
+			rules[dir] = append(rules[dir], r)
+		}
+	}
+}
+
+// loadParents reads the `.gitignore` files in the parent directories of the
+// given directory up to the root of the git repository containing it. Nothing
+// is read if the directory is not inside a git repository.
+func (rules ignoreRules) loadParents(dir string) {
+	var parents []string
+	for d := dir; d != filepath.Dir(d) && !isRemoteRoot(d); {
+		git := filepath.Join(d, ".git")
+		if _, err := getFS(git).Stat(git); err == nil {
+			for _, p := range parents {
+				rules.load(p)
+			}
+			return
+		}
+		d = filepath.Dir(d)
+		parents = append(parents, d)
+	}
+}
+
+// ignored reports whether the given path is ignored by the rules of its
+// parent directories, where later rules and rules in deeper directories take
+// precedence.
+func (rules ignoreRules) ignored(path string, isDir bool) bool {
+	var dirs []string
+	for d := filepath.Dir(path); ; d = filepath.Dir(d) {
+		if _, ok := rules[d]; ok {
+			dirs = append(dirs, d)
+		}
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		for _, r := range rules[dirs[i]] {
+			if r.match(path, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	sub := filepath.Join(root, "sub")
+
+	rules := make(ignoreRules)
+	for _, line := range []string{"# comment", "", "*.o", "!keep.o", "build/", "/top", "docs/**/*.md", `\#hash`} {
+		if r := parseIgnoreRule(root, line); r != nil {
+			rules[root] = append(rules[root], r)
+		}
+	}
+	rules[sub] = []*ignoreRule{parseIgnoreRule(sub, "!*.o"), parseIgnoreRule(sub, "local")}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		exp   bool
+	}{
+		{"/repo/a.o", false, true},
+		{"/repo/x/a.o", false, true},
+		{"/repo/keep.o", false, false},
+		{"/repo/build", true, true},
+		{"/repo/build", false, false},
+		{"/repo/x/build", true, true},
+		{"/repo/top", false, true},
+		{"/repo/x/top", false, false},
+		{"/repo/docs/a.md", false, true},
+		{"/repo/docs/x/y/a.md", false, true},
+		{"/repo/x/docs/a.md", false, false},
+		{"/repo/#hash", false, true},
+		{"/repo/sub/a.o", false, false},
+		{"/repo/sub/local", false, true},
+		{"/repo/local", false, false},
+		{"/repo/a.c", false, false},
+	}
+
+	for _, test := range tests {
+		if got := rules.ignored(filepath.FromSlash(test.path), test.isDir); got != test.exp {
+			t.Errorf("at input '%s' (dir: %t) expected '%t' but got '%t'", test.path, test.isDir, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"text/tabwriter"
+)
+
+var errJobCanceled = errors.New("job canceled")
+
+type jobStatus string
+
+const (
+	jobRunning  jobStatus = "running"
+	jobPaused   jobStatus = "paused"
+	jobCanceled jobStatus = "canceled"
+)
+
+// job is a file transfer running in the background. Each job has a queue of
+// files to be processed in order, and can be paused, resumed and canceled.
+// Functions doing the actual work should call `wait` regularly, which blocks
+// while the job is paused and returns `errJobCanceled` if the job is canceled.
+type job struct {
+	id       int
+	kind     string
+	dstDir   string
+	queue    []string
+	ind      int
+	mutex    sync.Mutex
+	status   jobStatus
+	resume   chan struct{}
+	cancel   chan struct{}
+	conflict conflictPolicy
+	ask      func(path string) (action conflictPolicy, all bool)
+	verify   string
+	verified int
+	failed   int
+}
+
+func newJob(id int, kind string, srcs []string, dstDir string) *job {
+	return &job{
+		id:       id,
+		kind:     kind,
+		dstDir:   dstDir,
+		queue:    srcs,
+		status:   jobRunning,
+		cancel:   make(chan struct{}),
+		conflict: conflictRename,
+	}
+}
+
+// wait blocks while the job is paused. It is safe to call on a nil job, which
+// is used for transfers that are not managed as jobs.
+func (j *job) wait() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mutex.Lock()
+	resume := j.resume
+	j.mutex.Unlock()
+
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-j.cancel:
+		}
+	}
+
+	select {
+	case <-j.cancel:
+		return errJobCanceled
+	default:
+		return nil
+	}
+}
+
+// next marks the given file in the queue as the one being processed.
+func (j *job) next(src string) {
+	if j == nil {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if i := slices.Index(j.queue, src); i >= 0 {
+		j.ind = i
+	}
+}
+
+func (j *job) policy() conflictPolicy {
+	if j == nil {
+		return conflictRename
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.conflict
+}
+
+// resolve returns the action for a conflict between src and the existing file
+// at path, which is either overwrite, skip or rename.
+func (j *job) resolve(src, dst os.FileInfo, path string) conflictPolicy {
+	switch policy := j.policy(); policy {
+	case conflictNewer:
+		if src.IsDir() && dst.IsDir() || src.ModTime().After(dst.ModTime()) {
+			return conflictOverwrite
+		}
+		return conflictSkip
+	case conflictAsk:
+		if j.ask == nil {
+			return conflictRename
+		}
+		action, all := j.ask(path)
+		if all {
+			j.mutex.Lock()
+			j.conflict = action
+			j.mutex.Unlock()
+		}
+		return action
+	default:
+		return policy
+	}
+}
+
+// verifyMethod returns the hash used to verify copied files, or an empty
+// string if copied files should not be verified.
+func (j *job) verifyMethod() string {
+	if j == nil || j.verify == "none" {
+		return ""
+	}
+	return j.verify
+}
+
+func (j *job) addVerified(ok bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if ok {
+		j.verified++
+	} else {
+		j.failed++
+	}
+}
+
+// verifySummary returns a summary of verified files, or an empty string if no
+// copied files are verified.
+func (j *job) verifySummary() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	switch {
+	case j.verified+j.failed == 0:
+		return ""
+	case j.failed == 0:
+		return fmt.Sprintf("%d files verified with %s", j.verified, j.verify)
+	default:
+		return fmt.Sprintf("%d files verified with %s, %d mismatched", j.verified, j.verify, j.failed)
+	}
+}
+
+func (j *job) pause() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	switch j.status {
+	case jobPaused:
+		return fmt.Errorf("job %d is already paused", j.id)
+	case jobCanceled:
+		return fmt.Errorf("job %d is canceled", j.id)
+	}
+
+	j.status = jobPaused
+	j.resume = make(chan struct{})
+	return nil
+}
+
+func (j *job) unpause() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	switch j.status {
+	case jobRunning:
+		return fmt.Errorf("job %d is not paused", j.id)
+	case jobCanceled:
+		return fmt.Errorf("job %d is canceled", j.id)
+	}
+
+	j.status = jobRunning
+	close(j.resume)
+	j.resume = nil
+	return nil
+}
+
+func (j *job) stop() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.status == jobCanceled {
+		return fmt.Errorf("job %d is already canceled", j.id)
+	}
+
+	j.status = jobCanceled
+	close(j.cancel)
+	return nil
+}
+
+func (j *job) canceled() bool {
+	if j == nil {
+		return false
+	}
+
+	select {
+	case <-j.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// conflictPrompt is a request to ask the user how to resolve a conflict with
+// an existing file at path during a paste operation.
+type conflictPrompt struct {
+	path   string
+	answer chan conflictAnswer
+}
+
+type conflictAnswer struct {
+	action conflictPolicy
+	all    bool
+}
+
+// startJob registers a new job, which asks the user through the conflict
+// channel when its conflict policy is set to ask.
+func (nav *nav) startJob(kind string, srcs []string, dstDir string, conflict conflictPolicy) *job {
+	j := nav.jobs.add(kind, srcs, dstDir)
+	j.conflict = conflict
+	j.verify = gOpts.copyverify
+	j.ask = func(path string) (conflictPolicy, bool) {
+		c := &conflictPrompt{path, make(chan conflictAnswer, 1)}
+		nav.conflictChan <- c
+		select {
+		case a := <-c.answer:
+			return a.action, a.all
+		case <-j.cancel:
+			return conflictSkip, false
+		}
+	}
+	return j
+}
+
+// jobReader wraps a reader to block reads while the job is paused and stop
+// reading once the job is canceled.
+type jobReader struct {
+	reader io.Reader
+	job    *job
+}
+
+func (r *jobReader) Read(b []byte) (int, error) {
+	if err := r.job.wait(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(b)
+}
+
+type jobList struct {
+	mutex  sync.Mutex
+	nextID int
+	jobs   []*job
+}
+
+func (l *jobList) add(kind string, srcs []string, dstDir string) *job {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.nextID++
+	j := newJob(l.nextID, kind, srcs, dstDir)
+	l.jobs = append(l.jobs, j)
+	opEvent("op-start", j.id, kind, srcs, dstDir, "")
+	return j
+}
+
+func (l *jobList) remove(j *job) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.jobs = slices.DeleteFunc(l.jobs, func(x *job) bool { return x == j })
+
+	status := "done"
+	if j.canceled() {
+		status = "canceled"
+	}
+	opEvent("op-finish", j.id, j.kind, nil, "", status)
+}
+
+func (l *jobList) get(id int) (*job, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, j := range l.jobs {
+		if j.id == id {
+			return j, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such job: %d", id)
+}
+
+func (l *jobList) len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.jobs)
+}
+
+func (l *jobList) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	t.Init(b, 0, gOpts.tabstop, 2, '\t', 0)
+	fmt.Fprintln(t, "id\tkind\tstatus\tfiles\tcurrent\tdestination")
+	for _, j := range l.jobs {
+		j.mutex.Lock()
+		fmt.Fprintf(t, "%d\t%s\t%s\t%d/%d\t%s\t%s\n",
+			j.id, j.kind, j.status, j.ind+1, len(j.queue),
+			sanitizeName(filepath.Base(j.queue[j.ind])), sanitizeName(j.dstDir))
+		j.mutex.Unlock()
+	}
+	t.Flush()
+
+	return b.String()
+}
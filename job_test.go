@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobWait(t *testing.T) {
+	var l jobList
+	j := l.add("copy", []string{"/a", "/b"}, "/c")
+
+	if err := j.wait(); err != nil {
+		t.Errorf("running job should not wait: %s", err)
+	}
+
+	if err := j.pause(); err != nil {
+		t.Errorf("pausing running job: %s", err)
+	}
+	if err := j.pause(); err == nil {
+		t.Errorf("pausing paused job should fail")
+	}
+
+	done := make(chan error)
+	go func() { done <- j.wait() }()
+
+	select {
+	case <-done:
+		t.Errorf("paused job should wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if err := j.unpause(); err != nil {
+		t.Errorf("resuming paused job: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("resumed job should not fail: %s", err)
+	}
+
+	j.pause()
+	go func() { done <- j.wait() }()
+	if err := j.stop(); err != nil {
+		t.Errorf("canceling paused job: %s", err)
+	}
+	if err := <-done; !errors.Is(err, errJobCanceled) {
+		t.Errorf("canceled job should fail but got: %v", err)
+	}
+	if err := j.unpause(); err == nil {
+		t.Errorf("resuming canceled job should fail")
+	}
+
+	if _, err := l.get(j.id); err != nil {
+		t.Errorf("getting job: %s", err)
+	}
+	l.remove(j)
+	if _, err := l.get(j.id); err == nil {
+		t.Errorf("getting removed job should fail")
+	}
+
+	var nilJob *job
+	if err := nilJob.wait(); err != nil {
+		t.Errorf("nil job should not wait: %s", err)
+	}
+}
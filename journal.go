@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Maximum number of operations kept in the journal file. Older operations are
+// discarded when new ones are recorded.
+const journalMax = 100
+
+type journalKind string
+
+const (
+	journalRename journalKind = "rename"
+	journalMove   journalKind = "move"
+	journalCopy   journalKind = "copy"
+	journalTrash  journalKind = "trash"
+)
+
+// journalEntry is a single path mutation from src to dst. For copies, src is
+// the original file and dst is the newly created copy. For trashed files, dst
+// is the location of the file inside the trash directory.
+type journalEntry struct {
+	src string
+	dst string
+}
+
+// journalOp is a group of entries recorded as a single operation so that
+// pasting multiple files can be reverted with a single undo. For copies, stamps
+// holds the fingerprint of each copy so that it is only removed when undoing if
+// it has not changed since.
+type journalOp struct {
+	kind    journalKind
+	undone  bool
+	entries []journalEntry
+	stamps  []string
+}
+
+// The journal file is shared with other clients and guarded by a file lock,
+// but operations in a single client can also be recorded concurrently from
+// different goroutines.
+var gJournalMutex sync.Mutex
+
+// lockJournal acquires the journal for reading and writing, which should be
+// released by calling the returned function.
+func lockJournal() (func(), error) {
+	gJournalMutex.Lock()
+
+	if err := os.MkdirAll(filepath.Dir(gJournalPath), 0o700); err != nil {
+		gJournalMutex.Unlock()
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+
+	f, err := os.OpenFile(gJournalPath+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		gJournalMutex.Unlock()
+		return nil, fmt.Errorf("opening journal lock file: %w", err)
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		gJournalMutex.Unlock()
+		return nil, fmt.Errorf("locking journal file: %w", err)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+		gJournalMutex.Unlock()
+	}, nil
+}
+
+// copyStamp returns a fingerprint of the file tree at path made of the names,
+// modes, sizes and modification times of its files. It is empty when the path
+// does not exist.
+func copyStamp(path string) (string, error) {
+	fsys := getFS(path)
+	if _, err := fsys.Lstat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	h := sha256.New()
+	err := walkFS(fsys, path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%q %v %d %d\n", rel, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+func (op *journalOp) String() string {
+	var sb strings.Builder
+	sb.WriteString(string(op.kind))
+	if op.undone {
+		sb.WriteString(" -")
+	} else {
+		sb.WriteString(" +")
+	}
+	for i, e := range op.entries {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.Quote(e.src))
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.Quote(e.dst))
+		if op.kind == journalCopy {
+			sb.WriteByte(' ')
+			sb.WriteString(strconv.Quote(op.stamps[i]))
+		}
+	}
+	return sb.String()
+}
+
+func (op *journalOp) desc() string {
+	if len(op.entries) == 1 {
+		return fmt.Sprintf("%s %s", op.kind, filepath.Base(op.entries[0].src))
+	}
+	return fmt.Sprintf("%s %d files", op.kind, len(op.entries))
+}
+
+func parseJournalOp(line string) (*journalOp, error) {
+	kind, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf("invalid journal entry: %s", line)
+	}
+
+	op := &journalOp{kind: journalKind(kind)}
+
+	state, rest, _ := strings.Cut(rest, " ")
+	switch state {
+	case "+":
+	case "-":
+		op.undone = true
+	default:
+		return nil, fmt.Errorf("invalid journal entry: %s", line)
+	}
+
+	var paths []string
+	for rest != "" {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal entry: %s", line)
+		}
+		path, _ := strconv.Unquote(quoted)
+		paths = append(paths, path)
+		rest = strings.TrimPrefix(rest[len(quoted):], " ")
+	}
+
+	// copies are recorded with the fingerprint of the copy after the paths
+	n := 2
+	if op.kind == journalCopy {
+		n = 3
+	}
+
+	if len(paths) == 0 || len(paths)%n != 0 {
+		return nil, fmt.Errorf("invalid journal entry: %s", line)
+	}
+
+	for i := 0; i < len(paths); i += n {
+		op.entries = append(op.entries, journalEntry{paths[i], paths[i+1]})
+		if op.kind == journalCopy {
+			op.stamps = append(op.stamps, paths[i+2])
+		}
+	}
+
+	return op, nil
+}
+
+func readJournal() ([]*journalOp, error) {
+	f, err := os.Open(gJournalPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening journal file: %w", err)
+	}
+	defer f.Close()
+
+	var ops []*journalOp
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		op, err := parseJournalOp(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal file: %w", err)
+	}
+
+	return ops, nil
+}
+
+// writeJournal replaces the journal file with the given operations. A new file
+// is written and renamed over the old one so that the journal is never left
+// partially written.
+func writeJournal(ops []*journalOp) error {
+	f, err := os.CreateTemp(filepath.Dir(gJournalPath), ".journal-*")
+	if err != nil {
+		return fmt.Errorf("creating journal file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, op := range ops {
+		fmt.Fprintln(w, op)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing journal file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing journal file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), gJournalPath); err != nil {
+		return fmt.Errorf("writing journal file: %w", err)
+	}
+
+	return nil
+}
+
+// recordJournal appends a new operation to the journal. Operations that were
+// undone are dropped since they can no longer be redone.
+func recordJournal(kind journalKind, entries []journalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	op := &journalOp{kind: kind, entries: entries}
+	if kind == journalCopy {
+		op.stamps = make([]string, len(entries))
+		for i, e := range entries {
+			stamp, err := copyStamp(e.dst)
+			if err != nil {
+				log.Printf("journal: %s", err)
+			}
+			op.stamps[i] = stamp
+		}
+	}
+
+	unlock, err := lockJournal()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ops, err := readJournal()
+	if err != nil {
+		return err
+	}
+
+	for len(ops) > 0 && ops[len(ops)-1].undone {
+		ops = ops[:len(ops)-1]
+	}
+
+	ops = append(ops, op)
+	if len(ops) > journalMax {
+		ops = ops[len(ops)-journalMax:]
+	}
+
+	return writeJournal(ops)
+}
+
+// movePath renames src to dst, falling back to copying and removing when the
+// paths are on different devices. It never overwrites an existing dst.
+func movePath(src, dst string) error {
+	dstFS := getFS(dst)
+	if _, err := dstFS.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	if err := dstFS.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	err := renamePath(src, dst)
+	if err == nil || !errCrossDevice(err) {
+		return err
+	}
+
+	if err := copyPath(src, dst, []string{"mode", "timestamps"}); err != nil {
+		return err
+	}
+
+	return getFS(src).RemoveAll(src)
+}
+
+func (op *journalOp) undo() error {
+	var errs []error
+
+	for i := len(op.entries) - 1; i >= 0; i-- {
+		e := op.entries[i]
+		switch op.kind {
+		case journalRename, journalMove:
+			if err := movePath(e.dst, e.src); err != nil {
+				errs = append(errs, err)
+			}
+		case journalCopy:
+			stamp, err := copyStamp(e.dst)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if stamp == "" {
+				continue
+			}
+			if stamp != op.stamps[i] {
+				errs = append(errs, fmt.Errorf("%s has changed since it was copied", e.dst))
+				continue
+			}
+			if err := getFS(e.dst).RemoveAll(e.dst); err != nil {
+				errs = append(errs, err)
+			}
+		case journalTrash:
+			if err := untrashFile(e.dst, e.src); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			return fmt.Errorf("unknown journal operation: %s", op.kind)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (op *journalOp) redo() error {
+	var errs []error
+
+	for i, e := range op.entries {
+		switch op.kind {
+		case journalRename, journalMove:
+			if err := movePath(e.src, e.dst); err != nil {
+				errs = append(errs, err)
+			}
+		case journalCopy:
+			if _, err := os.Lstat(e.dst); err == nil {
+				errs = append(errs, fmt.Errorf("%s already exists", e.dst))
+				continue
+			}
+			if err := copyPath(e.src, e.dst, gOpts.preserve); err != nil {
+				errs = append(errs, err)
+			}
+			stamp, err := copyStamp(e.dst)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			op.stamps[i] = stamp
+		case journalTrash:
+			if err := writeTrashInfo(e.dst, e.src); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := movePath(e.src, e.dst); err != nil {
+				os.Remove(trashInfoPath(e.dst))
+				errs = append(errs, err)
+			}
+		default:
+			return fmt.Errorf("unknown journal operation: %s", op.kind)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// undoJournal reverts the most recent operation that is not undone yet and
+// returns it for reporting.
+func undoJournal() (*journalOp, error) {
+	unlock, err := lockJournal()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ops, err := readJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	i := len(ops) - 1
+	for i >= 0 && ops[i].undone {
+		i--
+	}
+	if i < 0 {
+		return nil, errors.New("nothing to undo")
+	}
+
+	op := ops[i]
+	err = op.undo()
+
+	// The operation is marked as undone even on partial failure, as some of
+	// the entries may already be reverted and redoing them is still possible.
+	op.undone = true
+	if werr := writeJournal(ops); werr != nil {
+		err = errors.Join(err, werr)
+	}
+
+	return op, err
+}
+
+// redoJournal applies the oldest undone operation again and returns it for
+// reporting.
+func redoJournal() (*journalOp, error) {
+	unlock, err := lockJournal()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ops, err := readJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	i := len(ops) - 1
+	for i >= 0 && ops[i].undone {
+		i--
+	}
+	i++
+	if i >= len(ops) {
+		return nil, errors.New("nothing to redo")
+	}
+
+	op := ops[i]
+	err = op.redo()
+
+	op.undone = false
+	if werr := writeJournal(ops); werr != nil {
+		err = errors.Join(err, werr)
+	}
+
+	return op, err
+}
+
+func (nav *nav) undo(app *app) {
+	nav.replayJournal(app, "undo", undoJournal)
+}
+
+func (nav *nav) redo(app *app) {
+	nav.replayJournal(app, "redo", redoJournal)
+}
+
+func (nav *nav) replayJournal(app *app, name string, replay func() (*journalOp, error)) {
+	op, err := replay()
+	if op == nil {
+		app.ui.exprChan <- &callExpr{"echoerr", []string{fmt.Sprintf("%s: %s", name, err)}, 1}
+		return
+	}
+
+	if gSingleMode {
+		nav.renew()
+		app.ui.loadFile(app, true)
+	} else {
+		if _, rerr := remote("send load"); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}
+
+	if err != nil {
+		msg := fmt.Sprintf("%s: %s: %s", name, op.desc(), strings.ReplaceAll(err.Error(), "\n", "; "))
+		app.ui.exprChan <- &callExpr{"echoerr", []string{msg}, 1}
+		return
+	}
+
+	msg := fmt.Sprintf("%s: %s", name, op.desc())
+	app.ui.exprChan <- &callExpr{"echo", []string{msg}, 1}
+}
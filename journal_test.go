@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJournalOp(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp *journalOp
+	}{
+		{`rename + "/a/b" "/a/c"`, &journalOp{journalRename, false, []journalEntry{{"/a/b", "/a/c"}}, nil}},
+		{`move - "/a/b" "/c/b" "/a/d e" "/c/d e"`, &journalOp{journalMove, true, []journalEntry{{"/a/b", "/c/b"}, {"/a/d e", "/c/d e"}}, nil}},
+		{`copy + "/a/\"b\nc\"" "/d/\"b\nc\"" "0123abcd"`, &journalOp{journalCopy, false, []journalEntry{{"/a/\"b\nc\"", "/d/\"b\nc\""}}, []string{"0123abcd"}}},
+		{`rename`, nil},
+		{`rename ? "/a" "/b"`, nil},
+		{`rename + "/a"`, nil},
+		{`rename + /a /b`, nil},
+		{`copy + "/a" "/b"`, nil},
+	}
+
+	for _, test := range tests {
+		got, err := parseJournalOp(test.s)
+		if test.exp == nil {
+			if err == nil {
+				t.Errorf("at input '%s' expected error but got '%v'", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("at input '%s' unexpected error: %s", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("at input '%s' expected '%v' but got '%v'", test.s, test.exp, got)
+		}
+		if s := got.String(); s != test.s {
+			t.Errorf("at input '%s' expected round trip but got '%s'", test.s, s)
+		}
+	}
+}
+
+func TestJournalUndoCopy(t *testing.T) {
+	dir := t.TempDir()
+
+	oldPath := gJournalPath
+	gJournalPath = filepath.Join(dir, "journal")
+	defer func() { gJournalPath = oldPath }()
+
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("foo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	copyFile := func(dst string) {
+		if err := copyPath(src, dst, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordJournal(journalCopy, []journalEntry{{src, dst}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// unchanged copies are removed
+	dst := filepath.Join(dir, "a")
+	copyFile(dst)
+	if _, err := undoJournal(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+		t.Errorf("expected '%s' to be removed", dst)
+	}
+
+	// copies written after being copied are kept
+	dst = filepath.Join(dir, "b")
+	copyFile(dst)
+	if err := os.WriteFile(dst, []byte("foobar"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := undoJournal(); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("expected changed error but got '%v'", err)
+	}
+	if b, err := os.ReadFile(dst); err != nil || string(b) != "foobar" {
+		t.Errorf("expected '%s' to be kept but got '%s' (%v)", dst, b, err)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -38,6 +41,9 @@ var (
 	gLastDirPath    string
 	gSelectionPath  string
 	gSocketPath     string
+	gListenAddr     string
+	gConnectAddr    string
+	gTLS            bool
 	gLogPath        string
 	gSelect         string
 	gConfigPath     string
@@ -275,6 +281,11 @@ Options:
 		"",
 		"send remote `command` to server")
 
+	jsonMode := flag.Bool(
+		"json",
+		false,
+		"use the JSON protocol for the remote command")
+
 	cpuprofile := flag.String(
 		"cpuprofile",
 		"",
@@ -309,6 +320,21 @@ Options:
 		"",
 		"`path` to the log file to write messages")
 
+	flag.StringVar(&gListenAddr,
+		"listen",
+		"",
+		"`address` to listen on over TCP in addition to the socket (with -server)")
+
+	flag.StringVar(&gConnectAddr,
+		"connect",
+		"",
+		"`address` to connect to the server over TCP (with -remote)")
+
+	flag.BoolVar(&gTLS,
+		"tls",
+		false,
+		"use TLS for -listen and -connect")
+
 	flag.Parse()
 
 	gSocketPath = gDefaultSocketPath
@@ -339,6 +365,35 @@ Options:
 		flag.Usage()
 	case *showVersion:
 		printVersion()
+	case *remoteCmd != "" && *jsonMode:
+		req, err := remoteRequest(*remoteCmd)
+		if err != nil {
+			log.Fatalf("remote command: %s", err)
+		}
+		c, err := remoteConn(string(req))
+		if err != nil {
+			log.Fatalf("remote command: %s", err)
+		}
+		defer c.Close()
+		// events of `subscribe` are streamed after the response
+		r := bufio.NewReader(c)
+		resp, err := r.ReadBytes('\n')
+		if err != nil && len(resp) == 0 {
+			log.Fatalf("remote command: reading response from server: %s", err)
+		}
+		os.Stdout.Write(resp)
+		var pr protoResponse
+		if err := json.Unmarshal(resp, &pr); err != nil || !pr.OK {
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, r)
+	case *remoteCmd != "" && isSubscribe(*remoteCmd):
+		c, err := remoteConn(*remoteCmd)
+		if err != nil {
+			log.Fatalf("remote command: %s", err)
+		}
+		defer c.Close()
+		io.Copy(os.Stdout, c)
 	case *remoteCmd != "":
 		resp, err := remote(*remoteCmd)
 		if err != nil {
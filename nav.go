@@ -17,8 +17,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/djherbis/times"
 )
 
 // A linkState describes whether a file is a symlink and whether its target exists.
@@ -46,7 +44,8 @@ type file struct {
 }
 
 func newFile(path string) *file {
-	lstat, err := os.Lstat(path)
+	fsys := getFS(path)
+	lstat, err := fsys.Lstat(path)
 	if err != nil {
 		log.Printf("getting file information: %s", err)
 		return &file{
@@ -66,50 +65,28 @@ func newFile(path string) *file {
 	var linkTarget string
 
 	if lstat.Mode()&os.ModeSymlink != 0 {
-		stat, err := os.Stat(path)
+		stat, err := fsys.Stat(path)
 		if err == nil {
 			linkState = working
 			lstat = stat
 		} else {
 			linkState = broken
 		}
-		linkTarget, err = os.Readlink(path)
+		linkTarget, err = fsys.Readlink(path)
 		if err != nil {
 			log.Printf("reading link target: %s", err)
 		}
 	}
 
-	ts := times.Get(lstat)
-	at := ts.AccessTime()
-	// from [times.Timespec] docs:
-	// ChangeTime() panics unless HasChangeTime() is true and
-	// BirthTime() panics unless HasBirthTime() is true.
-
-	// default to ModTime if BirthTime cannot be determined
-	bt := lstat.ModTime()
-	if ts.HasBirthTime() {
-		bt = ts.BirthTime()
-	}
-	// default to ModTime if ChangeTime cannot be determined
-	ct := lstat.ModTime()
-	if ts.HasChangeTime() {
-		ct = ts.ChangeTime()
-	}
+	at, bt, ct := fileTimes(lstat)
 
 	dirCount := -1
 	if lstat.IsDir() && getDirCounts(filepath.Dir(path)) {
-		d, err := os.Open(path)
-		if err != nil {
-			log.Printf("opening file: %s", err)
+		names, err := fsys.Readdirnames(path, 10000)
+		if names == nil && err != io.EOF {
+			log.Printf("reading directory: %s", err)
 		} else {
-			names, err := d.Readdirnames(10000)
-			d.Close()
-
-			if names == nil && err != io.EOF {
-				log.Printf("reading directory: %s", err)
-			} else {
-				dirCount = len(names)
-			}
+			dirCount = len(names)
 		}
 	}
 
@@ -143,12 +120,10 @@ func (fs *fakeStat) IsDir() bool        { return false }
 func (fs *fakeStat) Sys() any           { return nil }
 
 func readdir(path string) ([]*file, error) {
-	f, err := os.Open(path)
-	if err != nil {
+	names, err := getFS(path).Readdirnames(path, -1)
+	if names == nil && err != nil {
 		return nil, err
 	}
-	names, err := f.Readdirnames(-1)
-	f.Close()
 
 	files := make([]*file, 0, len(names))
 	for _, fname := range names {
@@ -181,11 +156,28 @@ type dir struct {
 	filter         []string   // last filter for this directory
 	sortignorecase bool       // sortignorecase value from last sort
 	sortignoredia  bool       // sortignoredia value from last sort
+	tree           bool       // whether files were ordered as a tree in last sort
+	du             bool       // whether files were ordered by `du` sizes in last sort
+	duTotal        int64      // total size of files for `du` percentages from last sort
 	noPerm         bool       // whether lf has no permission to open the directory
 }
 
 func newDir(path string) *dir {
-	files, err := readdir(path)
+	var files []*file
+	var err error
+	if re := getGrep(path); re != nil {
+		files, err = readdirGrep(path, re)
+	} else if getDuplicates(path) {
+		files, err = readdirDuplicates(path)
+	} else if depth := getFlatten(path); depth != 0 {
+		files, err = readdirFlat(path, depth)
+	} else if expanded := getTreeExpanded(path); len(expanded) != 0 {
+		files, err = readdirTree(path, expanded)
+	} else if _, _, ok := splitArchive(path); ok {
+		files, err = readdirArchive(path)
+	} else {
+		files, err = readdir(path)
+	}
 	if err != nil {
 		log.Printf("reading directory: %s", err)
 	}
@@ -210,6 +202,7 @@ func (dir *dir) sort() {
 	dir.hiddenfiles = gOpts.hiddenfiles
 	dir.sortignorecase = getSortIgnoreCase(dir.path)
 	dir.sortignoredia = getSortIgnoreDia(dir.path)
+	dir.tree = isTreeLayout()
 
 	dir.files = dir.allFiles
 
@@ -240,7 +233,7 @@ func (dir *dir) sort() {
 	}
 
 	if !dir.hidden {
-		applyFilter(func(f *file) bool { return !isHidden(f, dir.path, dir.hiddenfiles) })
+		applyFilter(func(f *file) bool { return !isHiddenPath(f, dir.path, dir.hiddenfiles) })
 	}
 
 	if len(dir.filter) != 0 {
@@ -335,6 +328,17 @@ func (dir *dir) sort() {
 		})
 	}
 
+	dir.du = isDuPath(dir.path)
+	if dir.du {
+		dir.duTotal = duOrder(dir.files, dir.allFiles)
+	}
+
+	if getDuplicates(dir.path) {
+		duplicateOrder(dir.files)
+	} else if dir.tree {
+		dir.files = treeOrder(dir.files)
+	}
+
 	dir.ind = max(dir.ind, 0)
 	dir.ind = min(dir.ind, len(dir.files)-1)
 }
@@ -450,18 +454,29 @@ type nav struct {
 	moveTotalChan   chan int
 	deleteCountChan chan int
 	deleteTotalChan chan int
+	conflictChan    chan *conflictPrompt
 	preloadChan     chan string
 	previewChan     chan string
 	dirChan         chan *dir
 	regChan         chan *reg
 	fileChan        chan *file
 	delChan         chan string
+	fuzzyChan       chan fuzzyBatch
+	gitChan         chan *gitStatus
+	compareChan     chan *compareResult
+	duChan          chan *duResult
 	dirCache        map[string]*dir
 	regCache        map[string]*reg
 	clipboard       clipboard
 	marks           map[string]string
 	renameOldPath   string
 	renameNewPath   string
+	renamePlan      []journalEntry
+	fuzzy           *fuzzyFinder
+	comparison      *comparison
+	du              *duScan
+	gitRepos        map[string]*gitRepo
+	gitRoots        map[string]string
 	selections      map[string]int
 	tags            map[string]string
 	selectionInd    int
@@ -479,6 +494,11 @@ type nav struct {
 	preloadTimer    *time.Timer
 	jumpList        []string
 	jumpListInd     int
+	jobs            jobList
+	tabs            []*tab
+	tabInd          int
+	pane            *tab
+	paneInd         int
 }
 
 func (nav *nav) getDir(path string) *dir {
@@ -514,17 +534,30 @@ func (nav *nav) checkDir(dir *dir) {
 		return
 	}
 
-	s, err := os.Stat(dir.path)
-	if err != nil {
-		log.Printf("getting directory info: %s", err)
-		return
+	// remote directories are reloaded from the background check when they are
+	// modified, and only the options are checked here
+	var modTime time.Time
+	if isRemote(dir.path) {
+		path, loadTime := dir.path, dir.loadTime
+		checkRemote(path, func(s os.FileInfo) {
+			if s.ModTime().After(loadTime) && !s.ModTime().After(time.Now()) {
+				nav.dirChan <- newDir(path)
+			}
+		})
+	} else {
+		s, err := os.Stat(diskPath(dir.path))
+		if err != nil {
+			log.Printf("getting directory info: %s", err)
+			return
+		}
+		modTime = s.ModTime()
 	}
 
 	switch {
-	case s.ModTime().After(dir.loadTime):
+	case modTime.After(dir.loadTime):
 		// XXX: Linux builtin exFAT drivers are able to predict modifications in the future
 		// https://bugs.launchpad.net/ubuntu/+source/ubuntu-meta/+bug/1872504
-		if s.ModTime().After(time.Now()) {
+		if modTime.After(time.Now()) {
 			return
 		}
 
@@ -547,7 +580,8 @@ func (nav *nav) checkDir(dir *dir) {
 		dir.reverse != getReverse(dir.path) ||
 		!slices.Equal(dir.hiddenfiles, gOpts.hiddenfiles) ||
 		dir.sortignorecase != getSortIgnoreCase(dir.path) ||
-		dir.sortignoredia != getSortIgnoreDia(dir.path):
+		dir.sortignoredia != getSortIgnoreDia(dir.path) ||
+		dir.tree != isTreeLayout():
 		dir.loading = true
 		sd := *dir
 		go func() {
@@ -561,13 +595,17 @@ func (nav *nav) checkDir(dir *dir) {
 func (nav *nav) loadDirs(wd string) {
 	var dirPaths []string
 
-	for curr, base := wd, ""; !isRoot(base); curr, base = filepath.Dir(curr), filepath.Base(curr) {
+	for curr, base := wd, ""; ; curr, base = filepath.Dir(curr), filepath.Base(curr) {
 		dirPaths = append(dirPaths, curr)
 
 		dir := nav.getDir(curr)
 		if base != "" {
 			dir.sel(base, nav.height)
 		}
+
+		if isRoot(curr) || isRemoteRoot(curr) {
+			break
+		}
 	}
 
 	slices.Reverse(dirPaths)
@@ -583,14 +621,21 @@ func newNav(ui *ui) *nav {
 		moveTotalChan:   make(chan int, 1024),
 		deleteCountChan: make(chan int, 1024),
 		deleteTotalChan: make(chan int, 1024),
+		conflictChan:    make(chan *conflictPrompt, 1024),
 		preloadChan:     make(chan string, 1024),
 		previewChan:     make(chan string, 1024),
 		dirChan:         make(chan *dir),
 		regChan:         make(chan *reg),
 		fileChan:        make(chan *file),
 		delChan:         make(chan string),
+		fuzzyChan:       make(chan fuzzyBatch),
+		gitChan:         make(chan *gitStatus),
+		compareChan:     make(chan *compareResult),
+		duChan:          make(chan *duResult),
 		dirCache:        make(map[string]*dir),
 		regCache:        make(map[string]*reg),
+		gitRepos:        make(map[string]*gitRepo),
+		gitRoots:        make(map[string]string),
 		marks:           make(map[string]string),
 		selections:      make(map[string]int),
 		tags:            make(map[string]string),
@@ -599,6 +644,7 @@ func newNav(ui *ui) *nav {
 		preloadTimer:    time.NewTimer(0),
 		jumpList:        make([]string, 0),
 		jumpListInd:     -1,
+		tabs:            []*tab{newTab("")},
 	}
 
 	nav.resize(ui)
@@ -644,8 +690,17 @@ func (nav *nav) renew() {
 		nav.checkDir(dir)
 	}
 
+	if isDualLayout() && nav.pane != nil {
+		nav.checkDir(nav.getDir(nav.pane.path))
+	}
+
+	// remote selections are not checked to avoid a round trip for each of
+	// them, and are removed when they cannot be found by commands instead
 	for m := range nav.selections {
-		if _, err := os.Lstat(m); os.IsNotExist(err) {
+		if isRemote(m) {
+			continue
+		}
+		if _, err := os.Lstat(m); os.IsNotExist(err) && !isArchiveMember(m) {
 			delete(nav.selections, m)
 		}
 	}
@@ -661,6 +716,8 @@ func (nav *nav) reload() {
 
 	clear(nav.dirCache)
 	clear(nav.regCache)
+	clear(nav.gitRepos)
+	clear(nav.gitRoots)
 
 	nav.loadDirs(wd)
 
@@ -831,7 +888,7 @@ func matchPattern(pattern, name, path string) bool {
 }
 
 func (nav *nav) preload() {
-	if !gOpts.preview || !gOpts.preload {
+	if !previewEnabled() || !gOpts.preload {
 		return
 	}
 
@@ -878,7 +935,22 @@ func (nav *nav) preview(path string, win *win, mode string) {
 
 	var reader *bufio.Reader
 
-	if len(gOpts.previewer) != 0 {
+	// the previewer cannot read files inside archives or on remote hosts, so
+	// they are always previewed internally
+	internal := len(gOpts.previewer) == 0 || isRemote(path)
+
+	if isArchiveMember(path) {
+		internal = true
+
+		r, err := openArchiveMember(path)
+		if err != nil {
+			log.Printf("opening file: %s", err)
+			return
+		}
+
+		defer r.Close()
+		reader = bufio.NewReader(r)
+	} else if !internal {
 		cmd := exec.Command(
 			gOpts.previewer,
 			path,
@@ -920,7 +992,8 @@ func (nav *nav) preview(path string, win *win, mode string) {
 		defer out.Close()
 		reader = bufio.NewReader(out)
 	} else {
-		lstat, err := os.Lstat(path)
+		fsys := getFS(path)
+		lstat, err := fsys.Lstat(path)
 		if err != nil {
 			log.Printf("lstat: %s", err)
 			return
@@ -930,7 +1003,7 @@ func (nav *nav) preview(path string, win *win, mode string) {
 			return
 		}
 
-		f, err := os.Open(path)
+		f, err := fsys.Open(path)
 		if err != nil {
 			log.Printf("opening file: %s", err)
 			return
@@ -949,7 +1022,7 @@ func (nav *nav) preview(path string, win *win, mode string) {
 	// escape sequences that corrupt the display or enable code execution
 	// (e.g. OSC 52 clipboard writes). Replace control characters with
 	// U+FFFD so they are visible but cannot form escape sequences.
-	if len(gOpts.previewer) == 0 && !binary {
+	if internal && !binary {
 		sixel = false
 		for i, l := range lines {
 			lines[i] = sanitizePreview(l)
@@ -989,6 +1062,16 @@ func (nav *nav) loadReg(path string, volatile bool) *reg {
 }
 
 func (nav *nav) checkReg(reg *reg) {
+	if isRemote(reg.path) {
+		path, loadTime := reg.path, reg.loadTime
+		checkRemote(path, func(s os.FileInfo) {
+			if s.ModTime().After(loadTime) && !s.ModTime().After(time.Now()) {
+				nav.reloadReg(path)
+			}
+		})
+		return
+	}
+
 	s, err := os.Stat(reg.path)
 	if err != nil {
 		return
@@ -1005,14 +1088,19 @@ func (nav *nav) checkReg(reg *reg) {
 	if s.ModTime().After(reg.loadTime) {
 		reg.loadTime = now
 		reg.loading = true
-		if gOpts.preload {
-			select {
-			case nav.preloadChan <- reg.path:
-			default:
-			}
-		} else {
-			nav.previewChan <- reg.path
+		nav.reloadReg(reg.path)
+	}
+}
+
+// reloadReg asks the previewer to load the file again.
+func (nav *nav) reloadReg(path string) {
+	if gOpts.preload {
+		select {
+		case nav.preloadChan <- path:
+		default:
 		}
+	} else {
+		nav.previewChan <- path
 	}
 }
 
@@ -1138,7 +1226,7 @@ func (nav *nav) updir() error {
 		return nil
 	}
 
-	if err := os.Chdir(nav.dirPaths[len(nav.dirPaths)-2]); err != nil {
+	if err := chdir(nav.dirPaths[len(nav.dirPaths)-2]); err != nil {
 		return fmt.Errorf("updir: %w", err)
 	}
 
@@ -1152,10 +1240,16 @@ func (nav *nav) open() error {
 		return nil
 	}
 
-	if err := os.Chdir(curr.path); err != nil {
+	if err := chdir(curr.path); err != nil {
 		return fmt.Errorf("open: %w", err)
 	}
 
+	// directories listed in a flattened directory can be nested deeper
+	if filepath.Dir(curr.path) != nav.currDir().path {
+		nav.loadDirs(curr.path)
+		return nil
+	}
+
 	nav.dirPaths = append(nav.dirPaths, curr.path)
 	return nil
 }
@@ -1335,7 +1429,7 @@ func (nav *nav) save(mode clipboardMode) error {
 	return nil
 }
 
-func (nav *nav) copyAsync(app *app, srcs []string, dstDir string) {
+func (nav *nav) copyAsync(app *app, srcs []string, dstDir string, conflict conflictPolicy, preserve []string) {
 	errCount := 0
 	sendErr := func(format string, a ...any) {
 		errCount++
@@ -1343,12 +1437,15 @@ func (nav *nav) copyAsync(app *app, srcs []string, dstDir string) {
 		app.ui.exprChan <- &callExpr{"echoerr", []string{msg}, 1}
 	}
 
-	_, err := os.Stat(dstDir)
+	_, err := getFS(dstDir).Stat(dstDir)
 	if os.IsNotExist(err) {
 		sendErr("%v", err)
 		return
 	}
 
+	j := nav.startJob("copy", srcs, dstDir, conflict)
+	defer nav.jobs.remove(j)
+
 	// Indicate that a copy operation is in progress. Using the total bytes to
 	// determine this instead will mean that it is possible for copySize to take
 	// a while, but not be reflected in the UI until it has finished.
@@ -1363,12 +1460,17 @@ func (nav *nav) copyAsync(app *app, srcs []string, dstDir string) {
 
 	nav.copyTotalChan <- total
 
-	nums, errs := copyAll(srcs, dstDir, gOpts.preserve)
+	var entries []journalEntry
+	nums, errs := copyAll(srcs, dstDir, preserve, j, func(src, dst string) {
+		entries = append(entries, journalEntry{src, dst})
+	})
 
+	var copied int64
 loop:
 	for {
 		select {
 		case n := <-nums:
+			copied += n
 			nav.copyBytesChan <- n
 		case err, ok := <-errs:
 			if !ok {
@@ -1378,9 +1480,19 @@ loop:
 		}
 	}
 
+	// Account for the bytes skipped due to errors or cancellation, so that
+	// the progress of other copy operations remains correct.
+	if copied < total {
+		nav.copyBytesChan <- total - copied
+	}
+
 	nav.copyJobsChan <- -1
 	nav.copyTotalChan <- -total
 
+	if err := recordJournal(journalCopy, entries); err != nil {
+		sendErr("%v", err)
+	}
+
 	if gSingleMode {
 		nav.renew()
 		app.ui.loadFile(app, true)
@@ -1390,12 +1502,21 @@ loop:
 		}
 	}
 
-	if errCount == 0 {
-		app.ui.exprChan <- &callExpr{"echo", []string{"\033[0;32mCopied successfully\033[0m"}, 1}
+	summary := j.verifySummary()
+	if j.canceled() {
+		app.ui.exprChan <- &callExpr{"echo", []string{fmt.Sprintf("copy: job %d canceled", j.id)}, 1}
+	} else if errCount == 0 {
+		msg := "Copied successfully"
+		if summary != "" {
+			msg += " (" + summary + ")"
+		}
+		app.ui.exprChan <- &callExpr{"echo", []string{"\033[0;32m" + msg + "\033[0m"}, 1}
+	} else if summary != "" {
+		sendErr("%s", summary)
 	}
 }
 
-func (nav *nav) moveAsync(app *app, srcs []string, dstDir string) {
+func (nav *nav) moveAsync(app *app, srcs []string, dstDir string, conflict conflictPolicy) {
 	errCount := 0
 	sendErr := func(format string, a ...any) {
 		errCount++
@@ -1403,46 +1524,61 @@ func (nav *nav) moveAsync(app *app, srcs []string, dstDir string) {
 		app.ui.exprChan <- &callExpr{"echoerr", []string{msg}, 1}
 	}
 
-	_, err := os.Stat(dstDir)
+	_, err := getFS(dstDir).Stat(dstDir)
 	if os.IsNotExist(err) {
 		sendErr("%v", err)
 		return
 	}
 
+	j := nav.startJob("move", srcs, dstDir, conflict)
+	defer nav.jobs.remove(j)
+
 	nav.moveTotalChan <- len(srcs)
 
+	var entries []journalEntry
+
 	for _, src := range srcs {
+		if j.wait() != nil {
+			break
+		}
+		j.next(src)
+
 		nav.moveCountChan <- 1
 
-		srcStat, err := os.Lstat(src)
+		srcStat, err := getFS(src).Lstat(src)
 		if err != nil {
 			sendErr("%v", err)
 			continue
 		}
 
-		file := filepath.Base(src)
-		dst := filepath.Join(dstDir, file)
+		if dstStat, err := getFS(dstDir).Lstat(filepath.Join(dstDir, filepath.Base(src))); err == nil && os.SameFile(srcStat, dstStat) {
+			sendErr("rename %s %s: source and destination are the same file", src, filepath.Join(dstDir, filepath.Base(src)))
+			continue
+		}
 
-		if dstStat, err := os.Lstat(dst); err == nil {
-			if os.SameFile(srcStat, dstStat) {
-				sendErr("rename %s %s: source and destination are the same file", src, dst)
-				continue
-			}
-			ext := getFileExtension(dstStat)
-			basename := file[:len(file)-len(ext)]
-			var newPath string
-			for i := 1; !os.IsNotExist(err); i++ {
-				file = strings.ReplaceAll(gOpts.dupfilefmt, "%f", basename+ext)
-				file = strings.ReplaceAll(file, "%b", basename)
-				file = strings.ReplaceAll(file, "%e", ext)
-				file = strings.ReplaceAll(file, "%n", strconv.Itoa(i))
-				newPath = filepath.Join(dstDir, file)
-				_, err = os.Lstat(newPath)
+		dst, merge, ok, err := copyDst(src, dstDir, j)
+		if err != nil {
+			sendErr("%v", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if merge {
+			err := moveMerge(src, dst, j, func(src, dst string) {
+				entries = append(entries, journalEntry{src, dst})
+			})
+			if err != nil && !errors.Is(err, errJobCanceled) {
+				sendErr("%v", err)
 			}
-			dst = newPath
+			continue
 		}
 
-		if err := os.Rename(src, dst); err != nil {
+		_, err = getFS(dst).Lstat(dst)
+		fresh := os.IsNotExist(err)
+
+		if err := renamePath(src, dst); err != nil {
 			if errCrossDevice(err) {
 				nav.copyJobsChan <- 1
 
@@ -1455,13 +1591,15 @@ func (nav *nav) moveAsync(app *app, srcs []string, dstDir string) {
 
 				nav.copyTotalChan <- total
 
-				nums, errs := copyAll([]string{src}, dstDir, []string{"mode", "timestamps"})
+				nums, errs := copyTo(src, dst, []string{"mode", "timestamps"}, j)
 
 				oldCount := errCount
+				var copied int64
 			loop:
 				for {
 					select {
 					case n := <-nums:
+						copied += n
 						nav.copyBytesChan <- n
 					case err, ok := <-errs:
 						if !ok {
@@ -1471,22 +1609,37 @@ func (nav *nav) moveAsync(app *app, srcs []string, dstDir string) {
 					}
 				}
 
+				if copied < total {
+					nav.copyBytesChan <- total - copied
+				}
+
 				nav.copyJobsChan <- -1
 				nav.copyTotalChan <- -total
 
-				if errCount == oldCount {
-					if err := os.RemoveAll(src); err != nil {
+				if errCount == oldCount && !j.canceled() {
+					if err := getFS(src).RemoveAll(src); err != nil {
 						sendErr("%v", err)
+					} else if fresh {
+						entries = append(entries, journalEntry{src, dst})
 					}
 				}
 			} else {
 				sendErr("%v", err)
 			}
+			continue
+		}
+
+		if fresh {
+			entries = append(entries, journalEntry{src, dst})
 		}
 	}
 
 	nav.moveTotalChan <- -len(srcs)
 
+	if err := recordJournal(journalMove, entries); err != nil {
+		sendErr("%v", err)
+	}
+
 	if gSingleMode {
 		nav.renew()
 		app.ui.loadFile(app, true)
@@ -1496,13 +1649,22 @@ func (nav *nav) moveAsync(app *app, srcs []string, dstDir string) {
 		}
 	}
 
-	if errCount == 0 {
+	summary := j.verifySummary()
+	if j.canceled() {
+		app.ui.exprChan <- &callExpr{"echo", []string{fmt.Sprintf("move: job %d canceled", j.id)}, 1}
+	} else if errCount == 0 {
+		msg := "Moved successfully"
+		if summary != "" {
+			msg += " (" + summary + ")"
+		}
 		app.ui.exprChan <- &callExpr{"clear", nil, 1}
-		app.ui.exprChan <- &callExpr{"echo", []string{"\033[0;32mMoved successfully\033[0m"}, 1}
+		app.ui.exprChan <- &callExpr{"echo", []string{"\033[0;32m" + msg + "\033[0m"}, 1}
+	} else if summary != "" {
+		sendErr("%s", summary)
 	}
 }
 
-func (nav *nav) paste(app *app) error {
+func (nav *nav) paste(app *app, conflict conflictPolicy) error {
 	clipboard, err := loadFiles()
 	if err != nil {
 		return err
@@ -1514,10 +1676,18 @@ func (nav *nav) paste(app *app) error {
 
 	dstDir := nav.currDir().path
 
+	// files can only be copied out of archives
+	if _, _, ok := splitArchive(dstDir); ok {
+		return errArchiveReadOnly
+	}
+	if clipboard.mode != clipboardCopy && slices.ContainsFunc(clipboard.paths, isArchiveMember) {
+		return errArchiveReadOnly
+	}
+
 	if clipboard.mode == clipboardCopy {
-		go nav.copyAsync(app, clipboard.paths, dstDir)
+		go nav.copyAsync(app, clipboard.paths, dstDir, conflict, gOpts.preserve)
 	} else {
-		go nav.moveAsync(app, clipboard.paths, dstDir)
+		go nav.moveAsync(app, clipboard.paths, dstDir, conflict)
 	}
 
 	return nil
@@ -1534,11 +1704,32 @@ func (nav *nav) del(app *app) error {
 		errCount := 0
 
 		nav.deleteTotalChan <- len(list)
+		opEvent("op-start", 0, "delete", list, "", "")
+
+		var entries []journalEntry
 
 		for _, path := range list {
 			nav.deleteCountChan <- 1
 
-			if err := os.RemoveAll(path); err != nil {
+			if gOpts.deletemethod == "trash" {
+				if isRemote(path) {
+					errCount++
+					echo.args[0] = fmt.Sprintf("[%d] trash is not supported on remote hosts: %s", errCount, path)
+					app.ui.exprChan <- echo
+					continue
+				}
+				trashed, err := trashFile(path)
+				if err != nil {
+					errCount++
+					echo.args[0] = fmt.Sprintf("[%d] %s", errCount, err)
+					app.ui.exprChan <- echo
+					continue
+				}
+				entries = append(entries, journalEntry{path, trashed})
+				continue
+			}
+
+			if err := getFS(path).RemoveAll(path); err != nil {
 				errCount++
 				echo.args[0] = fmt.Sprintf("[%d] %s", errCount, err)
 				app.ui.exprChan <- echo
@@ -1546,6 +1737,13 @@ func (nav *nav) del(app *app) error {
 		}
 
 		nav.deleteTotalChan <- -len(list)
+		opEvent("op-finish", 0, "delete", nil, "", "done")
+
+		if err := recordJournal(journalTrash, entries); err != nil {
+			errCount++
+			echo.args[0] = fmt.Sprintf("[%d] %s", errCount, err)
+			app.ui.exprChan <- echo
+		}
 
 		if gSingleMode {
 			nav.renew()
@@ -1566,11 +1764,15 @@ func (nav *nav) rename() error {
 	oldPath := nav.renameOldPath
 	newPath := nav.renameNewPath
 
-	if err := os.Rename(oldPath, newPath); err != nil {
+	if err := renamePath(oldPath, newPath); err != nil {
 		return err
 	}
 
-	lstat, err := os.Lstat(newPath)
+	if err := recordJournal(journalRename, []journalEntry{{oldPath, newPath}}); err != nil {
+		log.Printf("rename: %s", err)
+	}
+
+	lstat, err := getFS(newPath).Lstat(newPath)
 	if err != nil {
 		return err
 	}
@@ -1626,7 +1828,7 @@ func (nav *nav) sync() error {
 }
 
 func (nav *nav) cd(path string) error {
-	if err := os.Chdir(path); err != nil {
+	if err := chdir(path); err != nil {
 		return err
 	}
 
@@ -1687,7 +1889,7 @@ func (nav *nav) findSingle() int {
 	index := 0
 	dir := nav.currDir()
 	for i := range dir.files {
-		if findMatch(dir.files[i].Name(), nav.find) {
+		if findMatch(filepath.Base(dir.files[i].Name()), nav.find) {
 			count++
 			if count > 1 {
 				return count
@@ -1708,13 +1910,13 @@ func (nav *nav) findSingle() int {
 func (nav *nav) findNext() (bool, bool) {
 	dir := nav.currDir()
 	for i := dir.ind + 1; i < len(dir.files); i++ {
-		if findMatch(dir.files[i].Name(), nav.find) {
+		if findMatch(filepath.Base(dir.files[i].Name()), nav.find) {
 			return nav.down(i - dir.ind), true
 		}
 	}
 	if gOpts.wrapscan {
 		for i := range dir.ind {
-			if findMatch(dir.files[i].Name(), nav.find) {
+			if findMatch(filepath.Base(dir.files[i].Name()), nav.find) {
 				dir.visualWrap++
 				return nav.up(dir.ind - i), true
 			}
@@ -1726,13 +1928,13 @@ func (nav *nav) findNext() (bool, bool) {
 func (nav *nav) findPrev() (bool, bool) {
 	dir := nav.currDir()
 	for i := dir.ind - 1; i >= 0; i-- {
-		if findMatch(dir.files[i].Name(), nav.find) {
+		if findMatch(filepath.Base(dir.files[i].Name()), nav.find) {
 			return nav.up(dir.ind - i), true
 		}
 	}
 	if gOpts.wrapscan {
 		for i := len(dir.files) - 1; i > dir.ind; i-- {
-			if findMatch(dir.files[i].Name(), nav.find) {
+			if findMatch(filepath.Base(dir.files[i].Name()), nav.find) {
 				dir.visualWrap--
 				return nav.down(i - dir.ind), true
 			}
@@ -1813,8 +2015,10 @@ func (nav *nav) searchPrev() (bool, error) {
 }
 
 func isFiltered(f os.FileInfo, filter []string) bool {
+	// files in flattened directories are named with their relative paths, but
+	// filters should apply to the file name only
 	for _, pattern := range filter {
-		matched, err := searchMatch(f.Name(), strings.TrimPrefix(pattern, "!"), gOpts.filtermethod)
+		matched, err := searchMatch(filepath.Base(f.Name()), strings.TrimPrefix(pattern, "!"), gOpts.filtermethod)
 		if err != nil {
 			log.Printf("Filter Error: %s", err)
 			return false
@@ -2028,7 +2232,7 @@ func (nav *nav) calcDirSize() error {
 	}
 
 	for sel := range nav.selections {
-		lstat, err := os.Lstat(sel)
+		lstat, err := getFS(sel).Lstat(sel)
 		if err != nil || !lstat.IsDir() {
 			continue
 		}
@@ -87,10 +87,12 @@ var gOpts struct {
 	borderstyle      borderStyle
 	cleaner          string
 	copyfmt          string
+	copyverify       string
 	cursoractivefmt  string
 	cursorparentfmt  string
 	cursorpreviewfmt string
 	cutfmt           string
+	deletemethod     string
 	dircounts        bool
 	dirfirst         bool
 	dironly          bool
@@ -113,6 +115,7 @@ var gOpts struct {
 	info             []string
 	infotimefmtnew   string
 	infotimefmtold   string
+	layout           string
 	menufmt          string
 	menuheaderfmt    string
 	menuselectfmt    string
@@ -121,6 +124,7 @@ var gOpts struct {
 	number           bool
 	numbercursorfmt  string
 	numberfmt        string
+	pasteconflict    conflictPolicy
 	period           int
 	preload          bool
 	preserve         []string
@@ -248,10 +252,12 @@ func init() {
 	gOpts.borderstyle = borderBox
 	gOpts.cleaner = ""
 	gOpts.copyfmt = "\033[7;33m"
+	gOpts.copyverify = "none"
 	gOpts.cursoractivefmt = "\033[7m"
 	gOpts.cursorparentfmt = "\033[7m"
 	gOpts.cursorpreviewfmt = "\033[4m"
 	gOpts.cutfmt = "\033[7;31m"
+	gOpts.deletemethod = "remove"
 	gOpts.dircounts = false
 	gOpts.dirfirst = true
 	gOpts.dironly = false
@@ -274,6 +280,7 @@ func init() {
 	gOpts.info = nil
 	gOpts.infotimefmtnew = "Jan _2 15:04"
 	gOpts.infotimefmtold = "Jan _2  2006"
+	gOpts.layout = "miller"
 	gOpts.menufmt = "\033[0m"
 	gOpts.menuheaderfmt = "\033[1m"
 	gOpts.menuselectfmt = "\033[7m"
@@ -282,6 +289,7 @@ func init() {
 	gOpts.number = false
 	gOpts.numbercursorfmt = ""
 	gOpts.numberfmt = "\033[33m"
+	gOpts.pasteconflict = conflictRename
 	gOpts.period = 0
 	gOpts.preload = false
 	gOpts.preserve = []string{"mode"}
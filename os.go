@@ -4,6 +4,7 @@ package main
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -41,6 +42,10 @@ var (
 	gMarksPath   string
 	gTagsPath    string
 	gHistoryPath string
+	gJournalPath string
+	gTokenPath   string
+	gCertPath    string
+	gKeyPath     string
 )
 
 func init() {
@@ -104,6 +109,10 @@ func init() {
 		filepath.Join(config, "lf", "icons"),
 	}
 
+	gTokenPath = filepath.Join(config, "lf", "server-token")
+	gCertPath = filepath.Join(config, "lf", "server.crt")
+	gKeyPath = filepath.Join(config, "lf", "server.key")
+
 	data := cmp.Or(
 		os.Getenv("LF_DATA_HOME"),
 		os.Getenv("XDG_DATA_HOME"),
@@ -114,6 +123,7 @@ func init() {
 	gMarksPath = filepath.Join(data, "lf", "marks")
 	gTagsPath = filepath.Join(data, "lf", "tags")
 	gHistoryPath = filepath.Join(data, "lf", "history")
+	gJournalPath = filepath.Join(data, "lf", "journal")
 
 	// Use a private per-user dir when XDG_RUNTIME_DIR is unset
 	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
@@ -152,6 +162,10 @@ func shellCommand(s string, args []string) *exec.Cmd {
 	return exec.Command(gOpts.shell, args...)
 }
 
+func editorCommand(path string) *exec.Cmd {
+	return shellCommand(`$EDITOR "$1"`, []string{path})
+}
+
 func shellSetPG(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
 }
@@ -236,7 +250,17 @@ func linkCount(f os.FileInfo) string {
 }
 
 func errCrossDevice(err error) bool {
-	return err.(*os.LinkError).Err.(unix.Errno) == unix.EXDEV
+	return errors.Is(err, unix.EXDEV) || errors.Is(err, errCrossFS)
+}
+
+// lockFile acquires an exclusive lock on the file, waiting until it is
+// released by other processes.
+func lockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
 }
 
 func quoteString(s string) string {
@@ -270,3 +294,23 @@ func shellUnescape(s string) string {
 	}
 	return string(buf)
 }
+
+// ttyName returns the path of the terminal device of the standard input,
+// which is found by comparing it with the devices in `/dev`.
+func ttyName() string {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return ""
+	}
+
+	for _, pattern := range []string{"/dev/pts/*", "/dev/tty*"} {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			if fi2, err := os.Stat(path); err == nil && os.SameFile(fi, fi2) {
+				return path
+			}
+		}
+	}
+
+	return ""
+}
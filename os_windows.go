@@ -2,6 +2,7 @@ package main
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -38,6 +39,10 @@ var (
 	gTagsPath    string
 	gMarksPath   string
 	gHistoryPath string
+	gJournalPath string
+	gTokenPath   string
+	gCertPath    string
+	gKeyPath     string
 )
 
 func init() {
@@ -93,6 +98,10 @@ func init() {
 		filepath.Join(config, "lf", "icons"),
 	}
 
+	gTokenPath = filepath.Join(config, "lf", "server-token")
+	gCertPath = filepath.Join(config, "lf", "server.crt")
+	gKeyPath = filepath.Join(config, "lf", "server.key")
+
 	data := cmp.Or(
 		os.Getenv("LF_DATA_HOME"),
 		os.Getenv("XDG_DATA_HOME"),
@@ -103,6 +112,7 @@ func init() {
 	gMarksPath = filepath.Join(data, "lf", "marks")
 	gTagsPath = filepath.Join(data, "lf", "tags")
 	gHistoryPath = filepath.Join(data, "lf", "history")
+	gJournalPath = filepath.Join(data, "lf", "journal")
 
 	runtimeDir := os.TempDir()
 	gDefaultSocketPath = filepath.Join(runtimeDir, "lf.sock")
@@ -143,6 +153,10 @@ func shellCommand(s string, args []string) *exec.Cmd {
 	return exec.Command(gOpts.shell, args...)
 }
 
+func editorCommand(path string) *exec.Cmd {
+	return shellCommand("%EDITOR%", []string{path})
+}
+
 func shellSetPG(_ *exec.Cmd) {
 }
 
@@ -217,7 +231,15 @@ func linkCount(_ os.FileInfo) string {
 }
 
 func errCrossDevice(err error) bool {
-	return err.(*os.LinkError).Err.(windows.Errno) == windows.ERROR_NOT_SAME_DEVICE
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE) || errors.Is(err, errCrossFS)
+}
+
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, new(windows.Overlapped))
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, new(windows.Overlapped))
 }
 
 func quoteString(s string) string {
@@ -240,3 +262,7 @@ func shellEscape(s string) string {
 func shellUnescape(s string) string {
 	return strings.ReplaceAll(s, `"`, "")
 }
+
+func ttyName() string {
+	return ""
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gdamore/tcell/v3"
+)
+
+// In the dual layout, two directory panes are shown side by side. The state of
+// the active pane is kept in `nav` similar to tabs, and the inactive pane is
+// stored as a separate navigation context which is swapped when switching.
+
+func isDualLayout() bool {
+	return gOpts.layout == "dual"
+}
+
+// previewEnabled reports whether the preview pane is shown, which is never the
+// case in the dual layout.
+func previewEnabled() bool {
+	return gOpts.preview && !isDualLayout()
+}
+
+// initPane creates the inactive pane from the current state the first time the
+// dual layout is enabled. Selections and the jump list are not shared.
+func (nav *nav) initPane() {
+	if nav.pane != nil {
+		return
+	}
+
+	p := &tab{}
+	nav.saveState(p)
+	p.selections = make(map[string]int)
+	p.selectionInd = 0
+	p.jumpList = []string{p.path}
+	p.jumpListInd = 0
+
+	nav.pane = p
+	nav.paneInd = 0
+}
+
+// switchPane swaps the active and inactive panes.
+func (nav *nav) switchPane() error {
+	if !isDualLayout() {
+		return errors.New("requires 'layout' to be 'dual'")
+	}
+
+	t := &tab{}
+	nav.saveState(t)
+	if err := nav.loadState(nav.pane); err != nil {
+		return err
+	}
+
+	nav.pane = t
+	nav.paneInd ^= 1
+	return nil
+}
+
+// paneDir returns the directory shown in the inactive pane. The cached
+// directory is copied so that the view state of the inactive pane does not
+// affect the active one.
+func (nav *nav) paneDir() *dir {
+	p := nav.pane
+	d := nav.getDir(p.path)
+	v := p.views[p.path]
+
+	view := *d
+	if !slices.Equal(d.filter, v.filter) {
+		view.allFiles = slices.Clone(d.allFiles)
+		view.filter = v.filter
+		view.sort()
+	}
+	view.ind, view.pos = v.ind, v.pos
+	view.sel(v.name, nav.height)
+
+	return &view
+}
+
+// paneTarget returns the directory of the inactive pane used as the
+// destination for `pane-copy` and `pane-move`.
+func (nav *nav) paneTarget() (string, error) {
+	if !isDualLayout() {
+		return "", errors.New("requires 'layout' to be 'dual'")
+	}
+	return nav.pane.path, nil
+}
+
+func (ui *ui) drawPanes(nav *nav, context *dirContext) {
+	for i, win := range ui.wins[:2] {
+		var dir *dir
+		var path string
+		ctx := context
+		role := Parent
+		if i == nav.paneInd {
+			dir = nav.currDir()
+			path = dir.path
+			role = Active
+		} else {
+			dir = nav.paneDir()
+			path = nav.pane.path
+			ctx = &dirContext{selections: nav.pane.selections, clipboard: nav.clipboard, tags: nav.tags, git: nav.gitStatus, compare: nav.compareStates()}
+		}
+
+		dirStyle := &dirStyle{colors: ui.styles, icons: ui.icons, role: role}
+		win.printDir(ui, dir, ctx, dirStyle, nav.previewTimer)
+
+		// the header of the active pane is highlighted to indicate the active pane
+		path = sanitizeName(path)
+		if after, ok := strings.CutPrefix(path, gUser.HomeDir); ok {
+			path = filepath.Join("~", after)
+		}
+		header := newWin(win.w, 1, win.x, win.y-1)
+		label := truncateLeft(" "+path+" ", win.w)
+		st := tcell.StyleDefault
+		if i == nav.paneInd {
+			st = st.Reverse(true)
+		}
+		header.print(ui.screen, 0, 0, st, label)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Besides the line based commands, the server accepts requests encoded as JSON
+// objects on a single line, which are answered with a JSON object on a single
+// line. Requests can be given an `id` which is returned with the response, and
+// failures are reported with an error code instead of plain text. Since file
+// names are encoded as JSON strings, they can contain any characters including
+// newlines, and empty results are distinguished from missing ones.
+
+// protoVersion is the version of the JSON protocol, which is incremented when
+// requests or responses change incompatibly.
+const protoVersion = 1
+
+type protoRequest struct {
+	Version int             `json:"version"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Op      string          `json:"op"`
+	Client  *int            `json:"client,omitempty"`
+	Select  clientSelector  `json:"select,omitempty"`
+	Command string          `json:"command,omitempty"`
+	Type    string          `json:"type,omitempty"`
+	Events  []string        `json:"events,omitempty"`
+	Force   bool            `json:"force,omitempty"`
+	Token   string          `json:"token,omitempty"`
+}
+
+type protoResponse struct {
+	Version int             `json:"version"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	OK      bool            `json:"ok"`
+	Result  any             `json:"result,omitempty"`
+	Error   *protoError     `json:"error,omitempty"`
+}
+
+type protoErrorCode string
+
+const (
+	protoInvalidRequest     protoErrorCode = "invalid_request"
+	protoUnsupportedVersion protoErrorCode = "unsupported_version"
+	protoUnknownOp          protoErrorCode = "unknown_op"
+	protoNoSuchClient       protoErrorCode = "no_such_client"
+	protoClientsConnected   protoErrorCode = "clients_connected"
+	protoSendFailed         protoErrorCode = "send_failed"
+	protoQueryFailed        protoErrorCode = "query_failed"
+	protoUnknownQuery       protoErrorCode = "unknown_query"
+	protoNotReady           protoErrorCode = "not_ready"
+	protoUnauthorized       protoErrorCode = "unauthorized"
+	protoAmbiguousClient    protoErrorCode = "ambiguous_client"
+)
+
+type protoError struct {
+	Code    protoErrorCode `json:"code"`
+	Message string         `json:"message"`
+}
+
+func (e *protoError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newProtoError(code protoErrorCode, format string, a ...any) *protoError {
+	return &protoError{code, fmt.Sprintf(format, a...)}
+}
+
+// encodeResponse returns the response line for the given result or error.
+func encodeResponse(id json.RawMessage, result any, perr *protoError) []byte {
+	resp := protoResponse{Version: protoVersion, ID: id, OK: perr == nil, Result: result, Error: perr}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		resp = protoResponse{Version: protoVersion, ID: id, Error: newProtoError(protoQueryFailed, "encoding response: %s", err)}
+		b, _ = json.Marshal(resp)
+	}
+	return append(b, '\n')
+}
+
+// decodeRequest parses a request line and checks the version and the
+// arguments of the operation.
+func decodeRequest(line []byte) (*protoRequest, *protoError) {
+	var req protoRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &req, newProtoError(protoInvalidRequest, "%s", err)
+	}
+
+	if req.Version != protoVersion {
+		return &req, newProtoError(protoUnsupportedVersion, "version should be %d", protoVersion)
+	}
+
+	if req.Select != nil {
+		if req.Op != "send" && req.Op != "query" {
+			return &req, newProtoError(protoInvalidRequest, "%s: select is not supported", req.Op)
+		}
+		if req.Client != nil {
+			return &req, newProtoError(protoInvalidRequest, "%s: requires either a client id or select", req.Op)
+		}
+		if err := req.Select.validate(); err != nil {
+			return &req, newProtoError(protoInvalidRequest, "%s: %s", req.Op, err)
+		}
+	}
+
+	switch req.Op {
+	case "list", "quit":
+	case "send":
+		if req.Command == "" {
+			return &req, newProtoError(protoInvalidRequest, "send: requires a command")
+		}
+		// commands are sent to clients as a single line
+		if strings.ContainsAny(req.Command, "\n\r") {
+			return &req, newProtoError(protoInvalidRequest, "send: command should not contain newlines")
+		}
+	case "query":
+		if req.Client == nil && req.Select == nil {
+			return &req, newProtoError(protoInvalidRequest, "query: requires a client id or select")
+		}
+		if req.Type == "" {
+			return &req, newProtoError(protoInvalidRequest, "query: requires a type")
+		}
+	case "drop":
+		if req.Client == nil {
+			return &req, newProtoError(protoInvalidRequest, "drop: requires a client id")
+		}
+	case "subscribe":
+		if err := validateEvents(req.Events); err != nil {
+			return &req, newProtoError(protoInvalidRequest, "subscribe: %s", err)
+		}
+	case "":
+		return &req, newProtoError(protoInvalidRequest, "requires an op")
+	default:
+		return &req, newProtoError(protoUnknownOp, "unknown op: %s", req.Op)
+	}
+
+	return &req, nil
+}
+
+// remoteRequest converts a line based command given to `-remote` into a JSON
+// request. Commands already encoded as JSON are returned as is.
+func remoteRequest(cmd string) ([]byte, error) {
+	cmd = strings.TrimSpace(cmd)
+	if strings.HasPrefix(cmd, "{") {
+		return []byte(cmd), nil
+	}
+
+	req := protoRequest{Version: protoVersion}
+
+	parseID := func(s string) (*int, error) {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("client id should be a number: %s", s)
+		}
+		return &id, nil
+	}
+
+	word, rest := splitWord(cmd)
+	switch word {
+	case "list":
+		req.Op = "list"
+	case "send":
+		req.Op = "send"
+		word2, rest2 := splitWord(rest)
+		if id, err := strconv.Atoi(word2); err == nil {
+			req.Client = &id
+			req.Command = rest2
+		} else if sel, ok := parseSelector(word2); ok {
+			req.Select = sel
+			req.Command = rest2
+		} else {
+			req.Command = rest
+		}
+	case "query":
+		req.Op = "query"
+		word2, rest2 := splitWord(rest)
+		if sel, ok := parseSelector(word2); ok {
+			req.Select = sel
+		} else {
+			id, err := parseID(word2)
+			if err != nil {
+				return nil, err
+			}
+			req.Client = id
+		}
+		req.Type = rest2
+	case "drop":
+		req.Op = "drop"
+		word2, _ := splitWord(rest)
+		id, err := parseID(word2)
+		if err != nil {
+			return nil, err
+		}
+		req.Client = id
+	case "subscribe":
+		req.Op = "subscribe"
+		id, events, err := parseSubscribe(rest)
+		if err != nil {
+			return nil, err
+		}
+		if id != allClients {
+			req.Client = &id
+		}
+		req.Events = events
+	case "quit", "quit!":
+		req.Op = "quit"
+		req.Force = word == "quit!"
+	case "":
+		return nil, errors.New("empty command")
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", word)
+	}
+
+	return json.Marshal(req)
+}
+
+// queryState returns the response line of a client for a query of the given
+// type, which fails with `not_ready` if the state is not saved yet or the
+// current directory is still loading.
+func queryState(typ string) []byte {
+	_, val, err := lookupState(typ)
+	switch {
+	case errors.Is(err, errNotReady):
+		return encodeResponse(nil, nil, newProtoError(protoNotReady, "%s is %s", typ, err))
+	case err != nil:
+		return encodeResponse(nil, nil, newProtoError(protoUnknownQuery, "%s", err))
+	}
+	return encodeResponse(nil, val, nil)
+}
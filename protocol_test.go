@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteRequest(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		exp  string
+		fail bool
+	}{
+		{"list", `{"version":1,"op":"list"}`, false},
+		{"send echo hi", `{"version":1,"op":"send","command":"echo hi"}`, false},
+		{"send 42 echo hi", `{"version":1,"op":"send","client":42,"command":"echo hi"}`, false},
+		{"query 42 files", `{"version":1,"op":"query","client":42,"type":"files"}`, false},
+		{"send tty=/dev/pts/3 echo hi", `{"version":1,"op":"send","select":{"tty":"/dev/pts/3"},"command":"echo hi"}`, false},
+		{"send set ratios=1:2", `{"version":1,"op":"send","command":"set ratios=1:2"}`, false},
+		{"query cwd=/a files", `{"version":1,"op":"query","select":{"cwd":"/a"},"type":"files"}`, false},
+		{"query foo=bar files", "", true},
+		{"drop 42", `{"version":1,"op":"drop","client":42}`, false},
+		{"quit", `{"version":1,"op":"quit"}`, false},
+		{"quit!", `{"version":1,"op":"quit","force":true}`, false},
+		{"subscribe all", `{"version":1,"op":"subscribe"}`, false},
+		{"subscribe 42 cd cursor", `{"version":1,"op":"subscribe","client":42,"events":["cd","cursor"]}`, false},
+		{"subscribe all bogus", "", true},
+		{` {"version":1,"op":"list","id":"a"} `, `{"version":1,"op":"list","id":"a"}`, false},
+		{"query foo files", "", true},
+		{"drop", "", true},
+		{"conn 42", "", true},
+		{"", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := remoteRequest(test.cmd)
+		if (err != nil) != test.fail {
+			t.Errorf("at input '%s' expected failure '%t' but got error '%v'", test.cmd, test.fail, err)
+			continue
+		}
+		if string(got) != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.cmd, test.exp, got)
+		}
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		line string
+		code protoErrorCode
+	}{
+		{`{"version":1,"op":"list"}`, ""},
+		{`{"version":1,"op":"query","client":1,"type":"files"}`, ""},
+		{`{"version":1,"op":"query","select":{"cwd":"/a","tty":"/dev/pts/3"},"type":"files"}`, ""},
+		{`{"version":1,"op":"query","client":1,"select":{"cwd":"/a"},"type":"files"}`, protoInvalidRequest},
+		{`{"version":1,"op":"query","select":{},"type":"files"}`, protoInvalidRequest},
+		{`{"version":1,"op":"send","select":{"foo":"bar"},"command":"echo"}`, protoInvalidRequest},
+		{`{"version":1,"op":"drop","select":{"pid":"1"}}`, protoInvalidRequest},
+		{`{"version":1,"op":"send","command":"echo a\nb"}`, protoInvalidRequest},
+		{`{"version":1,"op":"send"}`, protoInvalidRequest},
+		{`{"version":1,"op":"query","type":"files"}`, protoInvalidRequest},
+		{`{"version":1,"op":"drop"}`, protoInvalidRequest},
+		{`{"version":1,"op":"subscribe","events":["cd"]}`, ""},
+		{`{"version":1,"op":"subscribe","events":["bogus"]}`, protoInvalidRequest},
+		{`{"version":1}`, protoInvalidRequest},
+		{`{"version":2,"op":"list"}`, protoUnsupportedVersion},
+		{`{"op":"list"}`, protoUnsupportedVersion},
+		{`{"version":1,"op":"conn"}`, protoUnknownOp},
+		{`{"version":1,`, protoInvalidRequest},
+	}
+
+	for _, test := range tests {
+		_, perr := decodeRequest([]byte(test.line))
+		var code protoErrorCode
+		if perr != nil {
+			code = perr.Code
+		}
+		if code != test.code {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.line, test.code, code)
+		}
+	}
+}
+
+// the server manages connections in a single goroutine for all tests
+var startManage = sync.OnceFunc(func() { go manage() })
+
+// waitClient waits until the client with the given id is registered.
+func waitClient(id int) {
+	for {
+		resp := &protoResponse{}
+		send(srvCmd{op: "list", resp: resp})
+		if slices.Contains(resp.Result.(clientList).Clients, id) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandleJSON(t *testing.T) {
+	startManage()
+
+	// fake client answering queries with a file name containing a newline
+	client, server := net.Pipe()
+	go handleConn(server, nil)
+	fmt.Fprintln(client, `conn 7 {"pid":7,"tty":"/dev/pts/7","cwd":"/a","hostname":"h"}`)
+	go func() {
+		s := bufio.NewScanner(client)
+		for s.Scan() {
+			switch s.Text() {
+			case "query-json files":
+				client.Write(encodeResponse(nil, []string{"/a", "/b\nc"}, nil))
+			case "query-json jumps":
+				client.Write(encodeResponse(nil, nil, newProtoError(protoNotReady, "jumps is not available yet")))
+			}
+		}
+	}()
+	waitClient(7)
+
+	tests := []struct {
+		req string
+		exp string
+	}{
+		{`{"version":1,"id":1,"op":"list"}`, `{"version":1,"id":1,"ok":true,"result":{"clients":[7],"info":[{"id":7,"pid":7,"tty":"/dev/pts/7","cwd":"/b","hostname":"h"}]}}`},
+		{`{"version":1,"id":"x","op":"query","client":7,"type":"files"}`, `{"version":1,"id":"x","ok":true,"result":["/a","/b\nc"]}`},
+		{`{"version":1,"op":"query","select":{"cwd":"/b/"},"type":"files"}`, `{"version":1,"ok":true,"result":["/a","/b\nc"]}`},
+		{`{"version":1,"op":"query","select":{"cwd":"/a"},"type":"files"}`, `{"version":1,"ok":false,"error":{"code":"no_such_client","message":"listen: query: no client matches cwd=/a"}}`},
+		{`{"version":1,"op":"send","select":{"tty":"/dev/pts/8"},"command":"echo"}`, `{"version":1,"ok":false,"error":{"code":"no_such_client","message":"listen: send: no client matches tty=/dev/pts/8"}}`},
+		{`{"version":1,"op":"query","client":7,"type":"jumps"}`, `{"version":1,"ok":false,"error":{"code":"not_ready","message":"jumps is not available yet"}}`},
+		{`{"version":1,"op":"query","client":8,"type":"files"}`, `{"version":1,"ok":false,"error":{"code":"no_such_client","message":"listen: query: no such client id is connected"}}`},
+		{`{"version":1,"op":"send","client":8,"command":"echo"}`, `{"version":1,"ok":false,"error":{"code":"no_such_client","message":"listen: send: no such client id is connected"}}`},
+		{`{"version":1,"op":"quit"}`, `{"version":1,"ok":false,"error":{"code":"clients_connected","message":"listen: quit: clients are still connected"}}`},
+		{`{"version":2,"id":3,"op":"list"}`, `{"version":1,"id":3,"ok":false,"error":{"code":"unsupported_version","message":"version should be 1"}}`},
+		{`{"version":1,"op":"drop","client":7}`, `{"version":1,"ok":true}`},
+		{`{"version":1,"op":"list"}`, `{"version":1,"ok":true,"result":{"clients":[],"info":[]}}`},
+	}
+
+	c, s := net.Pipe()
+	defer c.Close()
+	go handleConn(s, nil)
+	r := bufio.NewReader(c)
+
+	// metadata of clients is updated with `cd` events
+	fmt.Fprintln(c, `event 7 cd {"client":7,"event":"cd","path":"/b"}`)
+
+	for _, test := range tests {
+		fmt.Fprintln(c, test.req)
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("at input '%s' unexpected error: %s", test.req, err)
+		}
+		if got := string(line[:len(line)-1]); got != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.req, test.exp, got)
+		}
+		if !json.Valid(line) {
+			t.Errorf("at input '%s' got invalid JSON '%s'", test.req, line)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	startManage()
+
+	tests := []struct {
+		lines []string
+		exp   string
+	}{
+		{[]string{"auth secret", `{"version":1,"id":1,"op":"list"}`}, `{"version":1,"id":1,"ok":true,"result":{"clients":[`},
+		{[]string{`{"version":1,"id":2,"op":"list","token":"secret"}`}, `{"version":1,"id":2,"ok":true,"result":{"clients":[`},
+		{[]string{"auth secre", `{"version":1,"op":"list"}`}, "listen: auth: invalid token\n"},
+		{[]string{"auth", "list"}, "listen: auth: invalid token\n"},
+		{[]string{"list"}, "listen: auth: invalid token\n"},
+		{[]string{`{"version":1,"id":3,"op":"list","token":"secret2"}`}, `{"version":1,"id":3,"ok":false,"error":{"code":"unauthorized","message":"listen: auth: invalid token"}}` + "\n"},
+		{[]string{`{"version":1,"op":"list"}`}, `{"version":1,"ok":false,"error":{"code":"unauthorized","message":"listen: auth: invalid token"}}` + "\n"},
+	}
+
+	for _, test := range tests {
+		c, s := net.Pipe()
+		go handleConn(s, []byte("secret"))
+		go func() {
+			for _, line := range test.lines {
+				if _, err := fmt.Fprintln(c, line); err != nil {
+					return
+				}
+			}
+		}()
+
+		line, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil {
+			t.Errorf("at input '%q' unexpected error: %s", test.lines, err)
+		} else if !strings.HasPrefix(line, test.exp) {
+			t.Errorf("at input '%q' expected '%s' but got '%s'", test.lines, test.exp, line)
+		}
+		c.Close()
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on windows")
+	}
+
+	oldPath := gTokenPath
+	gTokenPath = filepath.Join(t.TempDir(), "server-token")
+	defer func() { gTokenPath = oldPath }()
+
+	tests := []struct {
+		perm os.FileMode
+		ok   bool
+	}{
+		{0o600, true},
+		{0o400, true},
+		{0o640, false},
+		{0o604, false},
+	}
+
+	for _, test := range tests {
+		if err := os.WriteFile(gTokenPath, []byte("secret\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(gTokenPath, test.perm); err != nil {
+			t.Fatal(err)
+		}
+		token, err := readToken()
+		if test.ok && (err != nil || string(token) != "secret") {
+			t.Errorf("at permissions '%#o' expected token but got '%s' (%v)", test.perm, token, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("at permissions '%#o' expected error", test.perm)
+		}
+		os.Remove(gTokenPath)
+	}
+}
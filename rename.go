@@ -0,0 +1,479 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// planRenames orders the given renames so that no file is overwritten. Renames
+// forming a cycle (e.g. swapping `a` and `b`) are resolved by first moving one
+// of the files to a temporary name. The exists function reports whether a path
+// is occupied by a file that is not renamed itself.
+func planRenames(renames []journalEntry, exists func(string) bool) ([]journalEntry, error) {
+	srcs := make(map[string]bool)
+	dsts := make(map[string]bool)
+	for _, r := range renames {
+		if r.dst == "" || strings.ContainsAny(filepath.Base(r.dst), "\n\r") {
+			return nil, fmt.Errorf("invalid name for %s", r.src)
+		}
+		if srcs[r.src] {
+			return nil, fmt.Errorf("duplicate file: %s", r.src)
+		}
+		if dsts[r.dst] {
+			return nil, fmt.Errorf("multiple files renamed to %s", r.dst)
+		}
+		srcs[r.src] = true
+		dsts[r.dst] = true
+	}
+
+	for _, r := range renames {
+		if !srcs[r.dst] && exists(r.dst) {
+			return nil, fmt.Errorf("file already exists: %s", r.dst)
+		}
+	}
+
+	pending := slices.Clone(renames)
+	var steps []journalEntry
+	tmpCount := 0
+
+	for len(pending) > 0 {
+		progress := false
+		for i := 0; i < len(pending); i++ {
+			r := pending[i]
+			if srcs[r.dst] {
+				continue
+			}
+			steps = append(steps, r)
+			delete(srcs, r.src)
+			pending = slices.Delete(pending, i, i+1)
+			i--
+			progress = true
+		}
+
+		if progress {
+			continue
+		}
+
+		// All remaining renames are blocked by each other, so break the cycle
+		// by moving the first file out of the way.
+		r := pending[0]
+		var tmp string
+		for {
+			tmpCount++
+			tmp = filepath.Join(filepath.Dir(r.src), fmt.Sprintf(".lf-rename-%d-%s", tmpCount, filepath.Base(r.src)))
+			if !srcs[tmp] && !dsts[tmp] && !exists(tmp) {
+				break
+			}
+		}
+		steps = append(steps, journalEntry{r.src, tmp})
+		delete(srcs, r.src)
+		srcs[tmp] = true
+		pending[0].src = tmp
+	}
+
+	return steps, nil
+}
+
+// applyRenames performs the planned renames in order and returns the renames
+// done successfully.
+func applyRenames(steps []journalEntry) ([]journalEntry, error) {
+	var done []journalEntry
+
+	for _, r := range steps {
+		fsys := getFS(r.dst)
+		if err := fsys.MkdirAll(filepath.Dir(r.dst), os.ModePerm); err != nil {
+			return done, fmt.Errorf("mkdir: %w", err)
+		}
+		if _, err := fsys.Lstat(r.dst); err == nil {
+			return done, fmt.Errorf("file already exists: %s", r.dst)
+		}
+		if err := renamePath(r.src, r.dst); err != nil {
+			return done, err
+		}
+		done = append(done, r)
+	}
+
+	return done, nil
+}
+
+// collapseRenames merges the intermediate steps of the planned renames for
+// displaying them, so that temporary names are not shown.
+func collapseRenames(steps []journalEntry) []journalEntry {
+	var renames []journalEntry
+	for _, s := range steps {
+		i := slices.IndexFunc(renames, func(r journalEntry) bool { return r.dst == s.src })
+		if i >= 0 {
+			renames[i].dst = s.dst
+		} else {
+			renames = append(renames, s)
+		}
+	}
+	return renames
+}
+
+// renamePattern is a regular expression and a template used to rename files
+// with the `rename-pattern` command. The template supports `$1` style capture
+// groups and the placeholders below enclosed in braces:
+//
+//	n       sequence counter starting from 1 (e.g. `{n:03}` for padding)
+//	name    file name without the extension
+//	ext     file extension including the dot
+//	1, foo  capture group by index or name
+//
+// Placeholders other than `n` can be followed by a case transform, which is
+// either `upper`, `lower` or `title` (e.g. `{name:upper}`).
+type renamePattern struct {
+	re   *regexp.Regexp
+	tmpl string
+}
+
+// parseRenamePattern parses the input of `rename-pattern`, where the first
+// word is the regular expression and the rest is the template.
+func parseRenamePattern(s string) (*renamePattern, error) {
+	expr, tmpl := splitWord(s)
+	if expr == "" {
+		return nil, errors.New("missing pattern")
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &renamePattern{re, tmpl}, nil
+}
+
+// apply returns the new name for a file by replacing the first match of the
+// pattern with the expanded template. The counter n is used for the `{n}`
+// placeholder. It returns false if the name does not match the pattern.
+func (p *renamePattern) apply(name, ext string, n int) (string, bool, error) {
+	match := p.re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name, false, nil
+	}
+
+	repl, err := p.expand(name, ext, n, match)
+	if err != nil {
+		return "", false, err
+	}
+
+	return name[:match[0]] + repl + name[match[1]:], true, nil
+}
+
+func (p *renamePattern) expand(name, ext string, n int, match []int) (string, error) {
+	var b []byte
+	var lit strings.Builder
+
+	flush := func() {
+		b = p.re.ExpandString(b, lit.String(), name, match)
+		lit.Reset()
+	}
+
+	for s := p.tmpl; s != ""; {
+		switch {
+		case strings.HasPrefix(s, "{{"), strings.HasPrefix(s, "}}"):
+			flush()
+			b = append(b, s[0])
+			s = s[2:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", errors.New("unclosed capture group")
+			}
+			lit.WriteString(s[:end+1])
+			s = s[end+1:]
+		case s[0] == '{':
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", errors.New("unclosed placeholder")
+			}
+			val, err := p.placeholder(s[1:end], name, ext, n, match)
+			if err != nil {
+				return "", err
+			}
+			flush()
+			b = append(b, val...)
+			s = s[end+1:]
+		default:
+			lit.WriteByte(s[0])
+			s = s[1:]
+		}
+	}
+	flush()
+
+	return string(b), nil
+}
+
+func (p *renamePattern) placeholder(s, name, ext string, n int, match []int) (string, error) {
+	field, spec, _ := strings.Cut(s, ":")
+
+	if field == "n" {
+		if spec == "" {
+			return strconv.Itoa(n), nil
+		}
+		width, err := strconv.Atoi(spec)
+		if err != nil || width < 0 {
+			return "", fmt.Errorf("invalid counter width: %s", spec)
+		}
+		if spec[0] == '0' {
+			return fmt.Sprintf("%0*d", width, n), nil
+		}
+		return fmt.Sprintf("%*d", width, n), nil
+	}
+
+	var val string
+	switch field {
+	case "name":
+		val = strings.TrimSuffix(name, ext)
+	case "ext":
+		val = ext
+	default:
+		i, err := strconv.Atoi(field)
+		if err != nil {
+			i = p.re.SubexpIndex(field)
+		}
+		if i < 0 || i > p.re.NumSubexp() {
+			return "", fmt.Errorf("unknown placeholder: %s", field)
+		}
+		if match[2*i] >= 0 {
+			val = name[match[2*i]:match[2*i+1]]
+		}
+	}
+
+	switch spec {
+	case "":
+		return val, nil
+	case "upper":
+		return strings.ToUpper(val), nil
+	case "lower":
+		return strings.ToLower(val), nil
+	case "title":
+		return toTitle(val), nil
+	default:
+		return "", fmt.Errorf("unknown case transform: %s", spec)
+	}
+}
+
+// toTitle capitalizes the first letter of each word and lowercases the rest.
+func toTitle(s string) string {
+	inWord := false
+	return strings.Map(func(r rune) rune {
+		first := !inWord
+		inWord = unicode.IsLetter(r) || unicode.IsDigit(r)
+		if first {
+			return unicode.ToTitle(r)
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// patternRenames returns the renames for the given files according to the
+// pattern. Files not matching the pattern are not renamed, and the counter is
+// only incremented for matching files.
+func patternRenames(p *renamePattern, paths []string) ([]journalEntry, error) {
+	var renames []journalEntry
+	n := 0
+
+	for _, path := range paths {
+		name := filepath.Base(path)
+		ext := ""
+		if lstat, err := os.Lstat(path); err == nil {
+			ext = getFileExtension(lstat)
+		}
+
+		newName, ok, err := p.apply(name, ext, n+1)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		n++
+
+		if newName == name {
+			continue
+		}
+		if newName == "" || strings.ContainsAny(newName, "/"+string(os.PathSeparator)) {
+			return nil, fmt.Errorf("invalid name for %s: %q", name, newName)
+		}
+
+		renames = append(renames, journalEntry{path, filepath.Join(filepath.Dir(path), newName)})
+	}
+
+	return renames, nil
+}
+
+// renameTargets returns the files to be renamed by `bulk-rename` and
+// `rename-pattern`, which are the selected files or all files in the current
+// directory if there is no selection.
+func (nav *nav) renameTargets() []string {
+	paths := nav.currSelections()
+	if len(paths) == 0 {
+		for _, f := range nav.currDir().files {
+			paths = append(paths, f.path)
+		}
+	}
+	return paths
+}
+
+// previewPatternRenames renders the menu showing the renames for the current
+// input of the `rename-pattern` prompt.
+func (app *app) previewPatternRenames(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+
+	var renames []journalEntry
+	p, err := parseRenamePattern(s)
+	if err == nil {
+		renames, err = patternRenames(p, app.nav.renameTargets())
+	}
+	if err != nil {
+		menu, _ := listColumns(app.ui.screen, "invalid pattern: "+err.Error(), nil, -1)
+		return menu
+	}
+
+	maxLines := max(app.ui.msgWin.y-1, 2)
+	entries := make([]string, 0, len(renames))
+	for i, r := range renames {
+		if i >= maxLines-1 && len(renames) > maxLines {
+			entries = append(entries, fmt.Sprintf("... %d more", len(renames)-i))
+			break
+		}
+		entries = append(entries, filepath.Base(r.src)+" -> "+filepath.Base(r.dst))
+	}
+
+	menu, _ := listColumns(app.ui.screen, fmt.Sprintf("rename preview (%d files)", len(renames)), entries, -1)
+	return menu
+}
+
+// bulkRename writes the names of the given files to a temporary file, opens it
+// in the editor and returns the renames for the lines changed by the user.
+func (app *app) bulkRename(paths []string) ([]journalEntry, error) {
+	wd := app.nav.currDir().path
+
+	rel := func(path string) string {
+		if filepath.Dir(path) == wd {
+			return filepath.Base(path)
+		}
+		return path
+	}
+
+	var b strings.Builder
+	for _, path := range paths {
+		if strings.ContainsAny(path, "\n\r") {
+			return nil, fmt.Errorf("newline in file name: %q", path)
+		}
+		b.WriteString(rel(path))
+		b.WriteByte('\n')
+	}
+
+	f, err := os.CreateTemp("", "lf-bulk-rename-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("writing temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("writing temporary file: %w", err)
+	}
+
+	cmd := editorCommand(f.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	app.runCmdSync(cmd, false)
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("reading temporary file: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	if len(lines) != len(paths) {
+		return nil, errors.New("number of lines changed")
+	}
+
+	var renames []journalEntry
+	for i, line := range lines {
+		if line == rel(paths[i]) {
+			continue
+		}
+		if line == "" {
+			return nil, fmt.Errorf("empty name for %s", paths[i])
+		}
+		dst := replaceTilde(line)
+		if !filepath.IsAbs(dst) {
+			dst = filepath.Join(wd, dst)
+		}
+		renames = append(renames, journalEntry{paths[i], filepath.Clean(dst)})
+	}
+
+	return renames, nil
+}
+
+// commitRenames performs the planned renames stored for the confirmation
+// prompt, records them in the journal and refreshes the affected directories.
+func (app *app) commitRenames(name string) error {
+	steps := app.nav.renamePlan
+	app.nav.renamePlan = nil
+
+	done, err := applyRenames(steps)
+
+	if jerr := recordJournal(journalRename, done); jerr != nil {
+		err = errors.Join(err, jerr)
+	}
+
+	dirs := make(map[string]bool)
+	for _, r := range done {
+		deletePathRecursive(app.nav.dirCache, r.src)
+		deletePathRecursive(app.nav.regCache, r.src)
+		deletePathRecursive(app.nav.dirCache, r.dst)
+		deletePathRecursive(app.nav.regCache, r.dst)
+		delete(app.nav.selections, r.src)
+		dirs[filepath.Dir(r.src)] = true
+		dirs[filepath.Dir(r.dst)] = true
+	}
+
+	// Modification times of the parent directories may not change when files
+	// are renamed in quick succession, so cached directories are always
+	// reloaded instead of relying on `checkDir`.
+	for path := range dirs {
+		if d, ok := app.nav.dirCache[path]; ok && !d.loading {
+			d.loading = true
+			go func() {
+				app.nav.dirChan <- newDir(path)
+			}()
+		}
+	}
+
+	if gSingleMode {
+		app.nav.renew()
+		app.ui.loadFile(app, true)
+	} else {
+		if _, rerr := remote("send load"); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	app.ui.echo(fmt.Sprintf("%s: %d files renamed", name, len(collapseRenames(done))))
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPlanRenames(t *testing.T) {
+	tests := []struct {
+		renames []journalEntry
+		files   []string
+		exp     []journalEntry
+		fail    bool
+	}{
+		{
+			[]journalEntry{{"/a", "/b"}},
+			[]string{"/a"},
+			[]journalEntry{{"/a", "/b"}},
+			false,
+		},
+		{
+			[]journalEntry{{"/a", "/b"}, {"/b", "/c"}},
+			[]string{"/a", "/b"},
+			[]journalEntry{{"/b", "/c"}, {"/a", "/b"}},
+			false,
+		},
+		{
+			[]journalEntry{{"/a", "/b"}, {"/b", "/a"}},
+			[]string{"/a", "/b"},
+			[]journalEntry{{"/a", "/.lf-rename-1-a"}, {"/b", "/a"}, {"/.lf-rename-1-a", "/b"}},
+			false,
+		},
+		{
+			[]journalEntry{{"/a", "/b"}, {"/b", "/c"}, {"/c", "/a"}},
+			[]string{"/a", "/b", "/c", "/.lf-rename-1-a"},
+			[]journalEntry{{"/a", "/.lf-rename-2-a"}, {"/c", "/a"}, {"/b", "/c"}, {"/.lf-rename-2-a", "/b"}},
+			false,
+		},
+		{
+			[]journalEntry{{"/a", "/c"}, {"/b", "/c"}},
+			[]string{"/a", "/b"},
+			nil,
+			true,
+		},
+		{
+			[]journalEntry{{"/a", "/b"}},
+			[]string{"/a", "/b"},
+			nil,
+			true,
+		},
+		{
+			[]journalEntry{{"/a", ""}},
+			[]string{"/a"},
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := planRenames(test.renames, func(path string) bool {
+			return slices.Contains(test.files, path)
+		})
+		if test.fail {
+			if err == nil {
+				t.Errorf("at input '%v' expected error but got '%v'", test.renames, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("at input '%v' unexpected error: %s", test.renames, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("at input '%v' expected '%v' but got '%v'", test.renames, test.exp, got)
+		}
+		if collapsed := collapseRenames(got); len(collapsed) != len(test.renames) {
+			t.Errorf("at input '%v' expected %d collapsed renames but got '%v'", test.renames, len(test.renames), collapsed)
+		}
+	}
+}
+
+func TestRenamePatternApply(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		ext     string
+		n       int
+		exp     string
+		ok      bool
+	}{
+		{`foo bar`, "foo.txt", ".txt", 1, "bar.txt", true},
+		{`foo bar`, "baz.txt", ".txt", 1, "baz.txt", false},
+		{`^(\w+)-(\w+) $2-$1`, "a-b.txt", ".txt", 1, "b-a.txt", true},
+		{`^(\w+)-(\w+) ${2}x-${1}`, "a-b", "", 1, "bx-a", true},
+		{`^.*$ img_{n:03}{ext}`, "IMG1234.JPG", ".JPG", 7, "img_007.JPG", true},
+		{`^.*$ {n:3}`, "a", "", 12, " 12", true},
+		{`^.*$ {n}`, "a", "", 12, "12", true},
+		{`^.*$ {name:upper}{ext:lower}`, "photo.JPG", ".JPG", 1, "PHOTO.jpg", true},
+		{`^(?P<word>\w+) {word:title}`, "hello_world", "", 1, "Hello_World", true},
+		{`^.*$ {name:title}`, "hello big-world", "", 1, "Hello Big-World", true},
+		{`(\d+) {1:upper}{{x}}`, "a1", "", 1, "a1{x}", true},
+		{`.* a b`, "x", "", 1, "a b", true},
+	}
+
+	for _, test := range tests {
+		p, err := parseRenamePattern(test.pattern)
+		if err != nil {
+			t.Errorf("at input '%s' unexpected error: %s", test.pattern, err)
+			continue
+		}
+		got, ok, err := p.apply(test.name, test.ext, test.n)
+		if err != nil {
+			t.Errorf("at input '%s' unexpected error: %s", test.pattern, err)
+			continue
+		}
+		if got != test.exp || ok != test.ok {
+			t.Errorf("at input '%s' and name '%s' expected '%s' (%t) but got '%s' (%t)", test.pattern, test.name, test.exp, test.ok, got, ok)
+		}
+	}
+
+	for _, s := range []string{`(`, ``, `a {n:x}`, `a {foo}`, `a {2}`, `a {name:bad}`, `a {name`} {
+		p, err := parseRenamePattern(s)
+		if err == nil {
+			_, _, err = p.apply("a", "", 1)
+		}
+		if err == nil {
+			t.Errorf("at input '%s' expected error", s)
+		}
+	}
+}
@@ -52,6 +52,19 @@ type rulerData struct {
 	Options          map[string]string
 	UserOptions      map[string]string
 	Stat             *statData
+	Tabs             []string
+	Tab              int
+
+	git func() *gitData
+}
+
+// Git returns the repository information of the current directory, which is
+// only loaded when it is used in the ruler.
+func (d rulerData) Git() *gitData {
+	if d.git == nil {
+		return nil
+	}
+	return d.git()
 }
 
 func parseRuler(path string) (*template.Template, error) {
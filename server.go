@@ -2,26 +2,53 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"maps"
 	"net"
 	"os"
+	"runtime"
 	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// Time allowed for connections over TCP to send the token before they are
+// closed, so that unauthenticated connections are not kept open.
+const authTimeout = 10 * time.Second
+
 type srvCmd struct {
 	op   string
 	id   int
 	msg  string
 	c    net.Conn
 	done chan struct{}
+	resp *protoResponse // set for requests of the JSON protocol
+	info *clientInfo    // set for `conn` commands
+	sel  clientSelector // set for commands targeting clients by metadata
+}
+
+// fail reports an error of a command, which is written to the connection for
+// the line based protocol and saved in the response for the JSON protocol.
+func (cmd srvCmd) fail(code protoErrorCode, msg string) {
+	if cmd.resp == nil {
+		echoerr(cmd.c, msg)
+		return
+	}
+	log.Print(msg)
+	if cmd.resp.Error == nil {
+		cmd.resp.Error = &protoError{code, msg}
+	}
 }
 
 var (
 	gCmdChan  = make(chan srvCmd)
-	gQuitChan = make(chan struct{}, 1)
+	gQuitChan = make(chan struct{})
 	gListener net.Listener
 )
 
@@ -48,46 +75,219 @@ func serve() {
 
 	gListener = l
 
+	if gListenAddr != "" {
+		tl, token, err := listenTCP(gListenAddr)
+		if err != nil {
+			log.Printf("listening on %s: %s", gListenAddr, err)
+			return
+		}
+		defer tl.Close()
+
+		log.Printf("listening on %s", tl.Addr())
+		go listen(tl, token)
+	}
+
 	go manage()
 
-	listen(l)
+	listen(l, nil)
+}
+
+// listenTCP listens on the given address over TCP, with TLS if `-tls` is
+// given, and returns the token which clients should authenticate with.
+func listenTCP(addr string) (net.Listener, []byte, error) {
+	token, err := readToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !gTLS {
+		l, err := net.Listen("tcp", addr)
+		return l, token, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(gCertPath, gKeyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	l, err := tls.Listen("tcp", addr, config)
+	return l, token, err
+}
+
+// readToken reads the token for connections over TCP from the token file in
+// the config directory.
+func readToken() ([]byte, error) {
+	// the token is refused if it is readable by others as in ssh
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(gTokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading token: %w", err)
+		}
+		if fi.Mode().Perm()&0o077 != 0 {
+			return nil, fmt.Errorf("reading token: permissions %#o for %s are too open", fi.Mode().Perm(), gTokenPath)
+		}
+	}
+
+	b, err := os.ReadFile(gTokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading token: %w", err)
+	}
+	token := bytes.TrimSpace(b)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("reading token: %s is empty", gTokenPath)
+	}
+	return token, nil
+}
+
+// authenticate checks the token given in the first line of a connection,
+// which is either an `auth` command or a JSON request with a `token` field.
+func authenticate(c net.Conn, line, token []byte) bool {
+	if bytes.HasPrefix(line, []byte("{")) {
+		var req struct {
+			ID    json.RawMessage `json:"id"`
+			Token string          `json:"token"`
+		}
+		if json.Unmarshal(line, &req) == nil && checkToken([]byte(req.Token), token) {
+			return true
+		}
+		log.Printf("listen: auth: invalid token from %s", c.RemoteAddr())
+		c.Write(encodeResponse(req.ID, nil, newProtoError(protoUnauthorized, "listen: auth: invalid token")))
+		return false
+	}
+
+	if word, rest := splitWord(string(line)); word == "auth" && checkToken([]byte(rest), token) {
+		return true
+	}
+	log.Printf("listen: auth: invalid token from %s", c.RemoteAddr())
+	fmt.Fprintln(c, "listen: auth: invalid token")
+	return false
+}
+
+// checkToken compares tokens in constant time to avoid leaking the token
+// through the time taken by the comparison.
+func checkToken(given, token []byte) bool {
+	return subtle.ConstantTimeCompare(given, token) == 1
+}
+
+// matchClients returns the ids of the connected clients matching the selector.
+func matchClients(connList map[int]net.Conn, infos map[int]*clientInfo, sel clientSelector) []int {
+	var ids []int
+	for _, id := range slices.Sorted(maps.Keys(connList)) {
+		if sel.matches(clientInfoOf(infos, id)) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// clientInfoOf returns the metadata of a client, which only has the id for
+// clients that have not sent any metadata.
+func clientInfoOf(infos map[int]*clientInfo, id int) clientInfo {
+	if info, ok := infos[id]; ok {
+		return *info
+	}
+	return clientInfo{ID: id}
 }
 
 func manage() {
 	connList := make(map[int]net.Conn)
+	// metadata is kept separately since `cd` events are sent over another
+	// connection and may be received before the client is registered
+	infos := make(map[int]*clientInfo)
+	var subs subList
 	for cmd := range gCmdChan {
+		// commands targeting clients with a selector are resolved to ids
+		var ids []int
+		if cmd.sel != nil {
+			ids = matchClients(connList, infos, cmd.sel)
+			switch {
+			case len(ids) == 0:
+				cmd.fail(protoNoSuchClient, fmt.Sprintf("listen: %s: no client matches %s", cmd.op, cmd.sel))
+				close(cmd.done)
+				continue
+			case len(ids) > 1 && cmd.op == "query":
+				cmd.fail(protoAmbiguousClient, fmt.Sprintf("listen: %s: multiple clients match %s", cmd.op, cmd.sel))
+				close(cmd.done)
+				continue
+			}
+			cmd.id = ids[0]
+		}
+
 		switch cmd.op {
 		case "conn":
 			// lifetime of the connection is managed by the server and
 			// will be cleaned up via the `drop` command
 			connList[cmd.id] = cmd.c
+			info := clientInfo{ID: cmd.id}
+			if cmd.info != nil {
+				info = *cmd.info
+			}
+			if old, ok := infos[cmd.id]; ok && old.Cwd != "" {
+				info.Cwd = old.Cwd
+			}
+			infos[cmd.id] = &info
+			delete(subs.announced, cmd.id)
+			subs.announce(connList)
 		case "drop":
 			if c2, ok := connList[cmd.id]; ok {
 				c2.Close()
 				delete(connList, cmd.id)
+				delete(infos, cmd.id)
+				subs.drop(cmd.id)
+			} else if cmd.resp != nil {
+				cmd.fail(protoNoSuchClient, "listen: drop: no such client id is connected")
 			}
 		case "list":
-			for _, id := range slices.Sorted(maps.Keys(connList)) {
-				fmt.Fprintln(cmd.c, id)
+			ids := slices.Sorted(maps.Keys(connList))
+			var list []clientInfo
+			for _, id := range ids {
+				list = append(list, clientInfoOf(infos, id))
+			}
+			if cmd.resp != nil {
+				cmd.resp.Result = clientList{append([]int{}, ids...), append([]clientInfo{}, list...)}
+				break
+			}
+			switch cmd.msg {
+			case "":
+				for _, id := range ids {
+					fmt.Fprintln(cmd.c, id)
+				}
+			case "table":
+				fmt.Fprint(cmd.c, listClients(list))
+			default:
+				echoerrf(cmd.c, "listen: list: unknown format: %s", cmd.msg)
 			}
 		case "broadcast":
 			for id, c2 := range connList {
 				if _, err := fmt.Fprintln(c2, cmd.msg); err != nil {
-					echoerrf(cmd.c, "failed to send command to client %v: %s", id, err)
+					cmd.fail(protoSendFailed, fmt.Sprintf("failed to send command to client %v: %s", id, err))
 				}
 			}
 		case "send":
-			if c2, ok := connList[cmd.id]; ok {
+			if cmd.sel != nil {
+				for _, id := range ids {
+					if _, err := fmt.Fprintln(connList[id], cmd.msg); err != nil {
+						cmd.fail(protoSendFailed, fmt.Sprintf("failed to send command to client %v: %s", id, err))
+					}
+				}
+			} else if c2, ok := connList[cmd.id]; ok {
 				if _, err := fmt.Fprintln(c2, cmd.msg); err != nil {
-					echoerrf(cmd.c, "failed to send command to client %v: %s", cmd.id, err)
+					cmd.fail(protoSendFailed, fmt.Sprintf("failed to send command to client %v: %s", cmd.id, err))
 				}
 			} else {
-				echoerr(cmd.c, "listen: send: no such client id is connected")
+				cmd.fail(protoNoSuchClient, "listen: send: no such client id is connected")
 			}
 		case "query":
 			c2, ok := connList[cmd.id]
 			if !ok {
-				echoerr(cmd.c, "listen: query: no such client id is connected")
+				cmd.fail(protoNoSuchClient, "listen: query: no such client id is connected")
+				break
+			}
+			if cmd.resp != nil {
+				queryJSON(cmd, c2)
 				break
 			}
 			if _, err := fmt.Fprintln(c2, "query "+cmd.msg); err != nil {
@@ -103,15 +303,55 @@ func manage() {
 			if s2.Err() != nil {
 				echoerrf(cmd.c, "failed to read query response from client %v: %s", cmd.id, s2.Err())
 			}
+		case "subscribe":
+			// lifetime of the connection is managed by the server and
+			// will be cleaned up when the subscriber cannot be written
+			if _, ok := connList[cmd.id]; !ok && cmd.id != allClients {
+				cmd.fail(protoNoSuchClient, "listen: subscribe: no such client id is connected")
+				if cmd.resp != nil {
+					cmd.c.Write(encodeResponse(cmd.resp.ID, nil, cmd.resp.Error))
+				}
+				cmd.c.Close()
+				break
+			}
+			if cmd.resp != nil {
+				if _, err := cmd.c.Write(encodeResponse(cmd.resp.ID, nil, nil)); err != nil {
+					log.Printf("listen: subscribe: %s", err)
+					cmd.c.Close()
+					break
+				}
+			}
+			events := strings.Fields(cmd.msg)
+			if len(events) == 0 {
+				events = eventNames
+			}
+			subs.add(&subscriber{cmd.c, cmd.id, events})
+			subs.announce(connList)
+		case "event":
+			name, payload := splitWord(cmd.msg)
+			if path, ok := cdPath(payload); name == "cd" && ok {
+				if infos[cmd.id] == nil {
+					infos[cmd.id] = &clientInfo{ID: cmd.id}
+				}
+				infos[cmd.id].Cwd = path
+			}
+			if subs.publish(cmd.id, name, payload) {
+				subs.announce(connList)
+			}
 		case "quit":
+			if len(connList) > 0 && cmd.resp != nil {
+				cmd.fail(protoClientsConnected, "listen: quit: clients are still connected")
+			}
 			if len(connList) == 0 {
-				gQuitChan <- struct{}{}
+				subs.closeAll()
+				close(gQuitChan)
 				gListener.Close()
 				close(cmd.done)
 				return
 			}
 		case "quit!":
-			gQuitChan <- struct{}{}
+			subs.closeAll()
+			close(gQuitChan)
 			for _, c := range connList {
 				fmt.Fprintln(c, "echo server is quitting...")
 				c.Close()
@@ -126,7 +366,9 @@ func manage() {
 	}
 }
 
-func listen(l net.Listener) {
+// listen accepts connections on the given listener, where connections should
+// authenticate with the token first unless it is nil.
+func listen(l net.Listener, token []byte) {
 	for {
 		c, err := l.Accept()
 		if err != nil {
@@ -136,9 +378,10 @@ func listen(l net.Listener) {
 				return
 			default:
 				log.Printf("accepting connection: %s", err)
+				continue
 			}
 		}
-		go handleConn(c)
+		go handleConn(c, token)
 	}
 }
 
@@ -151,31 +394,100 @@ func echoerrf(c net.Conn, format string, a ...any) {
 	echoerr(c, fmt.Sprintf(format, a...))
 }
 
+// queryJSON forwards a query of the JSON protocol to the client and saves the
+// response of the client, which is a single line.
+func queryJSON(cmd srvCmd, c2 net.Conn) {
+	if _, err := fmt.Fprintln(c2, "query-json "+cmd.msg); err != nil {
+		cmd.fail(protoQueryFailed, fmt.Sprintf("failed to send query to client %v: %s", cmd.id, err))
+		return
+	}
+
+	line, err := bufio.NewReader(c2).ReadBytes('\n')
+	if err != nil {
+		cmd.fail(protoQueryFailed, fmt.Sprintf("failed to read query response from client %v: %s", cmd.id, err))
+		return
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *protoError     `json:"error"`
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		cmd.fail(protoQueryFailed, fmt.Sprintf("failed to decode query response from client %v: %s", cmd.id, err))
+		return
+	}
+
+	if resp.Error != nil {
+		cmd.resp.Error = resp.Error
+		return
+	}
+	cmd.resp.Result = resp.Result
+}
+
 func send(cmd srvCmd) {
 	cmd.done = make(chan struct{})
 	gCmdChan <- cmd
 	<-cmd.done
 }
 
-func handleConn(c net.Conn) {
+func handleConn(c net.Conn, token []byte) {
 	s := bufio.NewScanner(c)
+	authenticated := token == nil
+	if !authenticated {
+		c.SetReadDeadline(time.Now().Add(authTimeout))
+	}
 
 Loop:
 	for s.Scan() {
-		log.Printf("listen: %s", s.Text())
+		// lines are not logged before authentication to keep the token secret
+		if !authenticated {
+			if !authenticate(c, s.Bytes(), token) {
+				break
+			}
+			authenticated = true
+			c.SetReadDeadline(time.Time{})
+			log.Printf("listen: authenticated connection from %s", c.RemoteAddr())
+			if !strings.HasPrefix(s.Text(), "{") {
+				continue
+			}
+		} else {
+			log.Printf("listen: %s", s.Text())
+		}
+
+		if strings.HasPrefix(s.Text(), "{") {
+			keep, managed := handleJSON(c, s.Bytes())
+			if managed {
+				return
+			}
+			if !keep {
+				break
+			}
+			continue
+		}
+
 		word, rest := splitWord(s.Text())
 
 		switch word {
 		case "conn":
 			if rest != "" {
-				word2, _ := splitWord(rest)
+				word2, rest2 := splitWord(rest)
 				id, err := strconv.Atoi(word2)
 				if err != nil {
 					echoerr(c, "listen: conn: client id should be a number")
-				} else {
-					send(srvCmd{op: "conn", id: id, c: c})
-					return
+					break
+				}
+				// metadata is optional to support clients without it
+				var info *clientInfo
+				if rest2 != "" {
+					info = &clientInfo{}
+					if err := json.Unmarshal([]byte(rest2), info); err != nil {
+						echoerrf(c, "listen: conn: invalid client info: %s", err)
+						break
+					}
+					info.ID = id
 				}
+				send(srvCmd{op: "conn", id: id, c: c, info: info})
+				return
 			} else {
 				echoerr(c, "listen: conn: requires a client id")
 			}
@@ -192,15 +504,16 @@ Loop:
 				echoerr(c, "listen: drop: requires a client id")
 			}
 		case "list":
-			send(srvCmd{op: "list", c: c})
+			send(srvCmd{op: "list", msg: rest, c: c})
 		case "send":
 			if rest != "" {
 				word2, rest2 := splitWord(rest)
-				id, err := strconv.Atoi(word2)
-				if err != nil {
-					send(srvCmd{op: "broadcast", msg: rest, c: c})
-				} else {
+				if id, err := strconv.Atoi(word2); err == nil {
 					send(srvCmd{op: "send", id: id, msg: rest2, c: c})
+				} else if sel, ok := parseSelector(word2); ok {
+					send(srvCmd{op: "send", sel: sel, msg: rest2, c: c})
+				} else {
+					send(srvCmd{op: "broadcast", msg: rest, c: c})
 				}
 			}
 		case "query":
@@ -209,12 +522,32 @@ Loop:
 				break
 			}
 			word2, rest2 := splitWord(rest)
+			if sel, ok := parseSelector(word2); ok {
+				send(srvCmd{op: "query", sel: sel, msg: rest2, c: c})
+				break
+			}
 			id, err := strconv.Atoi(word2)
 			if err != nil {
-				echoerr(c, "listen: query: client id should be a number")
+				echoerr(c, "listen: query: client id should be a number or a selector")
 				break
 			}
 			send(srvCmd{op: "query", id: id, msg: rest2, c: c})
+		case "subscribe":
+			id, events, err := parseSubscribe(rest)
+			if err != nil {
+				echoerrf(c, "listen: subscribe: %s", err)
+				break
+			}
+			send(srvCmd{op: "subscribe", id: id, msg: strings.Join(events, " "), c: c})
+			return
+		case "event":
+			word2, rest2 := splitWord(rest)
+			id, err := strconv.Atoi(word2)
+			if err != nil {
+				echoerr(c, "listen: event: client id should be a number")
+				break
+			}
+			send(srvCmd{op: "event", id: id, msg: rest2})
 		case "quit":
 			send(srvCmd{op: "quit"})
 			break Loop
@@ -232,3 +565,51 @@ Loop:
 
 	c.Close()
 }
+
+// handleJSON handles a request of the JSON protocol and reports whether the
+// connection should be kept open and whether it is now managed by the server.
+func handleJSON(c net.Conn, line []byte) (keep, managed bool) {
+	req, perr := decodeRequest(line)
+	if perr != nil {
+		log.Printf("listen: %s", perr)
+		c.Write(encodeResponse(req.ID, nil, perr))
+		return true, false
+	}
+
+	resp := &protoResponse{ID: req.ID}
+	cmd := srvCmd{op: req.Op, msg: req.Command, c: c, resp: resp, sel: req.Select}
+	if req.Client != nil {
+		cmd.id = *req.Client
+	}
+
+	switch req.Op {
+	case "send":
+		if req.Client == nil && req.Select == nil {
+			cmd.op = "broadcast"
+		}
+	case "query":
+		cmd.msg = req.Type
+	case "subscribe":
+		if req.Client == nil {
+			cmd.id = allClients
+		}
+		cmd.msg = strings.Join(req.Events, " ")
+	case "quit":
+		if req.Force {
+			cmd.op = "quit!"
+		}
+	}
+
+	send(cmd)
+
+	// responses to `subscribe` are written by the server before events
+	if req.Op == "subscribe" {
+		return false, true
+	}
+
+	if _, err := c.Write(encodeResponse(req.ID, resp.Result, resp.Error)); err != nil {
+		log.Printf("listen: sending response: %s", err)
+		return false, false
+	}
+	return req.Op != "quit" || resp.Error != nil, false
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Remote directories are browsed over SFTP with paths such as
+// `sftp://user@host:port/path`, where the path is absolute on the host. Hosts
+// are authenticated with `~/.ssh/known_hosts`, and users with the keys of a
+// running ssh-agent or unencrypted keys in `~/.ssh`. One connection is kept
+// open for each host until it is closed by the remote side. Failed connections
+// are not retried until their backoff expires, so that an unreachable host does
+// not delay every access to its paths.
+var gSFTP = struct {
+	sync.Mutex
+	conns    map[string]*sftpFS
+	dials    map[string]chan struct{} // closed when the dial to the host is done
+	failures map[string]*sftpFailure
+}{
+	conns:    make(map[string]*sftpFS),
+	dials:    make(map[string]chan struct{}),
+	failures: make(map[string]*sftpFailure),
+}
+
+// sftpStatTTL is the duration for which file information read while listing
+// a remote directory is reused, to avoid a round trip for each listed file.
+const sftpStatTTL = 2 * time.Second
+
+// sftpMinBackoff and sftpMaxBackoff bound the time to wait before connecting
+// again to a host after a failed connection, which doubles after each failure.
+const (
+	sftpMinBackoff = 5 * time.Second
+	sftpMaxBackoff = 5 * time.Minute
+)
+
+// sftpFailure is the last failed connection to a host.
+type sftpFailure struct {
+	err     error
+	backoff time.Duration
+	retry   time.Time
+}
+
+type sftpFS struct {
+	host   string
+	client *sftp.Client
+
+	mu        sync.Mutex
+	stats     map[string]os.FileInfo // file information of the last listing
+	statsTime time.Time
+}
+
+// connectSFTP returns the connection to the given host, opening a new one if
+// there is none yet. Concurrent calls for the same host wait for a single
+// connection, and the error of the last failed connection is returned until
+// its backoff expires.
+func connectSFTP(host string) (*sftpFS, error) {
+	gSFTP.Lock()
+	for {
+		if fsys, ok := gSFTP.conns[host]; ok {
+			gSFTP.Unlock()
+			return fsys, nil
+		}
+		if f, ok := gSFTP.failures[host]; ok && time.Now().Before(f.retry) {
+			gSFTP.Unlock()
+			return nil, f.err
+		}
+		done, ok := gSFTP.dials[host]
+		if !ok {
+			break
+		}
+		gSFTP.Unlock()
+		<-done
+		gSFTP.Lock()
+	}
+	done := make(chan struct{})
+	gSFTP.dials[host] = done
+	gSFTP.Unlock()
+
+	fsys, err := dialSFTP(host, done)
+
+	gSFTP.Lock()
+	defer gSFTP.Unlock()
+
+	delete(gSFTP.dials, host)
+	close(done)
+
+	if err != nil {
+		f := &sftpFailure{err: err, backoff: sftpMinBackoff}
+		if prev, ok := gSFTP.failures[host]; ok {
+			f.backoff = min(2*prev.backoff, sftpMaxBackoff)
+		}
+		f.retry = time.Now().Add(f.backoff)
+		gSFTP.failures[host] = f
+		log.Printf("sftp: connecting to %s failed, retrying after %s: %s", host, f.backoff, err)
+		return nil, err
+	}
+
+	delete(gSFTP.failures, host)
+	gSFTP.conns[host] = fsys
+	return fsys, nil
+}
+
+// dialSFTP opens a new connection to the given host, which is removed from
+// the open connections when it is closed after it is registered, as signaled
+// by closing the given channel.
+func dialSFTP(host string, registered <-chan struct{}) (*sftpFS, error) {
+	user, addr := sftpAddr(host)
+	config, closeAgent, err := sftpClientConfig(user)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+
+	conn, err := ssh.Dial("tcp", addr, config)
+	closeAgent()
+	if err != nil {
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+
+	fsys := &sftpFS{host: host, client: client}
+
+	go func() {
+		if err := client.Wait(); err != nil {
+			log.Printf("sftp: connection to %s closed: %s", host, err)
+		}
+		conn.Close()
+
+		<-registered
+		gSFTP.Lock()
+		if gSFTP.conns[host] == fsys {
+			delete(gSFTP.conns, host)
+		}
+		gSFTP.Unlock()
+	}()
+
+	return fsys, nil
+}
+
+// sftpAddr returns the user name and the network address of the given host,
+// which defaults to the current user and the standard ssh port.
+func sftpAddr(host string) (user, addr string) {
+	user = gUser.Username
+	if u, h, ok := strings.Cut(host, "@"); ok {
+		user, host = u, h
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	return user, host
+}
+
+// sftpClientConfig returns the configuration for connecting as the given user,
+// and a function to close the connection to the ssh-agent after connecting.
+func sftpClientConfig(user string) (*ssh.ClientConfig, func(), error) {
+	sshDir := filepath.Join(gUser.HomeDir, ".ssh")
+
+	hostKeys, err := knownhosts.New(filepath.Join(sshDir, "known_hosts"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading known hosts: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	closeAgent := func() {}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		} else {
+			log.Printf("sftp: connecting to agent: %s", err)
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(filepath.Join(sshDir, name))
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			// keys protected with a passphrase need to be added to the agent
+			log.Printf("sftp: reading key %s: %s", name, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) != 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         10 * time.Second,
+	}, closeAgent, nil
+}
+
+// remotePath returns the path on the host for the given path.
+func (fsys *sftpFS) remotePath(name string) string {
+	_, rpath, _ := splitRemote(name)
+	return path.Clean(rpath)
+}
+
+// changed discards the file information of the last listing after a
+// modification.
+func (fsys *sftpFS) changed() {
+	fsys.mu.Lock()
+	fsys.stats = nil
+	fsys.mu.Unlock()
+}
+
+func (fsys *sftpFS) Lstat(name string) (os.FileInfo, error) {
+	fsys.mu.Lock()
+	info, ok := fsys.stats[fsys.remotePath(name)]
+	if ok && time.Since(fsys.statsTime) > sftpStatTTL {
+		ok = false
+	}
+	fsys.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err := fsys.client.Lstat(fsys.remotePath(name))
+	return info, remoteError("lstat", name, err)
+}
+
+func (fsys *sftpFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fsys.client.Stat(fsys.remotePath(name))
+	return info, remoteError("stat", name, err)
+}
+
+func (fsys *sftpFS) Readdirnames(name string, n int) ([]string, error) {
+	rpath := fsys.remotePath(name)
+	infos, err := fsys.client.ReadDir(rpath)
+	if err != nil {
+		return nil, remoteError("readdir", name, err)
+	}
+
+	if n < 0 {
+		stats := make(map[string]os.FileInfo, len(infos))
+		for _, info := range infos {
+			stats[path.Join(rpath, info.Name())] = info
+		}
+		fsys.mu.Lock()
+		fsys.stats = stats
+		fsys.statsTime = time.Now()
+		fsys.mu.Unlock()
+	} else if len(infos) > n {
+		infos = infos[:n]
+	}
+
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, nil
+}
+
+func (fsys *sftpFS) Readlink(name string) (string, error) {
+	target, err := fsys.client.ReadLink(fsys.remotePath(name))
+	return target, remoteError("readlink", name, err)
+}
+
+func (fsys *sftpFS) Symlink(oldname, newname string) error {
+	defer fsys.changed()
+	return remoteError("symlink", newname, fsys.client.Symlink(oldname, fsys.remotePath(newname)))
+}
+
+func (fsys *sftpFS) Open(name string) (io.ReadCloser, error) {
+	f, err := fsys.client.Open(fsys.remotePath(name))
+	if err != nil {
+		return nil, remoteError("open", name, err)
+	}
+	return f, nil
+}
+
+func (fsys *sftpFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	defer fsys.changed()
+	rpath := fsys.remotePath(name)
+	f, err := fsys.client.OpenFile(rpath, os.O_RDWR|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, remoteError("open", name, err)
+	}
+
+	// The server applies its own umask to new files, so only modes differing
+	// from the default are set explicitly.
+	if perm != 0o666 {
+		if err := f.Chmod(perm); err != nil {
+			f.Close()
+			fsys.client.Remove(rpath)
+			return nil, remoteError("chmod", name, err)
+		}
+	}
+	return f, nil
+}
+
+func (fsys *sftpFS) MkdirAll(name string, perm os.FileMode) error {
+	defer fsys.changed()
+	rpath := fsys.remotePath(name)
+	if err := fsys.client.MkdirAll(rpath); err != nil {
+		return remoteError("mkdir", name, err)
+	}
+	if perm != os.ModePerm {
+		return remoteError("chmod", name, fsys.client.Chmod(rpath, perm))
+	}
+	return nil
+}
+
+// Rename replaces an existing newname like [os.Rename], which requires the
+// posix-rename extension supported by OpenSSH.
+func (fsys *sftpFS) Rename(oldname, newname string) error {
+	defer fsys.changed()
+	oldpath, newpath := fsys.remotePath(oldname), fsys.remotePath(newname)
+	var err error
+	if _, ok := fsys.client.HasExtension("posix-rename@openssh.com"); ok {
+		err = fsys.client.PosixRename(oldpath, newpath)
+	} else {
+		err = fsys.client.Rename(oldpath, newpath)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (fsys *sftpFS) Remove(name string) error {
+	defer fsys.changed()
+	return remoteError("remove", name, fsys.client.Remove(fsys.remotePath(name)))
+}
+
+// RemoveAll removes name and its contents like [os.RemoveAll] without
+// following symbolic links.
+func (fsys *sftpFS) RemoveAll(name string) error {
+	defer fsys.changed()
+	return remoteError("removeall", name, fsys.removeAll(fsys.remotePath(name)))
+}
+
+func (fsys *sftpFS) removeAll(rpath string) error {
+	info, err := fsys.client.Lstat(rpath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fsys.client.Remove(rpath)
+	}
+
+	infos, err := fsys.client.ReadDir(rpath)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := fsys.removeAll(path.Join(rpath, info.Name())); err != nil {
+			return err
+		}
+	}
+	return fsys.client.RemoveDirectory(rpath)
+}
+
+func (fsys *sftpFS) Chtimes(name string, atime, mtime time.Time) error {
+	defer fsys.changed()
+	return remoteError("chtimes", name, fsys.client.Chtimes(fsys.remotePath(name), atime, mtime))
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSplitRemote(t *testing.T) {
+	tests := []struct {
+		path  string
+		host  string
+		rpath string
+		ok    bool
+	}{
+		{"sftp://host/a/b", "host", "/a/b", true},
+		{"sftp:/host/a/b", "host", "/a/b", true},
+		{"sftp://user@host:2222", "user@host:2222", "/", true},
+		{"sftp://", "", "", false},
+		{"/sftp:/host", "", "", false},
+		{"/a/b", "", "", false},
+	}
+
+	for _, test := range tests {
+		host, rpath, ok := splitRemote(test.path)
+		if host != test.host || rpath != test.rpath || ok != test.ok {
+			t.Errorf("at input '%s' expected ('%s', '%s', %t) but got ('%s', '%s', %t)",
+				test.path, test.host, test.rpath, test.ok, host, rpath, ok)
+		}
+	}
+}
+
+// startSFTPServer starts an ssh server serving the local file system over
+// sftp, and sets up the home directory of the current user with a client key
+// and the host key of the server. It returns the address of the server.
+func startSFTPServer(t *testing.T) string {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshClientPub, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), sshClientPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	addr := l.Addr().String()
+
+	home := t.TempDir()
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.Mkdir(sshDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(filepath.Join(sshDir, "known_hosts"), []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	oldUser := gUser
+	gUser = &user.User{Username: "lf", HomeDir: home}
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Cleanup(func() {
+		gUser = oldUser
+		gSFTP.Lock()
+		if fsys, ok := gSFTP.conns[addr]; ok {
+			fsys.client.Close()
+			delete(gSFTP.conns, addr)
+		}
+		gSFTP.Unlock()
+	})
+
+	return addr
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if server, err := sftp.NewServer(ch); err == nil {
+						server.Serve()
+					}
+					ch.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	addr := startSFTPServer(t)
+
+	local := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(local, "src", "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "src", "d", "f.txt"), []byte("f\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("d", filepath.Join(local, "src", "l")); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteDir := t.TempDir()
+	remote := filepath.Clean("sftp://" + addr + filepath.ToSlash(remoteDir))
+
+	if err := chdir(remote); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, errs := copyAll([]string{filepath.Join(local, "src")}, remote, nil, nil, nil)
+	for err := range errs {
+		t.Errorf("copying to remote: %s", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(remoteDir, "src", "d", "f.txt"))
+	if err != nil || string(b) != "f\n" {
+		t.Errorf("expected copied file content 'f\\n' but got '%q' (%v)", b, err)
+	}
+
+	files, err := readdir(filepath.Join(remote, "src"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+		if f.Name() == "l" && (f.linkState != working || f.linkTarget != "d" || !f.IsDir()) {
+			t.Errorf("expected working link to 'd' but got (%v, '%s')", f.linkState, f.linkTarget)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"d", "l"}) {
+		t.Errorf("expected '[d l]' but got '%v'", names)
+	}
+
+	if f := newFile(filepath.Join(remote, "src", "d", "f.txt")); f.err != nil || f.Size() != 2 {
+		t.Errorf("expected file of size 2 but got %d (%v)", f.Size(), f.err)
+	}
+	if f := newFile(filepath.Join(remote, "missing")); !os.IsNotExist(f.err) {
+		t.Errorf("expected not exist error but got '%v'", f.err)
+	}
+
+	flat, err := readdirFlat(filepath.Join(remote, "src"), -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(flat) != 3 {
+		t.Errorf("expected 3 files in flattened remote directory but got %d", len(flat))
+	}
+
+	found, err := readdirGrep(filepath.Join(remote, "src"), regexp.MustCompile("f"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(found) != 1 || found[0].path != filepath.Join(remote, "src", "d", "f.txt") {
+		t.Errorf("expected one match in remote directory but got %d", len(found))
+	}
+
+	back := filepath.Join(local, "back")
+	if err := os.Mkdir(back, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := movePath(filepath.Join(remote, "src"), filepath.Join(back, "src")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if target, err := os.Readlink(filepath.Join(back, "src", "l")); err != nil || target != "d" {
+		t.Errorf("expected link to 'd' but got '%s' (%v)", target, err)
+	}
+	if _, err := os.Lstat(filepath.Join(remoteDir, "src")); !os.IsNotExist(err) {
+		t.Errorf("expected moved remote directory to be removed but got '%v'", err)
+	}
+}
+
+func TestConnectSFTPBackoff(t *testing.T) {
+	// nothing listens on the port, so connecting fails immediately
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := l.Addr().String()
+	l.Close()
+	defer func() {
+		gSFTP.Lock()
+		delete(gSFTP.failures, host)
+		gSFTP.Unlock()
+	}()
+
+	if _, err := connectSFTP(host); err == nil {
+		t.Fatalf("expected connection to fail")
+	}
+	gSFTP.Lock()
+	f := gSFTP.failures[host]
+	gSFTP.Unlock()
+	if f == nil || f.backoff != sftpMinBackoff {
+		t.Fatalf("expected failure with backoff %s but got '%v'", sftpMinBackoff, f)
+	}
+
+	// failures are returned without connecting again until the backoff expires
+	if _, err := connectSFTP(host); err != f.err {
+		t.Errorf("expected cached error '%v' but got '%v'", f.err, err)
+	}
+	gSFTP.Lock()
+	cached := gSFTP.failures[host] == f
+	f.retry = time.Now()
+	gSFTP.Unlock()
+	if !cached {
+		t.Errorf("expected failure to be cached")
+	}
+
+	if _, err := connectSFTP(host); err == nil {
+		t.Fatalf("expected connection to fail")
+	}
+	gSFTP.Lock()
+	backoff := gSFTP.failures[host].backoff
+	gSFTP.Unlock()
+	if backoff != 2*sftpMinBackoff {
+		t.Errorf("expected backoff %s but got %s", 2*sftpMinBackoff, backoff)
+	}
+}
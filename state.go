@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The state of the client is saved for the `query` server command both as
+// text and as values for the JSON protocol. Queries are answered outside of
+// the main thread, which may be blocked running a synchronous shell command.
+// Therefore queries ask the main thread to save the current state first, and
+// fall back to the state saved when the last shell command is run if the main
+// thread does not respond in time.
+var gStateReq = make(chan chan struct{})
+
+// stateTimeout is the time to wait for the main thread to save the state.
+const stateTimeout = 250 * time.Millisecond
+
+var errNotReady = errors.New("not available yet")
+
+// queryTypes are the types of state which can be queried from clients, along
+// with `opt:<name>` for the value of a single option.
+var queryTypes = []string{
+	"maps", "nmaps", "vmaps", "cmaps", "cmds", "jumps", "history", "files",
+	"dir", "file", "selections", "tags", "marks", "filter", "opts", "clipboard", "jobs",
+}
+
+// refreshState asks the main thread to save the current state and waits until
+// it is saved, unless the main thread is busy.
+func refreshState() {
+	done := make(chan struct{})
+	select {
+	case gStateReq <- done:
+		<-done
+	case <-time.After(stateTimeout):
+	}
+}
+
+// lookupState returns the saved state of the given query type as text and as
+// a value for the JSON protocol.
+func lookupState(typ string) (string, any, error) {
+	gState.mutex.Lock()
+	defer gState.mutex.Unlock()
+
+	if name, ok := strings.CutPrefix(typ, "opt:"); ok {
+		opts, ok := gState.values["opts"].(map[string]string)
+		if !ok {
+			return "", nil, errNotReady
+		}
+		val, ok := opts[name]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown option: %s", name)
+		}
+		return val + "\n", val, nil
+	}
+
+	if !slices.Contains(queryTypes, typ) {
+		return "", nil, fmt.Errorf("unknown query type: %s", typ)
+	}
+
+	val, ok := gState.values[typ]
+	if files, isFiles := val.([]string); !ok || typ == "files" && isFiles && files == nil {
+		return gState.data[typ], nil, errNotReady
+	}
+	return gState.data[typ], val, nil
+}
+
+// saveState saves the state of the client for queries.
+func (app *app) saveState() {
+	binds := map[string]map[string]map[string]expr{
+		"maps":  {"n": gOpts.nkeys, "v": gOpts.vkeys},
+		"nmaps": {"n": gOpts.nkeys},
+		"vmaps": {"v": gOpts.vkeys},
+		"cmaps": {"c": gOpts.cmdkeys},
+	}
+
+	dir := app.nav.currDir()
+
+	var file string
+	if curr := app.nav.currFile(); curr != nil {
+		file = curr.path
+	}
+
+	selections := append([]string{}, app.nav.currSelections()...)
+	filter := append([]string{}, dir.filter...)
+	opts := currOpts(dir.path)
+	clip := clipboardEntries{"copy", append([]string{}, app.nav.clipboard.paths...)}
+	if app.nav.clipboard.mode == clipboardCut {
+		clip.Mode = "move"
+	}
+
+	gState.mutex.Lock()
+	defer gState.mutex.Unlock()
+
+	for name, keys := range binds {
+		gState.data[name] = listBinds(keys)
+		gState.values[name] = bindEntries(keys)
+	}
+	gState.data["cmds"] = listCmds(gOpts.cmds)
+	gState.values["cmds"] = cmdEntries(gOpts.cmds)
+	gState.data["jumps"] = listJumps(app.nav.jumpList, app.nav.jumpListInd)
+	gState.values["jumps"] = jumpEntries{append([]string{}, app.nav.jumpList...), app.nav.jumpListInd}
+	gState.data["history"] = listHistory(app.cmdHistory)
+	gState.values["history"] = append([]string{}, app.cmdHistory...)
+	gState.data["files"] = listFilesInCurrDir(app.nav)
+	gState.values["files"] = filesInCurrDir(app.nav)
+	gState.data["dir"] = dir.path + "\n"
+	gState.values["dir"] = dir.path
+	gState.data["file"] = listLines([]string{file})
+	gState.values["file"] = file
+	gState.data["selections"] = listLines(selections)
+	gState.values["selections"] = selections
+	gState.data["tags"] = listTags(app.nav.tags)
+	gState.values["tags"] = maps.Clone(app.nav.tags)
+	gState.data["marks"] = listMarks(app.nav.marks)
+	gState.values["marks"] = maps.Clone(app.nav.marks)
+	gState.data["filter"] = listLines(filter)
+	gState.values["filter"] = filter
+	gState.data["opts"] = listOpts(opts)
+	gState.values["opts"] = opts
+	gState.data["clipboard"] = clip.Mode + "\n" + listLines(clip.Paths)
+	gState.values["clipboard"] = clip
+	gState.data["jobs"] = app.nav.jobs.String()
+	gState.values["jobs"] = app.nav.jobs.entries()
+}
+
+// currOpts returns the values of all options as shown in `lf_<name>`
+// environment variables without the prefix, where local options are set to
+// their values for the given directory.
+func currOpts(path string) map[string]string {
+	opts := make(map[string]string)
+	for k, v := range getOptsMap() {
+		opts[strings.TrimPrefix(k, "lf_")] = v
+	}
+
+	opts["dircounts"] = fmt.Sprint(getDirCounts(path))
+	opts["dirfirst"] = fmt.Sprint(getDirFirst(path))
+	opts["dironly"] = fmt.Sprint(getDirOnly(path))
+	opts["hidden"] = fmt.Sprint(getHidden(path))
+	opts["info"] = strings.Join(getInfo(path), ":")
+	opts["reverse"] = fmt.Sprint(getReverse(path))
+	opts["sortby"] = string(getSortBy(path))
+	opts["sortignorecase"] = fmt.Sprint(getSortIgnoreCase(path))
+	opts["sortignoredia"] = fmt.Sprint(getSortIgnoreDia(path))
+
+	return opts
+}
+
+// clipboardEntries is the clipboard with the mode of the next paste, which is
+// either `copy` or `move`.
+type clipboardEntries struct {
+	Mode  string   `json:"mode"`
+	Paths []string `json:"paths"`
+}
+
+// listLines returns the given strings on separate lines, where empty strings
+// and strings containing newlines are skipped.
+func listLines(lines []string) string {
+	b := new(strings.Builder)
+	for _, line := range lines {
+		if line == "" || strings.ContainsAny(line, "\n\r") {
+			continue
+		}
+		fmt.Fprintln(b, line)
+	}
+	return b.String()
+}
+
+func listTags(tags map[string]string) string {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	t.Init(b, 0, gOpts.tabstop, 2, '\t', 0)
+	fmt.Fprintln(t, "tag\tpath")
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		fmt.Fprintf(t, "%s\t%s\n", sanitizeName(tags[k]), sanitizeName(k))
+	}
+	t.Flush()
+
+	return b.String()
+}
+
+func listOpts(opts map[string]string) string {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	t.Init(b, 0, gOpts.tabstop, 2, '\t', 0)
+	fmt.Fprintln(t, "option\tvalue")
+	for _, k := range slices.Sorted(maps.Keys(opts)) {
+		fmt.Fprintf(t, "%s\t%s\n", k, opts[k])
+	}
+	t.Flush()
+
+	return b.String()
+}
+
+// jobEntry is a running job as listed by the `jobs` command.
+type jobEntry struct {
+	ID          int      `json:"id"`
+	Kind        string   `json:"kind"`
+	Status      string   `json:"status"`
+	Files       []string `json:"files"`
+	Current     string   `json:"current"`
+	Destination string   `json:"destination"`
+}
+
+func (l *jobList) entries() []jobEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries := []jobEntry{}
+	for _, j := range l.jobs {
+		j.mutex.Lock()
+		entries = append(entries, jobEntry{
+			ID:          j.id,
+			Kind:        j.kind,
+			Status:      string(j.status),
+			Files:       append([]string{}, j.queue...),
+			Current:     j.queue[j.ind],
+			Destination: j.dstDir,
+		})
+		j.mutex.Unlock()
+	}
+	return entries
+}
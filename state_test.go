@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestCurrOpts(t *testing.T) {
+	hidden, info := gLocalOpts.hidden, gLocalOpts.info
+	gLocalOpts.hidden = map[string]bool{"/a": !gOpts.hidden}
+	gLocalOpts.info = map[string][]string{"/a": {"size", "time"}}
+	defer func() {
+		gLocalOpts.hidden, gLocalOpts.info = hidden, info
+	}()
+
+	tests := []struct {
+		path string
+		name string
+		exp  string
+	}{
+		{"/a", "hidden", "true"},
+		{"/b", "hidden", "false"},
+		{"/a", "info", "size:time"},
+		{"/b", "info", ""},
+		{"/a", "sortby", "natural"},
+		{"/a", "filesep", "\n"},
+	}
+
+	for _, test := range tests {
+		if got := currOpts(test.path)[test.name]; got != test.exp {
+			t.Errorf("at input '%s' with option '%s' expected '%q' but got '%q'", test.path, test.name, test.exp, got)
+		}
+	}
+}
+
+func TestLookupState(t *testing.T) {
+	gState.mutex.Lock()
+	gState.data["dir"] = "/a\n"
+	gState.values["dir"] = "/a"
+	gState.data["files"] = ""
+	gState.values["files"] = []string(nil)
+	gState.values["opts"] = map[string]string{"hidden": "true"}
+	gState.mutex.Unlock()
+
+	tests := []struct {
+		typ  string
+		text string
+		val  any
+		fail bool
+	}{
+		{"dir", "/a\n", "/a", false},
+		{"opt:hidden", "true\n", "true", false},
+		{"opt:foo", "", nil, true},
+		{"files", "", nil, true},
+		{"foo", "", nil, true},
+	}
+
+	for _, test := range tests {
+		text, val, err := lookupState(test.typ)
+		if (err != nil) != test.fail {
+			t.Errorf("at input '%s' expected failure '%t' but got error '%v'", test.typ, test.fail, err)
+			continue
+		}
+		if text != test.text || val != test.val {
+			t.Errorf("at input '%s' expected '%q %v' but got '%q %v'", test.typ, test.text, test.val, text, val)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+)
+
+// dirView is the view state of a directory which is kept separately for each
+// tab, since directories in the cache are shared between tabs.
+type dirView struct {
+	ind    int
+	pos    int
+	name   string
+	filter []string
+}
+
+// tab is an independent navigation context. The state of the active tab is
+// kept in the corresponding fields of `nav`, and it is only saved to the tab
+// when switching to another tab.
+type tab struct {
+	path         string
+	selections   map[string]int
+	selectionInd int
+	jumpList     []string
+	jumpListInd  int
+	views        map[string]dirView
+}
+
+func newTab(path string) *tab {
+	return &tab{
+		path:        path,
+		selections:  make(map[string]int),
+		jumpList:    []string{path},
+		jumpListInd: 0,
+		views:       make(map[string]dirView),
+	}
+}
+
+// saveState stores the navigation state of the active context into the given
+// tab.
+func (nav *nav) saveState(t *tab) {
+	t.path = nav.currDir().path
+	t.selections = nav.selections
+	t.selectionInd = nav.selectionInd
+	t.jumpList = nav.jumpList
+	t.jumpListInd = nav.jumpListInd
+
+	t.views = make(map[string]dirView, len(nav.dirCache))
+	for path, d := range nav.dirCache {
+		var name string
+		if len(d.files) != 0 {
+			name = d.files[d.ind].Name()
+		}
+		t.views[path] = dirView{d.ind, d.pos, name, d.filter}
+	}
+}
+
+// loadState restores the navigation state of the given tab. Cached directories
+// not visited in the tab are reset so that each tab starts with its own cursor
+// positions and filters.
+func (nav *nav) loadState(t *tab) error {
+	if err := chdir(t.path); err != nil {
+		return err
+	}
+
+	nav.selections = t.selections
+	nav.selectionInd = t.selectionInd
+	nav.jumpList = t.jumpList
+	nav.jumpListInd = t.jumpListInd
+
+	for path, d := range nav.dirCache {
+		v := t.views[path]
+		if !slices.Equal(d.filter, v.filter) {
+			d.filter = v.filter
+			d.sort()
+		}
+		d.ind, d.pos = v.ind, v.pos
+		d.sel(v.name, nav.height)
+	}
+
+	nav.loadDirs(t.path)
+	nav.renew()
+	return nil
+}
+
+// switchTab saves the active tab and loads the tab at the given index. The
+// active tab is not changed if the tab cannot be loaded.
+func (nav *nav) switchTab(ind int) error {
+	if ind < 0 || ind >= len(nav.tabs) {
+		return fmt.Errorf("no such tab: %d", ind+1)
+	}
+	if ind == nav.tabInd {
+		return nil
+	}
+
+	nav.saveState(nav.tabs[nav.tabInd])
+	if err := nav.loadState(nav.tabs[ind]); err != nil {
+		return err
+	}
+	nav.tabInd = ind
+	return nil
+}
+
+// newTab opens a new tab at the given path after the active tab.
+func (nav *nav) newTab(path string) error {
+	if s, err := getFS(path).Stat(path); err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("not a directory: %s", path)
+	}
+
+	nav.saveState(nav.tabs[nav.tabInd])
+	t := newTab(path)
+	if err := nav.loadState(t); err != nil {
+		return err
+	}
+	nav.tabInd++
+	nav.tabs = slices.Insert(nav.tabs, nav.tabInd, t)
+	return nil
+}
+
+// closeTab closes the active tab and switches to the previous tab.
+func (nav *nav) closeTab() error {
+	if len(nav.tabs) == 1 {
+		return errors.New("cannot close the last tab")
+	}
+
+	// the closed tab is kept if the previous tab cannot be loaded
+	prev := nav.tabInd - 1
+	if prev < 0 {
+		prev = 1
+	}
+	if err := nav.loadState(nav.tabs[prev]); err != nil {
+		return err
+	}
+
+	nav.tabs = slices.Delete(nav.tabs, nav.tabInd, nav.tabInd+1)
+	nav.tabInd = max(nav.tabInd-1, 0)
+	return nil
+}
+
+// tabPaths returns the working directories of all tabs.
+func (nav *nav) tabPaths() []string {
+	paths := make([]string, len(nav.tabs))
+	for i, t := range nav.tabs {
+		paths[i] = t.path
+	}
+	if len(nav.dirPaths) != 0 {
+		paths[nav.tabInd] = nav.currDir().path
+	}
+	return paths
+}
+
+func tabName(path string) string {
+	if path == gUser.HomeDir {
+		return "~"
+	}
+	if name := filepath.Base(path); !isRoot(name) {
+		return name
+	}
+	return path
+}
+
+// changeContext runs the given tab or pane operation and updates the ui for
+// the new working directory, showing the tab bar only when there are multiple
+// tabs.
+func (app *app) changeContext(name string, change func() error) {
+	bar := len(app.nav.tabs) > 1
+
+	resetIncCmd(app)
+	preChdir(app)
+
+	if err := change(); err != nil {
+		app.ui.echoerrf("%s: %s", name, err)
+		return
+	}
+
+	if bar != (len(app.nav.tabs) > 1) {
+		app.ui.tabbar = len(app.nav.tabs) > 1
+		app.ui.renew()
+		app.nav.resize(app.ui)
+	}
+
+	app.ui.loadFile(app, true)
+	restartIncCmd(app)
+	onChdir(app)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTabLoadError(t *testing.T) {
+	wd := t.TempDir()
+	missing := filepath.Join(wd, "missing")
+
+	nav := &nav{
+		dirPaths: []string{wd},
+		dirCache: map[string]*dir{wd: {path: wd}},
+		tabs:     []*tab{newTab(wd), newTab(missing)},
+	}
+
+	if err := nav.switchTab(1); err == nil {
+		t.Errorf("switching to a tab in a missing directory should fail")
+	}
+	if nav.tabInd != 0 {
+		t.Errorf("expected active tab '0' but got '%d'", nav.tabInd)
+	}
+
+	nav.tabs[0], nav.tabs[1] = nav.tabs[1], nav.tabs[0]
+	nav.tabInd = 1
+	if err := nav.closeTab(); err == nil {
+		t.Errorf("closing a tab next to a missing directory should fail")
+	}
+	if len(nav.tabs) != 2 || nav.tabInd != 1 {
+		t.Errorf("expected 2 tabs with active tab '1' but got %d tabs with active tab '%d'", len(nav.tabs), nav.tabInd)
+	}
+}
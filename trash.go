@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The trash follows the FreeDesktop.org Trash specification, which can be
+// found at https://specifications.freedesktop.org/trash-spec/latest/
+
+const trashInfoTimeFmt = "2006-01-02T15:04:05"
+
+type trashEntry struct {
+	path    string // original location of the file
+	trashed string // location of the file inside the trash directory
+	date    time.Time
+}
+
+func homeTrashDir() string {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		data = filepath.Join(gUser.HomeDir, ".local", "share")
+	}
+	return filepath.Join(data, "Trash")
+}
+
+// mountTopDir returns the topmost ancestor of path that is on the same device.
+func mountTopDir(path string, dev uint64) string {
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		if id, err := deviceID(parent); err != nil || id != dev {
+			return path
+		}
+		path = parent
+	}
+}
+
+// trashTopDir returns the top directory of a trash directory located on a
+// mount point other than the home trash, or an empty string for the home trash.
+func trashTopDir(trashDir string) string {
+	uid := strconv.Itoa(os.Getuid())
+	switch {
+	case filepath.Base(trashDir) == ".Trash-"+uid:
+		return filepath.Dir(trashDir)
+	case filepath.Base(trashDir) == uid && filepath.Base(filepath.Dir(trashDir)) == ".Trash":
+		return filepath.Dir(filepath.Dir(trashDir))
+	}
+	return ""
+}
+
+// topTrashDirs returns the candidate trash directories in a top directory.
+// The shared `.Trash` directory is only used if it has the sticky bit set and
+// is not a symbolic link as required by the specification.
+func topTrashDirs(topdir string) (shared, private string) {
+	uid := strconv.Itoa(os.Getuid())
+	if lstat, err := os.Lstat(filepath.Join(topdir, ".Trash")); err == nil {
+		mode := lstat.Mode()
+		if mode.IsDir() && mode&os.ModeSticky != 0 {
+			shared = filepath.Join(topdir, ".Trash", uid)
+		}
+	}
+	private = filepath.Join(topdir, ".Trash-"+uid)
+	return shared, private
+}
+
+// trashDirFor returns the trash directory to be used for the given path.
+func trashDirFor(path string) (string, error) {
+	dev, err := deviceID(path)
+	if err != nil {
+		return "", fmt.Errorf("trash: %w", err)
+	}
+
+	home := homeTrashDir()
+	if err := os.MkdirAll(home, 0o700); err != nil {
+		return "", fmt.Errorf("creating trash directory: %w", err)
+	}
+
+	if homeDev, err := deviceID(home); err == nil && homeDev == dev {
+		return home, nil
+	}
+
+	shared, private := topTrashDirs(mountTopDir(path, dev))
+	if shared != "" {
+		if err := os.MkdirAll(shared, 0o700); err == nil {
+			return shared, nil
+		}
+	}
+
+	if err := os.MkdirAll(private, 0o700); err != nil {
+		return "", fmt.Errorf("creating trash directory: %w", err)
+	}
+
+	return private, nil
+}
+
+func trashInfoPath(trashed string) string {
+	trashDir := filepath.Dir(filepath.Dir(trashed))
+	return filepath.Join(trashDir, "info", filepath.Base(trashed)+".trashinfo")
+}
+
+func formatTrashInfo(path string, date time.Time) string {
+	u := url.URL{Path: path}
+	return fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", u.EscapedPath(), date.Format(trashInfoTimeFmt))
+}
+
+func parseTrashInfo(s string) (path string, date time.Time, err error) {
+	header := false
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			header = line == "[Trash Info]"
+			continue
+		}
+		if !header {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			if path, err = url.PathUnescape(val); err != nil {
+				return "", time.Time{}, fmt.Errorf("invalid trash info path: %w", err)
+			}
+		case "DeletionDate":
+			if date, err = time.ParseInLocation(trashInfoTimeFmt, val, time.Local); err != nil {
+				return "", time.Time{}, fmt.Errorf("invalid trash info date: %w", err)
+			}
+		}
+	}
+
+	if path == "" {
+		return "", time.Time{}, errors.New("missing trash info path")
+	}
+
+	return path, date, nil
+}
+
+// writeTrashInfo creates the info file for a trashed file. The info file is
+// created exclusively so that it also reserves the name in the trash.
+func writeTrashInfo(trashed, path string) error {
+	if topdir := trashTopDir(filepath.Dir(filepath.Dir(trashed))); topdir != "" {
+		if rel, err := filepath.Rel(topdir, path); err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+	}
+
+	f, err := os.OpenFile(trashInfoPath(trashed), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(formatTrashInfo(path, time.Now())); err != nil {
+		f.Close()
+		os.Remove(trashInfoPath(trashed))
+		return fmt.Errorf("writing trash info: %w", err)
+	}
+
+	return f.Close()
+}
+
+// trashFile moves the given path into the trash and returns its new location.
+func trashFile(path string) (string, error) {
+	trashDir, err := trashDirFor(path)
+	if err != nil {
+		return "", err
+	}
+
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", fmt.Errorf("creating trash directory: %w", err)
+		}
+	}
+
+	file := filepath.Base(path)
+	ext := filepath.Ext(file)
+	basename := file[:len(file)-len(ext)]
+	for i := 1; ; i++ {
+		trashed := filepath.Join(filesDir, file)
+
+		file = strings.ReplaceAll(gOpts.dupfilefmt, "%f", basename+ext)
+		file = strings.ReplaceAll(file, "%b", basename)
+		file = strings.ReplaceAll(file, "%e", ext)
+		file = strings.ReplaceAll(file, "%n", strconv.Itoa(i))
+
+		if _, err := os.Lstat(trashed); err == nil {
+			continue
+		}
+
+		if err := writeTrashInfo(trashed, path); os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("creating trash info: %w", err)
+		}
+
+		if err := os.Rename(path, trashed); err != nil {
+			os.Remove(trashInfoPath(trashed))
+			return "", err
+		}
+
+		return trashed, nil
+	}
+}
+
+// untrashFile moves a trashed file back to its original location.
+func untrashFile(trashed, path string) error {
+	if err := movePath(trashed, path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(trashInfoPath(trashed)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// trashDirs returns the trash directories relevant for the given working
+// directory, which are the home trash and the trash directories on the mount
+// point of the working directory.
+func trashDirs(wd string) []string {
+	dirs := []string{homeTrashDir()}
+
+	dev, err := deviceID(wd)
+	if err != nil {
+		return dirs
+	}
+
+	if homeDev, err := deviceID(dirs[0]); err == nil && homeDev == dev {
+		return dirs
+	}
+
+	shared, private := topTrashDirs(mountTopDir(wd, dev))
+	if shared != "" {
+		dirs = append(dirs, shared)
+	}
+	return append(dirs, private)
+}
+
+func readTrashDir(trashDir string) ([]trashEntry, error) {
+	infoDir := filepath.Join(trashDir, "info")
+	names, err := os.ReadDir(infoDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	topdir := trashTopDir(trashDir)
+
+	var entries []trashEntry
+	for _, name := range names {
+		file, ok := strings.CutSuffix(name.Name(), ".trashinfo")
+		if !ok {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(infoDir, name.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		path, date, err := parseTrashInfo(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name.Name(), err)
+		}
+		if topdir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(topdir, path)
+		}
+
+		entries = append(entries, trashEntry{
+			path:    path,
+			trashed: filepath.Join(trashDir, "files", file),
+			date:    date,
+		})
+	}
+
+	return entries, nil
+}
+
+// readTrash returns the entries in the trash directories for the given working
+// directory sorted by their deletion dates.
+func readTrash(wd string) ([]trashEntry, error) {
+	var entries []trashEntry
+	var errs []error
+
+	for _, dir := range trashDirs(wd) {
+		e, err := readTrashDir(dir)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		entries = append(entries, e...)
+	}
+
+	slices.SortStableFunc(entries, func(a, b trashEntry) int {
+		return a.date.Compare(b.date)
+	})
+
+	return entries, errors.Join(errs...)
+}
+
+// emptyTrash permanently removes all entries in the trash directories for the
+// given working directory.
+func emptyTrash(wd string) error {
+	var errs []error
+
+	for _, dir := range trashDirs(wd) {
+		for _, sub := range []string{"files", "info"} {
+			names, err := os.ReadDir(filepath.Join(dir, sub))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, name := range names {
+				if err := os.RemoveAll(filepath.Join(dir, sub, name.Name())); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
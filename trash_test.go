@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTrashInfo(t *testing.T) {
+	date := time.Date(2004, 8, 31, 22, 32, 8, 0, time.Local)
+
+	tests := []struct {
+		s    string
+		path string
+		date time.Time
+		err  bool
+	}{
+		{"[Trash Info]\nPath=/home/user/foo\nDeletionDate=2004-08-31T22:32:08\n", "/home/user/foo", date, false},
+		{"[Trash Info]\nPath=foo%20bar/%25baz\nDeletionDate=2004-08-31T22:32:08\n", "foo bar/%baz", date, false},
+		{"# comment\n\n[Trash Info]\nDeletionDate=2004-08-31T22:32:08\nPath=/foo\n", "/foo", date, false},
+		{"[Other]\nPath=/bar\n[Trash Info]\nPath=/foo\n", "/foo", time.Time{}, false},
+		{"[Trash Info]\nDeletionDate=2004-08-31T22:32:08\n", "", time.Time{}, true},
+		{"[Trash Info]\nPath=/foo\nDeletionDate=yesterday\n", "", time.Time{}, true},
+		{"Path=/foo\n", "", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		path, date, err := parseTrashInfo(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("at input '%q' expected error", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("at input '%q' unexpected error: %s", test.s, err)
+			continue
+		}
+		if path != test.path || !date.Equal(test.date) {
+			t.Errorf("at input '%q' expected '%s' '%v' but got '%s' '%v'", test.s, test.path, test.date, path, date)
+		}
+	}
+}
+
+func TestFormatTrashInfo(t *testing.T) {
+	date := time.Date(2004, 8, 31, 22, 32, 8, 0, time.Local)
+
+	paths := []string{
+		"/home/user/foo",
+		"/home/user/foo bar",
+		"relative/100%/ü",
+	}
+
+	for _, p := range paths {
+		got, gotDate, err := parseTrashInfo(formatTrashInfo(p, date))
+		if err != nil {
+			t.Errorf("at input '%s' unexpected error: %s", p, err)
+			continue
+		}
+		if got != p || !gotDate.Equal(date) {
+			t.Errorf("at input '%s' expected round trip but got '%s' '%v'", p, got, gotDate)
+		}
+	}
+}
+
+func TestTrashFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("trash is not supported on windows")
+	}
+
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+
+	oldFmt := gOpts.dupfilefmt
+	gOpts.dupfilefmt = "%f.~%n~"
+	defer func() { gOpts.dupfilefmt = oldFmt }()
+
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "foo"), filepath.Join(dir, "sub", "foo")}
+	for i, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strconv.Itoa(i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filesDir := filepath.Join(data, "Trash", "files")
+	exps := []string{filepath.Join(filesDir, "foo"), filepath.Join(filesDir, "foo.~1~")}
+
+	var trashed []string
+	for i, path := range paths {
+		got, err := trashFile(path)
+		if err != nil {
+			t.Fatalf("at input '%s' unexpected error: %s", path, err)
+		}
+		if got != exps[i] {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", path, exps[i], got)
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("at input '%s' expected file to be moved", path)
+		}
+
+		b, err := os.ReadFile(filepath.Join(data, "Trash", "info", filepath.Base(got)+".trashinfo"))
+		if err != nil {
+			t.Errorf("at input '%s' unexpected error: %s", path, err)
+		} else if info, _, err := parseTrashInfo(string(b)); err != nil || info != path {
+			t.Errorf("at input '%s' expected trash info path '%s' but got '%s' (%v)", path, path, info, err)
+		}
+
+		trashed = append(trashed, got)
+	}
+
+	for i, path := range paths {
+		if err := untrashFile(trashed[i], path); err != nil {
+			t.Fatalf("at input '%s' unexpected error: %s", path, err)
+		}
+		if b, err := os.ReadFile(path); err != nil || string(b) != strconv.Itoa(i) {
+			t.Errorf("at input '%s' expected restored content '%d' but got '%s' (%v)", path, i, b, err)
+		}
+		if _, err := os.Lstat(trashInfoPath(trashed[i])); !os.IsNotExist(err) {
+			t.Errorf("at input '%s' expected trash info to be removed", path)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// In the tree layout, directories in the current column can be expanded inline
+// to show their contents below them. Expanded entries are named with their
+// paths relative to the directory similar to flattened directories, and they
+// are ordered hierarchically after sorting. The expanded paths are kept for
+// each directory and looked up when the directory is loaded, which happens in
+// the background.
+var gTree = struct {
+	sync.Mutex
+	expanded map[string]map[string]bool
+}{expanded: make(map[string]map[string]bool)}
+
+func isTreeLayout() bool {
+	return gOpts.layout == "tree"
+}
+
+func getTreeExpanded(path string) map[string]bool {
+	gTree.Lock()
+	defer gTree.Unlock()
+
+	expanded := make(map[string]bool, len(gTree.expanded[path]))
+	for name := range gTree.expanded[path] {
+		expanded[name] = true
+	}
+	return expanded
+}
+
+func isTreeExpanded(path, name string) bool {
+	gTree.Lock()
+	defer gTree.Unlock()
+
+	return gTree.expanded[path][name]
+}
+
+// setTreeExpanded expands or collapses the given entry of a directory.
+// Collapsing an entry also collapses all entries below it.
+func setTreeExpanded(path, name string, expand bool) {
+	gTree.Lock()
+	defer gTree.Unlock()
+
+	if expand {
+		if gTree.expanded[path] == nil {
+			gTree.expanded[path] = make(map[string]bool)
+		}
+		gTree.expanded[path][name] = true
+		return
+	}
+
+	deletePathRecursive(gTree.expanded[path], name)
+	if len(gTree.expanded[path]) == 0 {
+		delete(gTree.expanded, path)
+	}
+}
+
+// clearTree collapses all entries and returns the directories which had
+// expanded entries.
+func clearTree() []string {
+	gTree.Lock()
+	defer gTree.Unlock()
+
+	var paths []string
+	for path := range gTree.expanded {
+		paths = append(paths, path)
+	}
+	clear(gTree.expanded)
+	return paths
+}
+
+// readdirTree reads the files in the directory at path along with the files in
+// its expanded subdirectories.
+func readdirTree(path string, expanded map[string]bool) ([]*file, error) {
+	files, err := readdir(path)
+	if err != nil {
+		return files, err
+	}
+
+	var walk func(name string)
+	walk = func(name string) {
+		children, err := readdir(filepath.Join(path, name))
+		if err != nil {
+			log.Printf("reading directory: %s", err)
+		}
+		for _, f := range children {
+			rel := filepath.Join(name, f.Name())
+			f.FileInfo = &flatInfo{FileInfo: f.FileInfo, name: rel}
+			files = append(files, f)
+			if f.IsDir() && expanded[rel] {
+				walk(rel)
+			}
+		}
+	}
+
+	for _, f := range files[:len(files):len(files)] {
+		if f.IsDir() && expanded[f.Name()] {
+			walk(f.Name())
+		}
+	}
+
+	return files, nil
+}
+
+// treeOrder reorders the sorted files so that each file is placed below its
+// parent directory, keeping the sorted order among siblings. Files whose
+// parent directory is not in the list (e.g. due to a filter) are placed below
+// their closest listed ancestor.
+func treeOrder(files []*file) []*file {
+	listed := make(map[string]bool, len(files))
+	for _, f := range files {
+		listed[f.Name()] = true
+	}
+
+	children := make(map[string][]*file)
+	for _, f := range files {
+		parent := filepath.Dir(f.Name())
+		for parent != "." && !listed[parent] {
+			parent = filepath.Dir(parent)
+		}
+		children[parent] = append(children[parent], f)
+	}
+
+	ordered := make([]*file, 0, len(files))
+	var walk func(name string)
+	walk = func(name string) {
+		for _, f := range children[name] {
+			ordered = append(ordered, f)
+			walk(f.Name())
+		}
+	}
+	walk(".")
+
+	return ordered
+}
+
+// treeDepth returns the nesting level of a file in the tree, which is used for
+// indentation.
+func treeDepth(f *file) int {
+	return strings.Count(f.Name(), string(filepath.Separator))
+}
+
+// reloadTree reloads the given directory in the background after its
+// expanded entries are changed.
+func (nav *nav) reloadTree(d *dir) {
+	d.loading = true
+	go func() {
+		nav.dirChan <- newDir(d.path)
+	}()
+}
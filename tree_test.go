@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTreeOrder(t *testing.T) {
+	tests := []struct {
+		names []string
+		exp   []string
+	}{
+		{
+			[]string{"a", "b", "c"},
+			[]string{"a", "b", "c"},
+		},
+		{
+			[]string{"a", "b", "a/x", "a/y", "b/z"},
+			[]string{"a", "a/x", "a/y", "b", "b/z"},
+		},
+		{
+			[]string{"b", "a", "a/y", "a/x", "a/y/z"},
+			[]string{"b", "a", "a/y", "a/y/z", "a/x"},
+		},
+		{
+			[]string{"a", "a/b/c", "d/e"},
+			[]string{"a", "a/b/c", "d/e"},
+		},
+	}
+
+	for _, test := range tests {
+		var files []*file
+		for _, name := range test.names {
+			files = append(files, &file{FileInfo: &fakeStat{name: filepath.FromSlash(name)}})
+		}
+
+		var got []string
+		for _, f := range treeOrder(files) {
+			got = append(got, filepath.ToSlash(f.Name()))
+		}
+
+		if !slices.Equal(got, test.exp) {
+			t.Errorf("at input '%v' expected '%v' but got '%v'", test.names, test.exp, got)
+		}
+	}
+}
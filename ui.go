@@ -170,15 +170,26 @@ func infotimefmt(t time.Time) string {
 	return t.Format(gOpts.infotimefmtold)
 }
 
-func fileInfo(f *file, d *dir, userWidth, groupWidth, customWidth int) (string, string, int) {
+// infoOverlay is a part of the info column printed separately over the space
+// reserved for it, either to allow escape sequences or to use its own style.
+type infoOverlay struct {
+	off int
+	s   string
+	st  *tcell.Style
+}
+
+func fileInfo(f *file, d *dir, git *gitStatus, sm styleMap, userWidth, groupWidth, customWidth int) (string, []infoOverlay) {
 	var info strings.Builder
-	var custom string
-	var off int
+	var overlays []infoOverlay
+
+	if d.du {
+		info.WriteString(duBar(duSize(f), d.duTotal))
+	}
 
 	for _, s := range getInfo(d.path) {
 		switch s {
 		case "size":
-			if f.IsDir() && getDirCounts(d.path) {
+			if f.IsDir() && getDirCounts(d.path) && !d.du {
 				switch {
 				case f.dirCount < 0:
 					info.WriteString("     !")
@@ -218,21 +229,36 @@ func fileInfo(f *file, d *dir, userWidth, groupWidth, customWidth int) (string,
 			}
 			// To allow for the usage of escape sequences, store `custom`
 			// separately and print it later using the offset.
-			off = info.Len()
+			custom := fmt.Sprintf(" %s%*s", f.customInfo, customWidth-printLength(f.customInfo), "")
+			overlays = append(overlays, infoOverlay{info.Len(), custom, nil})
 			fmt.Fprintf(&info, " %*s", customWidth, "")
-			custom = fmt.Sprintf(" %s%*s", f.customInfo, customWidth-printLength(f.customInfo), "")
+		case "git":
+			var state byte
+			if git != nil {
+				state = git.state(filepath.Join(d.path, f.Name()), f.IsDir())
+			}
+			if state == 0 {
+				info.WriteString("  ")
+				continue
+			}
+			if st, ok := sm.styles[gitStyleKeys[state]]; ok {
+				overlays = append(overlays, infoOverlay{info.Len(), " " + string(state), &st})
+			}
+			info.WriteString(" " + string(state))
 		default:
 			log.Printf("unknown info type: %s", s)
 		}
 	}
 
-	return info.String(), custom, off
+	return info.String(), overlays
 }
 
 type dirContext struct {
 	selections map[string]int
 	clipboard  clipboard
 	tags       map[string]string
+	git        func(string) *gitStatus
+	compare    map[string]compareState
 }
 
 // dirRole describes what kind of directory pane is being drawn.
@@ -294,6 +320,7 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 
 	var userWidth, groupWidth, customWidth int
 	var fetchedCustom bool
+	var git *gitStatus
 
 	// Only fetch user/group/custom widths if configured to display them
 	for _, s := range getInfo(dir.path) {
@@ -305,6 +332,10 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 		case "custom":
 			customWidth = getCustomWidth(dir, beg, end)
 			fetchedCustom = true // Can have a length of 0
+		case "git":
+			if context.git != nil {
+				git = context.git(dir.path)
+			}
 		}
 
 		if userWidth > 0 && groupWidth > 0 && fetchedCustom {
@@ -320,7 +351,13 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 
 	visualSelections := dir.visualSelections()
 	for i, f := range dir.files[beg:end] {
-		st := dirStyle.colors.get(f)
+		base := baseFile(f)
+		st := dirStyle.colors.get(base)
+		if state, ok := context.compare[f.path]; ok {
+			if cst, ok := dirStyle.colors.compareStyle(state); ok {
+				st = cst
+			}
+		}
 
 		if lnwidth > 0 {
 			var ln string
@@ -365,6 +402,8 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 		tag := " "
 		if val, ok := context.tags[path]; ok && len(val) > 0 {
 			tag = val
+		} else if val := duplicateTag(f); val != "" {
+			tag = val
 		}
 
 		if fmtStr != "" {
@@ -381,10 +420,17 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 		var icon string
 		var iconDef iconDef
 		if gOpts.icons {
-			iconDef = dirStyle.icons.get(f)
+			iconDef = dirStyle.icons.get(base)
 			icon = iconDef.icon + " "
 		}
 
+		// files in expanded directories are indented below their parents
+		name := f
+		if dir.tree {
+			icon = strings.Repeat("  ", treeDepth(f)) + icon
+			name = base
+		}
+
 		// subtract space for icon
 		maxFilenameWidth := maxWidth - displaywidth.String(icon)
 		// subtract space for tag if not merged with selection marker
@@ -392,22 +438,25 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 			maxFilenameWidth--
 		}
 
-		info, custom, customOff := fileInfo(f, dir, userWidth, groupWidth, customWidth)
+		info, overlays := fileInfo(f, dir, git, dirStyle.colors, userWidth, groupWidth, customWidth)
 		infolen := len(info)
 		showInfo := infolen > 0 && 2*infolen < maxWidth
 		if showInfo {
 			maxFilenameWidth -= infolen
 		}
 
-		filename := truncateFilename(f, maxFilenameWidth, gOpts.truncatepct, gOpts.truncatechar)
+		filename := truncateFilename(name, maxFilenameWidth, gOpts.truncatepct, gOpts.truncatechar)
 		spacing := maxFilenameWidth - displaywidth.String(filename)
 		if spacing > 0 {
 			filename += strings.Repeat(" ", spacing)
 		}
 
+		var infoOff int
 		if showInfo {
 			filename += info
-			customOff += nameOff + displaywidth.String(icon) + maxFilenameWidth
+			infoOff = nameOff + displaywidth.String(icon) + maxFilenameWidth
+		} else {
+			overlays = nil
 		}
 
 		if i == dir.pos {
@@ -428,9 +477,9 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 
 			win.print(ui.screen, nameOff, i, st, fmt.Sprintf(cursorFmt, icon+filename+" "))
 
-			// print over the empty space we reserved for the custom info
-			if showInfo && custom != "" {
-				win.print(ui.screen, customOff, i, st, fmt.Sprintf(cursorFmt, stripTermSequence(custom)))
+			// print over the empty space we reserved for the custom and git info
+			for _, ov := range overlays {
+				win.print(ui.screen, infoOff+ov.off, i, st, fmt.Sprintf(cursorFmt, stripTermSequence(ov.s)))
 			}
 		} else {
 			if !gOpts.mergeindicators || fmtStr == "" {
@@ -452,9 +501,13 @@ func (win *win) printDir(ui *ui, dir *dir, context *dirContext, dirStyle *dirSty
 
 			win.print(ui.screen, nameOff+displaywidth.String(icon), i, st, filename+" ")
 
-			// print over the empty space we reserved for the custom info
-			if showInfo && custom != "" {
-				win.print(ui.screen, customOff, i, st, custom)
+			// print over the empty space we reserved for the custom and git info
+			for _, ov := range overlays {
+				ovStyle := st
+				if ov.st != nil {
+					ovStyle = *ov.st
+				}
+				win.print(ui.screen, infoOff+ov.off, i, ovStyle, ov.s)
 			}
 		}
 	}
@@ -490,17 +543,29 @@ func getCustomWidth(dir *dir, beg, end int) int {
 	return maxw
 }
 
-func getWins(screen tcell.Screen) []*win {
+func getWins(screen tcell.Screen, tabbar bool) []*win {
 	wtot, htot := screen.Size()
 
 	h := max(htot-2, 0)
 	x, y := 0, 1
+	if tabbar {
+		h = max(h-1, 0)
+		y++
+	}
 	if gOpts.drawbox && gOpts.borderstyle&borderOutline != 0 {
-		h = max(htot-4, 0)
-		x, y = 1, 2
+		h = max(h-2, 0)
+		x, y = 1, y+1
 	}
 
-	widths := getWidths(wtot, gOpts.ratios, gOpts.drawbox, gOpts.borderstyle)
+	ratios := gOpts.ratios
+	if isDualLayout() {
+		// leave a line above the panes for their headers
+		ratios = []int{1, 1}
+		h = max(h-1, 0)
+		y++
+	}
+
+	widths := getWidths(wtot, ratios, gOpts.drawbox, gOpts.borderstyle)
 	wins := make([]*win, 0, len(widths))
 	for _, w := range widths {
 		wins = append(wins, newWin(w, h, x, y))
@@ -520,6 +585,7 @@ type ui struct {
 	sxScreen    sixelScreen        // sixel preview state
 	wins        []*win             // pane windows from `ratios` (last is `preview` when enabled)
 	promptWin   *win               // prompt line window
+	tabWin      *win               // tab bar window (shown with multiple tabs)
 	msgWin      *win               // status line window
 	menuWin     *win               // menu window
 	msg         string             // message/output shown in msgWin
@@ -539,6 +605,7 @@ type ui struct {
 	rulerErr    error              // `rulerfile` parse error (if any)
 	currentFile string             // last path passed to `on-select`
 	pasteEvent  bool               // whether paste event is active (to ignore pasted input in Normal mode)
+	tabbar      bool               // whether the tab bar is shown
 }
 
 func newUI(screen tcell.Screen) *ui {
@@ -546,8 +613,9 @@ func newUI(screen tcell.Screen) *ui {
 
 	ui := &ui{
 		screen:      screen,
-		wins:        getWins(screen),
+		wins:        getWins(screen, false),
 		promptWin:   newWin(wtot, 1, 0, 0),
+		tabWin:      newWin(wtot, 1, 0, 1),
 		msgWin:      newWin(wtot, 1, 0, htot-1),
 		menuWin:     newWin(wtot, 1, 0, htot-2),
 		exprChan:    make(chan expr, 1000),
@@ -572,10 +640,11 @@ func (ui *ui) winAt(x, y int) (int, *win) {
 }
 
 func (ui *ui) renew() {
-	ui.wins = getWins(ui.screen)
+	ui.wins = getWins(ui.screen, ui.tabbar)
 
 	wtot, htot := ui.screen.Size()
 	ui.promptWin.renew(wtot, 1, 0, 0)
+	ui.tabWin.renew(wtot, 1, 0, 1)
 	ui.msgWin.renew(wtot, 1, 0, htot-1)
 	ui.menuWin.renew(wtot, 1, 0, htot-2)
 }
@@ -629,7 +698,11 @@ func (ui *ui) loadFile(app *app, volatile bool) {
 		onSelect(app)
 	}
 
-	if !gOpts.preview {
+	if !previewEnabled() {
+		return
+	}
+
+	if grepMatches(curr) != nil {
 		return
 	}
 
@@ -655,7 +728,7 @@ func (ui *ui) drawPromptLine(nav *nav) {
 
 	var fname string
 	if curr := nav.currFile(); curr != nil {
-		fname = sanitizeName(filepath.Base(curr.path))
+		fname = sanitizeName(curr.Name())
 	}
 
 	var prompt string
@@ -768,6 +841,21 @@ func (ui *ui) drawStat(nav *nav) {
 	ui.msgWin.print(ui.screen, 0, 0, tcell.StyleDefault, fileInfo.String())
 }
 
+// drawTabBar draws the numbered names of the open tabs, highlighting the current one.
+func (ui *ui) drawTabBar(nav *nav) {
+	st := tcell.StyleDefault
+	x := 0
+	for i, path := range nav.tabPaths() {
+		label := sanitizeName(fmt.Sprintf(" %d:%s ", i+1, tabName(path)))
+		if i == nav.tabInd {
+			ui.tabWin.print(ui.screen, x, 0, st.Reverse(true), label)
+		} else {
+			ui.tabWin.print(ui.screen, x, 0, st, label)
+		}
+		x += displaywidth.String(label)
+	}
+}
+
 // Deprecated: Will eventually be replaced by drawRulerFile
 func (ui *ui) drawRuler(nav *nav) {
 	st := tcell.StyleDefault
@@ -1007,6 +1095,9 @@ func (ui *ui) drawRulerFile(nav *nav) {
 		Options:          options,
 		UserOptions:      gOpts.user,
 		Stat:             stat,
+		Tabs:             nav.tabPaths(),
+		Tab:              nav.tabInd + 1,
+		git:              nav.gitData,
 	}
 
 	left, right, err := renderRuler(ui.ruler, data, ui.msgWin.w)
@@ -1029,8 +1120,11 @@ func (ui *ui) drawPreview(nav *nav, context *dirContext) {
 	win := ui.wins[len(ui.wins)-1]
 	ui.sxScreen.clearSixel(win, ui.screen, curr.path)
 
-	if gOpts.preview {
-		if curr.isPreviewable() {
+	if previewEnabled() {
+		if matches := grepMatches(curr); matches != nil {
+			ui.sxScreen.lastFile = ""
+			win.printGrep(ui.screen, matches)
+		} else if curr.isPreviewable() {
 			reg, ok := nav.regCache[curr.path]
 			if !ok {
 				// the shown file can lose its cache entry, e.g. a save that deletes and recreates it
@@ -1052,25 +1146,31 @@ func (ui *ui) drawBox() {
 	w, h := ui.screen.Size()
 	style := gOpts.borderstyle
 
+	// first row below the prompt line and the tab bar
+	y0 := 1
+	if ui.tabbar {
+		y0 = 2
+	}
+
 	if style&borderOutline != 0 {
 		for i := 1; i < w-1; i++ {
-			ui.screen.PutStrStyled(i, 1, string(tcell.RuneHLine), st)
+			ui.screen.PutStrStyled(i, y0, string(tcell.RuneHLine), st)
 			ui.screen.PutStrStyled(i, h-2, string(tcell.RuneHLine), st)
 		}
 
-		for i := 2; i < h-2; i++ {
+		for i := y0 + 1; i < h-2; i++ {
 			ui.screen.PutStrStyled(0, i, string(tcell.RuneVLine), st)
 			ui.screen.PutStrStyled(w-1, i, string(tcell.RuneVLine), st)
 		}
 
 		if style&borderRound != 0 {
-			ui.screen.PutStrStyled(0, 1, "╭", st)
-			ui.screen.PutStrStyled(w-1, 1, "╮", st)
+			ui.screen.PutStrStyled(0, y0, "╭", st)
+			ui.screen.PutStrStyled(w-1, y0, "╮", st)
 			ui.screen.PutStrStyled(0, h-2, "╰", st)
 			ui.screen.PutStrStyled(w-1, h-2, "╯", st)
 		} else {
-			ui.screen.PutStrStyled(0, 1, string(tcell.RuneULCorner), st)
-			ui.screen.PutStrStyled(w-1, 1, string(tcell.RuneURCorner), st)
+			ui.screen.PutStrStyled(0, y0, string(tcell.RuneULCorner), st)
+			ui.screen.PutStrStyled(w-1, y0, string(tcell.RuneURCorner), st)
 			ui.screen.PutStrStyled(0, h-2, string(tcell.RuneLLCorner), st)
 			ui.screen.PutStrStyled(w-1, h-2, string(tcell.RuneLRCorner), st)
 		}
@@ -1080,15 +1180,15 @@ func (ui *ui) drawBox() {
 		return
 	}
 
-	top, bot := 1, h-1
+	top, bot := y0, h-1
 	if style&borderOutline != 0 {
-		top, bot = 2, h-2
+		top, bot = y0+1, h-2
 	}
 
 	for wind := range len(ui.wins) - 1 {
 		x := ui.wins[wind].x + ui.wins[wind].w
 		if style&borderOutline != 0 {
-			ui.screen.PutStrStyled(x, 1, string(tcell.RuneTTee), st)
+			ui.screen.PutStrStyled(x, y0, string(tcell.RuneTTee), st)
 		}
 		for y := top; y < bot; y++ {
 			ui.screen.PutStrStyled(x, y, string(tcell.RuneVLine), st)
@@ -1132,6 +1232,13 @@ func (ui *ui) drawMenu() {
 }
 
 func (ui *ui) dirOfWin(nav *nav, wind int) *dir {
+	if isDualLayout() {
+		if wind == nav.paneInd {
+			return nav.currDir()
+		}
+		return nil
+	}
+
 	wins := len(ui.wins)
 	if gOpts.preview {
 		wins--
@@ -1145,24 +1252,32 @@ func (ui *ui) dirOfWin(nav *nav, wind int) *dir {
 
 func (ui *ui) draw(nav *nav) {
 	st := tcell.StyleDefault
-	context := dirContext{selections: nav.selections, clipboard: nav.clipboard, tags: nav.tags}
+	context := dirContext{selections: nav.selections, clipboard: nav.clipboard, tags: nav.tags, git: nav.gitStatus, compare: nav.compareStates()}
 
 	ui.screen.Clear()
 
 	ui.drawPromptLine(nav)
 
-	wins := len(ui.wins)
-	if gOpts.preview {
-		wins--
+	if ui.tabbar {
+		ui.drawTabBar(nav)
 	}
-	for i := range wins {
-		role := Parent
-		if i == wins-1 {
-			role = Active
-		}
-		if dir := ui.dirOfWin(nav, i); dir != nil {
-			dirStyle := &dirStyle{colors: ui.styles, icons: ui.icons, role: role}
-			ui.wins[i].printDir(ui, dir, &context, dirStyle, nav.previewTimer)
+
+	if isDualLayout() {
+		ui.drawPanes(nav, &context)
+	} else {
+		wins := len(ui.wins)
+		if gOpts.preview {
+			wins--
+		}
+		for i := range wins {
+			role := Parent
+			if i == wins-1 {
+				role = Active
+			}
+			if dir := ui.dirOfWin(nav, i); dir != nil {
+				dirStyle := &dirStyle{colors: ui.styles, icons: ui.icons, role: role}
+				ui.wins[i].printDir(ui, dir, &context, dirStyle, nav.previewTimer)
+			}
 		}
 	}
 
@@ -1195,7 +1310,9 @@ func (ui *ui) draw(nav *nav) {
 		ui.screen.ShowCursor(ui.msgWin.x+displaywidth.String(prefix)+displaywidth.String(left), ui.msgWin.y)
 	}
 
-	ui.drawPreview(nav, &context)
+	if !isDualLayout() {
+		ui.drawPreview(nav, &context)
+	}
 
 	if gOpts.drawbox {
 		ui.drawBox()
@@ -1220,10 +1337,15 @@ func findBinds(keys map[string]expr, prefix string) (binds map[string]expr, exac
 	return binds, exact
 }
 
-func listBinds(binds map[string]map[string]expr) string {
-	t := new(tabwriter.Writer)
-	b := new(bytes.Buffer)
+// bindEntry is a key binding merged across modes, where mode contains the
+// modes of the binding in sorted order.
+type bindEntry struct {
+	Mode    string `json:"mode"`
+	Key     string `json:"key"`
+	Command string `json:"command"`
+}
 
+func bindEntries(binds map[string]map[string]expr) []bindEntry {
 	// merge keys by command across modes
 	m := make(map[string]map[string]string)
 	for mode, keys := range binds {
@@ -1235,31 +1357,34 @@ func listBinds(binds map[string]map[string]expr) string {
 		}
 	}
 
-	type entry struct {
-		mode, key, cmd string
-	}
-
 	// collect normalized entries
-	var entries []entry
+	entries := []bindEntry{}
 	for key, cmds := range m {
 		for cmd, modes := range cmds {
 			tmp := []rune(modes)
 			slices.Sort(tmp)
-			entries = append(entries, entry{string(tmp), key, cmd})
+			entries = append(entries, bindEntry{string(tmp), key, cmd})
 		}
 	}
 
-	slices.SortFunc(entries, func(a, b entry) int {
-		if c := cmp.Compare(a.key, b.key); c != 0 {
+	slices.SortFunc(entries, func(a, b bindEntry) int {
+		if c := cmp.Compare(a.Key, b.Key); c != 0 {
 			return c
 		}
-		return cmp.Compare(a.mode, b.mode)
+		return cmp.Compare(a.Mode, b.Mode)
 	})
 
+	return entries
+}
+
+func listBinds(binds map[string]map[string]expr) string {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
 	t.Init(b, 0, gOpts.tabstop, 2, '\t', 0)
 	fmt.Fprintln(t, "mode\tkey\tcommand")
-	for _, e := range entries {
-		fmt.Fprintf(t, "%s\t%s\t%s\n", e.mode, e.key, e.cmd)
+	for _, e := range bindEntries(binds) {
+		fmt.Fprintf(t, "%s\t%s\t%s\n", e.Mode, e.Key, e.Command)
 	}
 	t.Flush()
 
@@ -1295,6 +1420,27 @@ func listCmds(cmds map[string]expr) string {
 	return b.String()
 }
 
+// cmdEntry is a user defined command.
+type cmdEntry struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+func cmdEntries(cmds map[string]expr) []cmdEntry {
+	entries := []cmdEntry{}
+	for _, k := range slices.Sorted(maps.Keys(cmds)) {
+		entries = append(entries, cmdEntry{k, cmds[k].String()})
+	}
+	return entries
+}
+
+// jumpEntries is the jump list in the order of visits with the index of the
+// current entry.
+type jumpEntries struct {
+	Paths []string `json:"paths"`
+	Index int      `json:"index"`
+}
+
 func listJumps(jumps []string, ind int) string {
 	t := new(tabwriter.Writer)
 	b := new(bytes.Buffer)
@@ -1349,6 +1495,45 @@ func listMarks(marks map[string]string) string {
 	return b.String()
 }
 
+func listTrash(entries []trashEntry) string {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	t.Init(b, 0, gOpts.tabstop, 2, '\t', 0)
+	fmt.Fprintln(t, "deleted\tpath")
+	for _, e := range entries {
+		fmt.Fprintf(t, "%s\t%s\n", e.date.Format(time.DateTime), sanitizeName(e.path))
+	}
+	t.Flush()
+
+	return b.String()
+}
+
+func listRenames(renames []journalEntry, wd string, maxLines int) string {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	rel := func(path string) string {
+		if r, err := filepath.Rel(wd, path); err == nil && filepath.IsLocal(r) {
+			return r
+		}
+		return path
+	}
+
+	t.Init(b, 0, gOpts.tabstop, 2, '\t', 0)
+	fmt.Fprintln(t, "old\t\tnew")
+	for i, r := range renames {
+		if maxLines > 0 && i >= maxLines-1 && len(renames) > maxLines {
+			fmt.Fprintf(t, "... %d more\t\t\n", len(renames)-i)
+			break
+		}
+		fmt.Fprintf(t, "%s\t->\t%s\n", sanitizeName(rel(r.src)), sanitizeName(rel(r.dst)))
+	}
+	t.Flush()
+
+	return b.String()
+}
+
 func listFilesInCurrDir(nav *nav) string {
 	dir := nav.currDir()
 	if dir.loading {
@@ -1367,6 +1552,22 @@ func listFilesInCurrDir(nav *nav) string {
 	return b.String()
 }
 
+// filesInCurrDir returns the paths of the files in the current directory, or
+// nil if the directory is still loading. Unlike listFilesInCurrDir, paths
+// containing newlines are included.
+func filesInCurrDir(nav *nav) []string {
+	dir := nav.currDir()
+	if dir.loading {
+		return nil
+	}
+
+	files := []string{}
+	for _, file := range dir.files {
+		files = append(files, file.path)
+	}
+	return files
+}
+
 // readNormalEvent is used to read a normal event on the client side. For keys,
 // digits are interpreted as command counts but this is only done for digits
 // preceding any non-digit characters (e.g. "42y2k" as 42 times "y2k").
@@ -1496,6 +1697,10 @@ func (ui *ui) readNormalEvent(ev tcell.Event, nav *nav) expr {
 			return nil
 		}
 
+		if isDualLayout() && wind != nav.paneInd {
+			return &callExpr{"pane-switch", nil, 1}
+		}
+
 		var dir *dir
 		if gOpts.preview && wind == len(ui.wins)-1 {
 			curr := nav.currFile()
@@ -1630,7 +1835,18 @@ func listMatches(screen tcell.Screen, matches []compMatch, selectedInd int) (str
 
 	names := make([]string, len(matches))
 	for i, m := range matches {
-		names[i] = sanitizeName(m.name)
+		names[i] = m.name
+	}
+
+	return listColumns(screen, "possible matches", names, selectedInd)
+}
+
+// listColumns renders the given entries in columns fitting the screen width
+// below a header line, as used for the completion menu.
+func listColumns(screen tcell.Screen, header string, entries []string, selectedInd int) (string, *menuSelect) {
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = sanitizeName(e)
 	}
 
 	wtot, _ := screen.Size()
@@ -1642,7 +1858,7 @@ func listMatches(screen tcell.Screen, matches []compMatch, selectedInd int) (str
 	ncol := max(wtot/wcol, 1)
 
 	var b strings.Builder
-	b.WriteString("possible matches")
+	b.WriteString(header)
 
 	for i, n := range names {
 		if i%ncol == 0 {
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djherbis/times"
+	"github.com/pkg/sftp"
+)
+
+// A fileSystem provides access to the files below a path. Local paths are
+// handled by the operating system, whereas remote paths such as
+// `sftp://host/path` are handled by the backend of their scheme. The
+// methods take full paths as used by lf in both cases.
+type fileSystem interface {
+	Lstat(name string) (os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	Readdirnames(name string, n int) ([]string, error)
+	Readlink(name string) (string, error)
+	Symlink(oldname, newname string) error
+	Open(name string) (io.ReadCloser, error)
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// errCrossFS is returned when renaming files between different file systems,
+// in which case they need to be copied instead.
+var errCrossFS = errors.New("cannot rename across file systems")
+
+// getFS returns the file system of the given path. Connections to remote
+// hosts are opened on first use, and errors are returned by the methods of
+// the resulting file system.
+func getFS(path string) fileSystem {
+	host, _, ok := splitRemote(path)
+	if !ok {
+		return localFS{}
+	}
+
+	fsys, err := connectSFTP(host)
+	if err != nil {
+		return errFS{err}
+	}
+	return fsys
+}
+
+// gRemoteChecks holds the remote paths being checked for modifications in
+// the background, so that only one check runs at a time for each path.
+var gRemoteChecks = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// checkRemote calls fn with the information of a remote file in the
+// background, since getting it requires a round trip to the host and should
+// not block the UI. Paths which are still being checked are skipped.
+func checkRemote(path string, fn func(s os.FileInfo)) {
+	gRemoteChecks.Lock()
+	defer gRemoteChecks.Unlock()
+
+	if gRemoteChecks.paths[path] {
+		return
+	}
+	gRemoteChecks.paths[path] = true
+
+	go func() {
+		defer func() {
+			gRemoteChecks.Lock()
+			delete(gRemoteChecks.paths, path)
+			gRemoteChecks.Unlock()
+		}()
+
+		s, err := getFS(path).Stat(path)
+		if err != nil {
+			log.Printf("checking remote file: %s", err)
+			return
+		}
+		fn(s)
+	}()
+}
+
+// isRemote reports whether the given path is on a remote host.
+func isRemote(path string) bool {
+	_, _, ok := splitRemote(path)
+	return ok
+}
+
+// splitRemote splits a remote path into the host and the absolute slash
+// separated path on the host. Both `sftp://host/path` and `sftp:/host/path`
+// are accepted, since the latter is the result of cleaning the former.
+func splitRemote(path string) (host, rpath string, ok bool) {
+	path = filepath.ToSlash(path)
+	rest, ok := strings.CutPrefix(path, "sftp:/")
+	if !ok {
+		return "", "", false
+	}
+	host, rpath, _ = strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	if host == "" {
+		return "", "", false
+	}
+	return host, "/" + rpath, true
+}
+
+// isRemoteRoot reports whether the given path is the root directory of a
+// remote host.
+func isRemoteRoot(path string) bool {
+	_, rpath, ok := splitRemote(path)
+	return ok && strings.Trim(rpath, "/") == ""
+}
+
+// absPath returns an absolute representation of the given path, where remote
+// paths are only cleaned since they are always absolute.
+func absPath(path string) (string, error) {
+	path = replaceTilde(path)
+	if isRemote(path) {
+		return filepath.Clean(path), nil
+	}
+	return filepath.Abs(path)
+}
+
+// renamePath renames oldname to newname, returning an error satisfying
+// [errCrossDevice] if they are on different file systems.
+func renamePath(oldname, newname string) error {
+	oldHost, _, _ := splitRemote(oldname)
+	newHost, _, _ := splitRemote(newname)
+	if oldHost != newHost {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errCrossFS}
+	}
+	return getFS(oldname).Rename(oldname, newname)
+}
+
+// tempPath returns a random path next to dst for writing it before renaming.
+func tempPath(dst string) string {
+	return filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.lf-partial-%s", filepath.Base(dst), rand.Text()[:12]))
+}
+
+// createTemp creates a new file next to dst with a random name in the same way
+// as [os.CreateTemp], but with the given permissions so that the umask is
+// respected, and returns the file with its path.
+func createTemp(fsys fileSystem, dst string, perm os.FileMode) (io.WriteCloser, string, error) {
+	for range 100 {
+		tmp := tempPath(dst)
+		w, err := fsys.Create(tmp, perm)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return w, tmp, err
+	}
+	return nil, "", &os.PathError{Op: "createtemp", Path: dst, Err: os.ErrExist}
+}
+
+// walkFS walks the file tree rooted at root in the same way as
+// [filepath.Walk], calling fn for each file in lexical order.
+func walkFS(fsys fileSystem, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFSInfo(fsys, root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkFSInfo(fsys fileSystem, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	names, err := fsys.Readdirnames(path, -1)
+	slices.Sort(names)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := fsys.Lstat(filename)
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := walkFSInfo(fsys, filename, fileInfo, fn); err != nil {
+			if !fileInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fileTimes returns the access, birth and change times of a file, which
+// default to the modification time when they cannot be determined.
+func fileTimes(info os.FileInfo) (at, bt, ct time.Time) {
+	mt := info.ModTime()
+	switch sys := info.Sys().(type) {
+	case nil:
+		return mt, mt, mt
+	case *sftp.FileStat:
+		return time.Unix(int64(sys.Atime), 0), mt, mt
+	}
+
+	ts := times.Get(info)
+	at = ts.AccessTime()
+	// from [times.Timespec] docs:
+	// ChangeTime() panics unless HasChangeTime() is true and
+	// BirthTime() panics unless HasBirthTime() is true.
+
+	// default to ModTime if BirthTime cannot be determined
+	bt = mt
+	if ts.HasBirthTime() {
+		bt = ts.BirthTime()
+	}
+	// default to ModTime if ChangeTime cannot be determined
+	ct = mt
+	if ts.HasChangeTime() {
+		ct = ts.ChangeTime()
+	}
+	return at, bt, ct
+}
+
+type localFS struct{}
+
+func (localFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+func (localFS) Stat(name string) (os.FileInfo, error)  { return os.Stat(name) }
+func (localFS) Readlink(name string) (string, error)   { return os.Readlink(name) }
+func (localFS) Symlink(oldname, newname string) error  { return os.Symlink(oldname, newname) }
+func (localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (localFS) Readdirnames(name string, n int) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(n)
+}
+
+func (localFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func (localFS) MkdirAll(name string, perm os.FileMode) error { return os.MkdirAll(name, perm) }
+func (localFS) Rename(oldname, newname string) error         { return os.Rename(oldname, newname) }
+func (localFS) Remove(name string) error                     { return os.Remove(name) }
+func (localFS) RemoveAll(name string) error                  { return os.RemoveAll(name) }
+func (localFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// errFS is the file system of remote paths that cannot be accessed, which
+// returns the same error for all operations.
+type errFS struct {
+	err error
+}
+
+func (e errFS) Lstat(string) (os.FileInfo, error)          { return nil, e.err }
+func (e errFS) Stat(string) (os.FileInfo, error)           { return nil, e.err }
+func (e errFS) Readdirnames(string, int) ([]string, error) { return nil, e.err }
+func (e errFS) Readlink(string) (string, error)            { return "", e.err }
+func (e errFS) Symlink(string, string) error               { return e.err }
+func (e errFS) Open(string) (io.ReadCloser, error)         { return nil, e.err }
+func (e errFS) Create(string, os.FileMode) (io.WriteCloser, error) {
+	return nil, e.err
+}
+func (e errFS) MkdirAll(string, os.FileMode) error         { return e.err }
+func (e errFS) Rename(string, string) error                { return e.err }
+func (e errFS) Remove(string) error                        { return e.err }
+func (e errFS) RemoveAll(string) error                     { return e.err }
+func (e errFS) Chtimes(string, time.Time, time.Time) error { return e.err }
+
+// remoteError adds the operation and the path as shown by lf to an error of a
+// remote backend, which only refers to the path on the host if at all.
+func remoteError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}